@@ -100,6 +100,7 @@ func (s *Server) registerAPIs() {
 	group.Entries = append(group.Entries, s.objectAPIEntries()...)
 	group.Entries = append(group.Entries, s.metadataAPIEntries()...)
 	group.Entries = append(group.Entries, s.healthAPIEntries()...)
+	group.Entries = append(group.Entries, s.metricsAPIEntries()...)
 	group.Entries = append(group.Entries, s.aboutAPIEntries()...)
 	group.Entries = append(group.Entries, s.customDataAPIEntries()...)
 
@@ -0,0 +1,334 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+const (
+	// MetricsPath is the path of metrics in Prometheus text format.
+	MetricsPath = "/metrics"
+
+	metricsNamespace = "easegress"
+)
+
+type (
+	// statusCollector collects the metrics from the latest statuses
+	// record of StatusSyncController.
+	//
+	// NOTE: HTTPStat.Status resets part of its data on every call,
+	// so it can't be called on scraping, or it breaks the statuses
+	// synced to the cluster.
+	statusCollector struct {
+		super *supervisor.Supervisor
+
+		httpServerStat *httpStatDescs
+		proxyStat      *httpStatDescs
+		proxyHealthy   *prometheus.Desc
+		proxyEjected   *prometheus.Desc
+		filterStatus   *prometheus.Desc
+		filterInfo     *prometheus.Desc
+	}
+
+	httpStatDescs struct {
+		requests *prometheus.Desc
+		errors   *prometheus.Desc
+		reqSize  *prometheus.Desc
+		respSize *prometheus.Desc
+		codes    *prometheus.Desc
+		duration *prometheus.Desc
+	}
+)
+
+func (s *Server) metricsAPIEntries() []*Entry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		newStatusCollector(s.super),
+	)
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	return []*Entry{
+		{
+			Path:    MetricsPath,
+			Method:  "GET",
+			Handler: handler.ServeHTTP,
+		},
+	}
+}
+
+func newHTTPStatDescs(subsystem string, labels []string) *httpStatDescs {
+	newDesc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, subsystem, name),
+			help, labels, nil)
+	}
+
+	return &httpStatDescs{
+		requests: newDesc("requests_total", "Total number of requests.", labels),
+		errors:   newDesc("request_errors_total", "Total number of requests with status code 4xx or 5xx.", labels),
+		reqSize:  newDesc("request_size_bytes_total", "Total size of requests.", labels),
+		respSize: newDesc("response_size_bytes_total", "Total size of responses.", labels),
+		codes: newDesc("responses_total", "Total number of responses by status code.",
+			append(append([]string{}, labels...), "code")),
+		duration: newDesc("request_duration_milliseconds",
+			"Duration of requests, quantiles are of the latest status sync period.", labels),
+	}
+}
+
+func (d *httpStatDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.requests
+	ch <- d.errors
+	ch <- d.reqSize
+	ch <- d.respSize
+	ch <- d.codes
+	ch <- d.duration
+}
+
+func (d *httpStatDescs) collect(ch chan<- prometheus.Metric, s *httpstat.Status, labelValues ...string) {
+	if s == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(d.requests, prometheus.CounterValue, float64(s.Count), labelValues...)
+	ch <- prometheus.MustNewConstMetric(d.errors, prometheus.CounterValue, float64(s.ErrCount), labelValues...)
+	ch <- prometheus.MustNewConstMetric(d.reqSize, prometheus.CounterValue, float64(s.ReqSize), labelValues...)
+	ch <- prometheus.MustNewConstMetric(d.respSize, prometheus.CounterValue, float64(s.RespSize), labelValues...)
+
+	for code, count := range s.TotalCodes() {
+		codeLabelValues := append(append([]string{}, labelValues...), strconv.Itoa(code))
+		ch <- prometheus.MustNewConstMetric(d.codes, prometheus.CounterValue, float64(count), codeLabelValues...)
+	}
+
+	// NOTE: The sum is calculated from the mean, so it is approximate.
+	sum := float64(s.Mean) * float64(s.Count)
+	quantiles := map[float64]float64{
+		0.25:  s.P25,
+		0.5:   s.P50,
+		0.75:  s.P75,
+		0.95:  s.P95,
+		0.98:  s.P98,
+		0.99:  s.P99,
+		0.999: s.P999,
+	}
+	ch <- prometheus.MustNewConstSummary(d.duration, s.Count, sum, quantiles, labelValues...)
+}
+
+func newStatusCollector(super *supervisor.Supervisor) *statusCollector {
+	poolLabels := []string{"namespace", "pipeline", "filter", "pool"}
+	serverLabels := append(append([]string{}, poolLabels...), "server")
+	fieldLabels := []string{"namespace", "pipeline", "filter", "field"}
+
+	return &statusCollector{
+		super: super,
+
+		httpServerStat: newHTTPStatDescs("httpserver", []string{"namespace", "httpserver"}),
+		proxyStat:      newHTTPStatDescs("proxy", poolLabels),
+		proxyHealthy: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "proxy", "server_healthy"),
+			"Whether the server passes the health check, 1 for healthy.", serverLabels, nil),
+		proxyEjected: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "proxy", "server_ejected"),
+			"Whether the server is ejected by outlier detection, 1 for ejected.", serverLabels, nil),
+		filterStatus: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "filter", "status"),
+			"Numeric and boolean fields of the filter status.", fieldLabels, nil),
+		filterInfo: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "filter", "status_info"),
+			"String fields of the filter status, the value is always 1.",
+			append(append([]string{}, fieldLabels...), "value"), nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.httpServerStat.describe(ch)
+	c.proxyStat.describe(ch)
+	ch <- c.proxyHealthy
+	ch <- c.proxyEjected
+	ch <- c.filterStatus
+	ch <- c.filterInfo
+}
+
+// Collect implements prometheus.Collector.
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	entity, exists := c.super.GetSystemController(statussynccontroller.Kind)
+	if !exists {
+		return
+	}
+	ssc, ok := entity.Instance().(*statussynccontroller.StatusSyncController)
+	if !ok {
+		return
+	}
+
+	records := ssc.GetStatusesRecords()
+	if len(records) == 0 {
+		return
+	}
+
+	c.collectRecord(ch, records[len(records)-1])
+}
+
+// collectRecord collects the statuses of namespaces in the record.
+// NOTE: The status of the system TrafficController contains all
+// namespaces too, it is skipped to avoid duplicated metrics.
+func (c *statusCollector) collectRecord(ch chan<- prometheus.Metric, record *statussynccontroller.StatusesRecord) {
+	for _, status := range record.Statuses {
+		if ns, ok := status.ObjectStatus.(*trafficcontroller.StatusInSameNamespace); ok {
+			c.collectNamespace(ch, ns)
+		}
+	}
+}
+
+func (c *statusCollector) collectNamespace(ch chan<- prometheus.Metric, ns *trafficcontroller.StatusInSameNamespace) {
+	for name, server := range ns.HTTPServers {
+		c.collectHTTPServer(ch, ns.Namespace, name, server.Status)
+	}
+
+	for name, pipeline := range ns.HTTPPipelines {
+		c.collectHTTPPipeline(ch, ns.Namespace, name, pipeline.Status)
+	}
+}
+
+func (c *statusCollector) collectHTTPServer(ch chan<- prometheus.Metric, namespace, name string, status *httpserver.Status) {
+	if status == nil {
+		return
+	}
+
+	c.httpServerStat.collect(ch, status.Status, namespace, name)
+}
+
+func (c *statusCollector) collectHTTPPipeline(ch chan<- prometheus.Metric, namespace, name string, status *httppipeline.Status) {
+	if status == nil {
+		return
+	}
+
+	for filterName, filterStatus := range status.Filters {
+		proxyStatus, ok := filterStatus.(*proxy.Status)
+		if !ok {
+			c.collectFilter(ch, filterStatus, namespace, name, filterName)
+			continue
+		}
+
+		c.collectPool(ch, proxyStatus.MainPool, namespace, name, filterName, "mainPool")
+		for i, pool := range proxyStatus.CandidatePools {
+			c.collectPool(ch, pool, namespace, name, filterName, fmt.Sprintf("candidatePool/%d", i))
+		}
+		c.collectPool(ch, proxyStatus.MirrorPool, namespace, name, filterName, "mirrorPool")
+	}
+}
+
+func (c *statusCollector) collectPool(ch chan<- prometheus.Metric, status *proxy.PoolStatus, labelValues ...string) {
+	if status == nil {
+		return
+	}
+
+	c.proxyStat.collect(ch, status.Stat, labelValues...)
+
+	for _, server := range status.Servers {
+		serverLabelValues := append(append([]string{}, labelValues...), server.URL)
+		ch <- prometheus.MustNewConstMetric(c.proxyHealthy, prometheus.GaugeValue, boolToFloat64(server.Healthy), serverLabelValues...)
+		ch <- prometheus.MustNewConstMetric(c.proxyEjected, prometheus.GaugeValue, boolToFloat64(server.Ejected), serverLabelValues...)
+	}
+}
+
+// collectFilter collects the filter status generically, the fields of the
+// status struct are walked, and nested structs are joined by dots.
+func (c *statusCollector) collectFilter(ch chan<- prometheus.Metric, status interface{}, labelValues ...string) {
+	v := reflect.ValueOf(status)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	c.collectFields(ch, v, "", labelValues)
+}
+
+func (c *statusCollector) collectFields(ch chan<- prometheus.Metric, v reflect.Value, field string, labelValues []string) {
+	fieldLabelValues := func() []string {
+		return append(append([]string{}, labelValues...), field)
+	}
+	gauge := func(value float64) {
+		ch <- prometheus.MustNewConstMetric(c.filterStatus, prometheus.GaugeValue, value, fieldLabelValues()...)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			c.collectFields(ch, v.Elem(), field, labelValues)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			// unexported field
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if field != "" {
+				name = field + "." + name
+			}
+			c.collectFields(ch, v.Field(i), name, labelValues)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		gauge(float64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		gauge(float64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		gauge(v.Float())
+	case reflect.Bool:
+		gauge(boolToFloat64(v.Bool()))
+	case reflect.String:
+		if v.String() != "" {
+			infoLabelValues := append(fieldLabelValues(), v.String())
+			ch <- prometheus.MustNewConstMetric(c.filterInfo, prometheus.GaugeValue, 1, infoLabelValues...)
+		}
+	}
+	// NOTE: Slices and maps are skipped, as their sizes are unbounded.
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
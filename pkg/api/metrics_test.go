@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/megaease/easegress/pkg/filter/circuitbreaker"
+	"github.com/megaease/easegress/pkg/filter/httpcache"
+	"github.com/megaease/easegress/pkg/filter/proxy"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+type recordCollector struct {
+	*statusCollector
+	record *statussynccontroller.StatusesRecord
+}
+
+func (c *recordCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectRecord(ch, c.record)
+}
+
+func TestStatusCollector(t *testing.T) {
+	hs := httpstat.New()
+	for i := 0; i < 10; i++ {
+		code := http.StatusOK
+		if i < 2 {
+			code = http.StatusServiceUnavailable
+		}
+		hs.Stat(&httpstat.Metric{StatusCode: code, Duration: 5 * time.Millisecond})
+	}
+	stat := hs.Status()
+
+	ns := &trafficcontroller.StatusInSameNamespace{
+		Namespace: "default",
+		HTTPServers: map[string]*trafficcontroller.HTTPServerStatus{
+			"server-demo": {
+				Status: &httpserver.Status{Status: stat},
+			},
+		},
+		HTTPPipelines: map[string]*trafficcontroller.HTTPPipelineStatus{
+			"pipeline-demo": {
+				Status: &httppipeline.Status{
+					Filters: map[string]interface{}{
+						"proxy": &proxy.Status{
+							MainPool: &proxy.PoolStatus{
+								Stat: stat,
+								Servers: []*proxy.ServerStatus{
+									{URL: "http://127.0.0.1:9095", Healthy: true},
+									{URL: "http://127.0.0.1:9096", Ejected: true},
+								},
+							},
+						},
+						"validator":       nil,
+						"cache":           &httpcache.Status{Hits: 3, Misses: 1},
+						"circuit-breaker": &circuitbreaker.Status{Health: "ok"},
+					},
+				},
+			},
+		},
+	}
+
+	// NOTE: The status of the system TrafficController contains all
+	// namespaces, which are reported by their own records too.
+	record := &statussynccontroller.StatusesRecord{
+		Statuses: map[string]*supervisor.Status{
+			"traffic-controller": {
+				ObjectStatus: &trafficcontroller.Status{
+					Specs: []*trafficcontroller.StatusInSameNamespace{ns},
+				},
+			},
+			"default": {
+				ObjectStatus: ns,
+			},
+		},
+	}
+
+	c := &recordCollector{
+		statusCollector: newStatusCollector(nil),
+		record:          record,
+	}
+
+	expected := `
+# HELP easegress_httpserver_responses_total Total number of responses by status code.
+# TYPE easegress_httpserver_responses_total counter
+easegress_httpserver_responses_total{code="200",httpserver="server-demo",namespace="default"} 8
+easegress_httpserver_responses_total{code="503",httpserver="server-demo",namespace="default"} 2
+# HELP easegress_proxy_requests_total Total number of requests.
+# TYPE easegress_proxy_requests_total counter
+easegress_proxy_requests_total{filter="proxy",namespace="default",pipeline="pipeline-demo",pool="mainPool"} 10
+# HELP easegress_proxy_server_ejected Whether the server is ejected by outlier detection, 1 for ejected.
+# TYPE easegress_proxy_server_ejected gauge
+easegress_proxy_server_ejected{filter="proxy",namespace="default",pipeline="pipeline-demo",pool="mainPool",server="http://127.0.0.1:9095"} 0
+easegress_proxy_server_ejected{filter="proxy",namespace="default",pipeline="pipeline-demo",pool="mainPool",server="http://127.0.0.1:9096"} 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"easegress_httpserver_responses_total",
+		"easegress_proxy_requests_total",
+		"easegress_proxy_server_ejected")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP easegress_filter_status_info String fields of the filter status, the value is always 1.
+# TYPE easegress_filter_status_info gauge
+easegress_filter_status_info{field="health",filter="circuit-breaker",namespace="default",pipeline="pipeline-demo",value="ok"} 1
+`
+	err = testutil.CollectAndCompare(c, strings.NewReader(expected), "easegress_filter_status_info")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP easegress_filter_status Numeric and boolean fields of the filter status.
+# TYPE easegress_filter_status gauge
+easegress_filter_status{field="bytes",filter="cache",namespace="default",pipeline="pipeline-demo"} 0
+easegress_filter_status{field="entries",filter="cache",namespace="default",pipeline="pipeline-demo"} 0
+easegress_filter_status{field="hits",filter="cache",namespace="default",pipeline="pipeline-demo"} 3
+easegress_filter_status{field="misses",filter="cache",namespace="default",pipeline="pipeline-demo"} 1
+easegress_filter_status{field="revalidations",filter="cache",namespace="default",pipeline="pipeline-demo"} 0
+easegress_filter_status{field="staleHits",filter="cache",namespace="default",pipeline="pipeline-demo"} 0
+`
+	err = testutil.CollectAndCompare(c, strings.NewReader(expected), "easegress_filter_status")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c, "easegress_httpserver_request_duration_milliseconds"); n != 1 {
+		t.Errorf("expected 1 duration summary, but got %d", n)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("gather should succeed: %v", err)
+	}
+}
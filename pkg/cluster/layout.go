@@ -33,9 +33,10 @@ const (
 	configObjectFormat       = "/config/objects/%s" // +objectName
 	configVersion            = "/config/version"
 	wasmCodeEvent            = "/wasm/code"
-	wasmDataPrefixFormat     = "/wasm/data/%s/%s/"  // + pipelineName + filterName
-	customDataPrefixFormat   = "/custom-data/%s/"   // + kind
-	customDataItemFormat     = "/custom-data/%s/%s" // + kind + item key
+	wasmDataPrefixFormat     = "/wasm/data/%s/%s/"     // + pipelineName + filterName
+	customDataPrefixFormat   = "/custom-data/%s/"      // + kind
+	customDataItemFormat     = "/custom-data/%s/%s"    // + kind + item key
+	rateLimiterFormat        = "/ratelimiter/%s/%s/%s" // + pipelineName + filterName + urlID
 
 	// the cluster name of this eg group will be registered under this path in etcd
 	// any new member(primary or secondary ) will be rejected if it is configured a different cluster name
@@ -140,3 +141,8 @@ func (l *Layout) CustomDataPrefix(kind string) string {
 func (l *Layout) CustomDataItem(kind, key string) string {
 	return fmt.Sprintf(customDataItemFormat, kind, key)
 }
+
+// RateLimiterKey returns the key of the cluster-wide rate limiter of a URL.
+func (l *Layout) RateLimiterKey(pipeline, filter, urlID string) string {
+	return fmt.Sprintf(rateLimiterFormat, pipeline, filter, urlID)
+}
@@ -21,32 +21,38 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 )
 
 // MockedHTTPRequest is the mocked HTTP request
 type MockedHTTPRequest struct {
-	MockedRealIP      func() string
-	MockedMethod      func() string
-	MockedSetMethod   func(method string)
-	MockedScheme      func() string
-	MockedHost        func() string
-	MockedSetHost     func(host string)
-	MockedPath        func() string
-	MockedSetPath     func(path string)
-	MockedEscapedPath func() string
-	MockedQuery       func() string
-	MockedSetQuery    func(query string)
-	MockedFragment    func() string
-	MockedProto       func() string
-	MockedHeader      func() *httpheader.HTTPHeader
-	MockedCookie      func(name string) (*http.Cookie, error)
-	MockedCookies     func() []*http.Cookie
-	MockedAddCookie   func(cookie *http.Cookie)
-	MockedBody        func() io.Reader
-	MockedSetBody     func(io.Reader)
-	MockedStd         func() *http.Request
-	MockedSize        func() uint64
+	MockedRealIP        func() string
+	MockedMethod        func() string
+	MockedSetMethod     func(method string)
+	MockedScheme        func() string
+	MockedHost          func() string
+	MockedSetHost       func(host string)
+	MockedPath          func() string
+	MockedSetPath       func(path string)
+	MockedPathParam     func(name string) string
+	MockedPathParams    func() map[string]string
+	MockedSetPathParams func(params map[string]string)
+	MockedIdentity      func() *context.Identity
+	MockedSetIdentity   func(identity *context.Identity)
+	MockedEscapedPath   func() string
+	MockedQuery         func() string
+	MockedSetQuery      func(query string)
+	MockedFragment      func() string
+	MockedProto         func() string
+	MockedHeader        func() *httpheader.HTTPHeader
+	MockedCookie        func(name string) (*http.Cookie, error)
+	MockedCookies       func() []*http.Cookie
+	MockedAddCookie     func(cookie *http.Cookie)
+	MockedBody          func() io.Reader
+	MockedSetBody       func(io.Reader)
+	MockedStd           func() *http.Request
+	MockedSize          func() uint64
 }
 
 // RealIP mocks the RealIP function of HTTPRequest
@@ -110,6 +116,44 @@ func (r *MockedHTTPRequest) SetPath(path string) {
 	}
 }
 
+// PathParam mocks the PathParam function of HTTPRequest
+func (r *MockedHTTPRequest) PathParam(name string) string {
+	if r.MockedPathParam != nil {
+		return r.MockedPathParam(name)
+	}
+	return ""
+}
+
+// PathParams mocks the PathParams function of HTTPRequest
+func (r *MockedHTTPRequest) PathParams() map[string]string {
+	if r.MockedPathParams != nil {
+		return r.MockedPathParams()
+	}
+	return nil
+}
+
+// SetPathParams mocks the SetPathParams function of HTTPRequest
+func (r *MockedHTTPRequest) SetPathParams(params map[string]string) {
+	if r.MockedSetPathParams != nil {
+		r.MockedSetPathParams(params)
+	}
+}
+
+// Identity mocks the Identity function of HTTPRequest
+func (r *MockedHTTPRequest) Identity() *context.Identity {
+	if r.MockedIdentity != nil {
+		return r.MockedIdentity()
+	}
+	return nil
+}
+
+// SetIdentity mocks the SetIdentity function of HTTPRequest
+func (r *MockedHTTPRequest) SetIdentity(identity *context.Identity) {
+	if r.MockedSetIdentity != nil {
+		r.MockedSetIdentity(identity)
+	}
+}
+
 // EscapedPath mocks the EscapedPath function of HTTPRequest
 func (r *MockedHTTPRequest) EscapedPath() string {
 	if r.MockedEscapedPath != nil {
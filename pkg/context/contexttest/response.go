@@ -32,6 +32,7 @@ type MockedHTTPResponse struct {
 	MockedStatusCode    func() int
 	MockedSetStatusCode func(code int)
 	MockedHeader        func() *httpheader.HTTPHeader
+	MockedTrailer       func() *httpheader.HTTPHeader
 	MockedSetCookie     func(cookie *http.Cookie)
 	MockedSetBody       func(body io.Reader)
 	MockedBody          func() io.Reader
@@ -63,6 +64,14 @@ func (r *MockedHTTPResponse) Header() *httpheader.HTTPHeader {
 	return nil
 }
 
+// Trailer returns the trailer
+func (r *MockedHTTPResponse) Trailer() *httpheader.HTTPHeader {
+	if r.MockedTrailer != nil {
+		return r.MockedTrailer()
+	}
+	return nil
+}
+
 // SetCookie sets a cookie
 func (r *MockedHTTPResponse) SetCookie(cookie *http.Cookie) {
 	if r.MockedSetCookie != nil {
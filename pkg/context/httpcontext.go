@@ -87,6 +87,16 @@ type (
 		SetHost(host string)
 		Path() string
 		SetPath(path string)
+		// PathParam returns the parameter captured from the path by
+		// the router of HTTPServer, empty if not found.
+		PathParam(name string) string
+		// PathParams returns all parameters, callers must not modify it.
+		PathParams() map[string]string
+		SetPathParams(params map[string]string)
+		// Identity returns the identity verified by authentication
+		// filters, like the Validator, nil if not verified.
+		Identity() *Identity
+		SetIdentity(identity *Identity)
 		EscapedPath() string
 		Query() string
 		SetQuery(query string)
@@ -113,6 +123,8 @@ type (
 		SetStatusCode(code int)
 
 		Header() *httpheader.HTTPHeader
+		// Trailer is sent after the body, it is not supported by HTTP/1.0.
+		Trailer() *httpheader.HTTPHeader
 		SetCookie(cookie *http.Cookie)
 
 		SetBody(body io.Reader)
@@ -124,6 +136,17 @@ type (
 		Size() uint64 // bytes
 	}
 
+	// Identity is the identity of the client verified by authentication
+	// filters, other filters should use it rather than the headers set by
+	// the authentication filters, because the headers could be spoofed if
+	// the authentication filters are not in the pipeline.
+	Identity struct {
+		// Consumer is the name of the authenticated consumer.
+		Consumer string
+		// Claims are the claims of the verified token.
+		Claims map[string]interface{}
+	}
+
 	// HTTPResult is result for handling http request
 	HTTPResult struct {
 		Err error
@@ -172,7 +195,7 @@ func New(stdw http.ResponseWriter, stdr *http.Request,
 	stdctx, cancelFunc := stdcontext.WithCancel(originalReqCtx)
 	stdr = stdr.WithContext(stdctx)
 	startTime := fasttime.Now()
-	span := tracing.NewSpanWithStart(tracer, spanName, startTime)
+	span := tracing.NewSpanWithHeader(tracer, spanName, startTime, stdr.Header)
 	if !span.IsNoopSpan() {
 		span.SetTag("http.method", stdr.Method)
 		span.SetTag("http.path", stdr.URL.Path)
@@ -296,10 +319,12 @@ func (ctx *httpContext) Finish() {
 		ctx.w.SetStatusCode(EGStatusClientClosedRequest /* consistent with nginx */)
 	}
 
-	ctx.r.finish()
+	// NOTE: The request body is closed after the response is flushed,
+	// the request of bidirectional gRPC streams is still in progress.
 	ctx.w.finish()
+	ctx.r.finish()
 
-	ctx.metric.StatusCode = ctx.Response().StatusCode()
+	ctx.metric.StatusCode = ctx.w.statCode()
 	ctx.metric.Duration = fasttime.Now().Sub(ctx.startTime)
 	ctx.metric.ReqSize = ctx.Request().Size()
 	ctx.metric.RespSize = ctx.Response().Size()
@@ -32,6 +32,8 @@ type (
 		std       *http.Request
 		method    string
 		path      string
+		params    map[string]string
+		identity  *Identity
 		header    *httpheader.HTTPHeader
 		body      *callbackreader.CallbackReader
 		bodyCount int
@@ -115,6 +117,26 @@ func (r *httpRequest) SetPath(path string) {
 	r.path = path
 }
 
+func (r *httpRequest) PathParam(name string) string {
+	return r.params[name]
+}
+
+func (r *httpRequest) PathParams() map[string]string {
+	return r.params
+}
+
+func (r *httpRequest) SetPathParams(params map[string]string) {
+	r.params = params
+}
+
+func (r *httpRequest) Identity() *Identity {
+	return r.identity
+}
+
+func (r *httpRequest) SetIdentity(identity *Identity) {
+	r.identity = identity
+}
+
 func (r *httpRequest) EscapedPath() string {
 	return r.std.URL.EscapedPath()
 }
@@ -25,18 +25,27 @@ import (
 	"strconv"
 
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/grpcstatus"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 )
 
 var bodyFlushBuffSize = 8 * int64(os.Getpagesize())
 
 type (
+	// flushWriter flushes every write to the client, so that the
+	// messages of gRPC streams are not held in the buffer.
+	flushWriter struct {
+		w       io.Writer
+		flusher http.Flusher
+	}
+
 	httpResponse struct {
 		stdr *http.Request
 		std  http.ResponseWriter
 
-		code   int
-		header *httpheader.HTTPHeader
+		code    int
+		header  *httpheader.HTTPHeader
+		trailer *httpheader.HTTPHeader
 
 		body           io.Reader
 		bodyWritten    uint64
@@ -46,13 +55,20 @@ type (
 
 func newHTTPResponse(stdw http.ResponseWriter, stdr *http.Request) *httpResponse {
 	return &httpResponse{
-		stdr:   stdr,
-		std:    stdw,
-		code:   http.StatusOK,
-		header: httpheader.New(stdw.Header()),
+		stdr:    stdr,
+		std:     stdw,
+		code:    http.StatusOK,
+		header:  httpheader.New(stdw.Header()),
+		trailer: httpheader.New(http.Header{}),
 	}
 }
 
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.flusher.Flush()
+	return n, err
+}
+
 func (w *httpResponse) StatusCode() int {
 	return w.code
 }
@@ -65,6 +81,10 @@ func (w *httpResponse) Header() *httpheader.HTTPHeader {
 	return w.header
 }
 
+func (w *httpResponse) Trailer() *httpheader.HTTPHeader {
+	return w.trailer
+}
+
 func (w *httpResponse) SetCookie(cookie *http.Cookie) {
 	http.SetCookie(w.std, cookie)
 }
@@ -98,8 +118,13 @@ func (w *httpResponse) flushBody() {
 		}
 	}()
 
+	var dst io.Writer = w.std
+	if flusher, ok := w.std.(http.Flusher); ok && w.isGRPC() {
+		dst = &flushWriter{w: w.std, flusher: flusher}
+	}
+
 	copyToClient := func(src io.Reader) (succeed bool) {
-		written, err := io.Copy(w.std, src)
+		written, err := io.Copy(dst, src)
 		if err != nil {
 			logger.Warnf("copy body failed: %v", err)
 			return false
@@ -155,14 +180,80 @@ func (w *httpResponse) FlushedBodyBytes() uint64 {
 }
 
 func (w *httpResponse) finish() {
+	if w.code != http.StatusOK && !w.isGRPC() &&
+		grpcstatus.IsGRPC(w.stdr.Header.Get("Content-Type")) {
+		w.toGRPC()
+	}
+
 	// copy backend http response header
 	// NOTE: this copy should call before WriteHeader
-	w.header.VisitAll(func(k, v string) {
-		w.std.Header().Add(k, v)
-	})
+	w.copyHeader()
 	// NOTE: WriteHeader must be called at most one time.
 	w.std.WriteHeader(w.StatusCode())
 	w.flushBody()
+	w.flushTrailer()
+}
+
+// copyHeader copies the header to the std writer. The header is shared
+// with the std writer unless it is replaced, so the values already in
+// the std writer are not copied again.
+func (w *httpResponse) copyHeader() {
+	dst := w.std.Header()
+	for key, values := range w.header.Std() {
+		if hasPrefix(dst[key], values) {
+			continue
+		}
+		dst[key] = append(dst[key], values...)
+	}
+}
+
+func hasPrefix(values, prefix []string) bool {
+	if len(values) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if values[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *httpResponse) flushTrailer() {
+	// NOTE: The trailers of upstream responses are available
+	// only after their bodies are read to EOF.
+	w.trailer.VisitAll(func(k, v string) {
+		w.std.Header().Add(http.TrailerPrefix+k, v)
+	})
+}
+
+func (w *httpResponse) isGRPC() bool {
+	return grpcstatus.IsGRPC(w.header.Get("Content-Type"))
+}
+
+// toGRPC converts the response to a gRPC Trailers-Only response, it is
+// for the responses of gRPC requests generated by Easegress, like 404
+// of no route matched, so that gRPC clients get meaningful status.
+func (w *httpResponse) toGRPC() {
+	if body, ok := w.body.(io.ReadCloser); ok {
+		body.Close()
+	}
+	w.body = nil
+
+	code := grpcstatus.FromHTTPStatus(w.code)
+	w.header.Del("Content-Length")
+	w.header.Set("Content-Type", grpcstatus.ContentType)
+	w.header.Set(grpcstatus.HeaderStatus, strconv.Itoa(int(code)))
+	if text := http.StatusText(w.code); text != "" {
+		w.header.Set(grpcstatus.HeaderMessage, text)
+	}
+	w.code = http.StatusOK
+}
+
+// statCode returns the status code for statistics, it is converted
+// from the gRPC status for gRPC responses.
+func (w *httpResponse) statCode() int {
+	return grpcstatus.StatusCode(w.code, w.header.Std(), w.trailer.Std())
 }
 
 func (w *httpResponse) Size() uint64 {
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestResponseHeader(t *testing.T) {
+	// the header is shared with the std writer.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	ctx := New(w, r, tracing.NoopTracing, "test")
+	ctx.Response().Header().Set("X-Test", "v1")
+	ctx.Response().Header().Add("X-Multi", "v1")
+	ctx.Response().Header().Add("X-Multi", "v2")
+	ctx.Finish()
+
+	if v := w.Header().Values("X-Test"); !reflect.DeepEqual(v, []string{"v1"}) {
+		t.Errorf("header should not be duplicated, but got %v", v)
+	}
+	if v := w.Header().Values("X-Multi"); !reflect.DeepEqual(v, []string{"v1", "v2"}) {
+		t.Errorf("header should not be duplicated, but got %v", v)
+	}
+
+	// the header is replaced, e.g. by the header of the upstream response.
+	w = httptest.NewRecorder()
+	ctx = New(w, r, tracing.NoopTracing, "test")
+	ctx.Response().SetCookie(&http.Cookie{Name: "session", Value: "1"})
+	ctx.Response().Header().SetRaw(http.Header{
+		"X-Test":     {"v1"},
+		"Set-Cookie": {"upstream=1"},
+	})
+	ctx.Finish()
+
+	if v := w.Header().Values("X-Test"); !reflect.DeepEqual(v, []string{"v1"}) {
+		t.Errorf("replaced header should be copied, but got %v", v)
+	}
+	if v := w.Header().Values("Set-Cookie"); !reflect.DeepEqual(v, []string{"session=1", "upstream=1"}) {
+		t.Errorf("replaced header should be appended, but got %v", v)
+	}
+}
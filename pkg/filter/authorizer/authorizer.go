@@ -0,0 +1,326 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authorizer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/yl2chen/cidranger"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of Authorizer.
+	Kind = "Authorizer"
+
+	resultForbidden = "forbidden"
+
+	actionAllow = "allow"
+	actionDeny  = "deny"
+
+	defaultUserHeader = "X-AUTH-USER"
+	// anyUser matches all authenticated users.
+	anyUser = "*"
+)
+
+var results = []string{resultForbidden}
+
+func init() {
+	httppipeline.Register(&Authorizer{})
+}
+
+type (
+	// Authorizer is filter Authorizer.
+	Authorizer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Spec describes the Authorizer.
+	Spec struct {
+		// DefaultAction is the action if no rule matches, default is deny.
+		DefaultAction string `yaml:"defaultAction,omitempty" jsonschema:"omitempty,enum=allow,enum=deny"`
+		// DryRun only logs the decisions, requests are always allowed.
+		DryRun bool `yaml:"dryRun" jsonschema:"omitempty"`
+		// UserHeader is the header of the authenticated user.
+		UserHeader string `yaml:"userHeader,omitempty" jsonschema:"omitempty"`
+		// GroupsHeader is the header of the groups of the authenticated
+		// user, groups are separated by commas.
+		GroupsHeader string `yaml:"groupsHeader,omitempty" jsonschema:"omitempty"`
+		// Rules are evaluated in order, the first matched rule decides.
+		Rules []*Rule `yaml:"rules" jsonschema:"omitempty"`
+	}
+
+	// Rule is the authorization rule, a request matches the rule if it
+	// matches all the conditions of the rule.
+	Rule struct {
+		Name    string               `yaml:"name" jsonschema:"required"`
+		Action  string               `yaml:"action" jsonschema:"required,enum=allow,enum=deny"`
+		Methods []string             `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Path    *urlrule.StringMatch `yaml:"path,omitempty" jsonschema:"omitempty"`
+		// Users matches if the user is any one of them, "*" matches
+		// all authenticated users.
+		Users []string `yaml:"users,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		// Groups matches if the user is in any one of them.
+		Groups    []string                        `yaml:"groups,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		SourceIPs []string                        `yaml:"sourceIPs,omitempty" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+		Headers   map[string]*urlrule.StringMatch `yaml:"headers,omitempty" jsonschema:"omitempty"`
+
+		sourceIPs cidranger.Ranger
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	for _, r := range spec.Rules {
+		if names[r.Name] {
+			return fmt.Errorf("duplicated rule name %s", r.Name)
+		}
+		names[r.Name] = true
+
+		if len(r.Groups) > 0 && spec.GroupsHeader == "" {
+			return fmt.Errorf("rule %s: groups requires groupsHeader", r.Name)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of Authorizer.
+func (a *Authorizer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of Authorizer.
+func (a *Authorizer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of Authorizer.
+func (a *Authorizer) Description() string {
+	return "Authorizer authorizes requests by rules."
+}
+
+// Results returns the results of Authorizer.
+func (a *Authorizer) Results() []string {
+	return results
+}
+
+// Init initializes Authorizer.
+func (a *Authorizer) Init(filterSpec *httppipeline.FilterSpec) {
+	a.filterSpec, a.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	a.reload()
+}
+
+// Inherit inherits previous generation of Authorizer.
+func (a *Authorizer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	a.Init(filterSpec)
+}
+
+func (a *Authorizer) reload() {
+	if a.spec.DefaultAction == "" {
+		a.spec.DefaultAction = actionDeny
+	}
+	if a.spec.UserHeader == "" {
+		a.spec.UserHeader = defaultUserHeader
+	}
+
+	for _, r := range a.spec.Rules {
+		if r.Path != nil {
+			r.Path.Init()
+		}
+		for _, h := range r.Headers {
+			h.Init()
+		}
+		if len(r.SourceIPs) > 0 {
+			r.sourceIPs = newRanger(r.SourceIPs)
+		}
+	}
+}
+
+func newRanger(ipcidrs []string) cidranger.Ranger {
+	ranger := cidranger.NewPCTrieRanger()
+	for _, ipcidr := range ipcidrs {
+		if !strings.Contains(ipcidr, "/") {
+			if strings.Contains(ipcidr, ":") {
+				ipcidr += "/128"
+			} else {
+				ipcidr += "/32"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(ipcidr)
+		if err != nil {
+			logger.Errorf("BUG: %s is an invalid ip or cidr", ipcidr)
+			continue
+		}
+		ranger.Insert(cidranger.NewBasicRangerEntry(*ipNet))
+	}
+	return ranger
+}
+
+// Handle authorizes HTTPContext.
+func (a *Authorizer) Handle(ctx context.HTTPContext) string {
+	result := a.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (a *Authorizer) handle(ctx context.HTTPContext) string {
+	req := ctx.Request()
+
+	action, ruleName := a.spec.DefaultAction, "default"
+	if r := a.match(req); r != nil {
+		action, ruleName = r.Action, r.Name
+	}
+
+	if a.spec.DryRun {
+		if action == actionDeny {
+			logger.Infof("%s: dry run: %s %s of user %q is denied by rule %s",
+				a.filterSpec.Name(), req.Method(), req.Path(), req.Header().Get(a.spec.UserHeader), ruleName)
+		}
+		ctx.AddTag(stringtool.Cat("authorizer: dry run: ", action, " by rule ", ruleName))
+		return ""
+	}
+
+	ctx.AddTag(stringtool.Cat("authorizer: ", action, " by rule ", ruleName))
+	if action == actionDeny {
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return resultForbidden
+	}
+
+	return ""
+}
+
+func (a *Authorizer) match(req context.HTTPRequest) *Rule {
+	user := req.Header().Get(a.spec.UserHeader)
+
+	var groups []string
+	if a.spec.GroupsHeader != "" {
+		for _, g := range strings.Split(req.Header().Get(a.spec.GroupsHeader), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	// NOTE: The path is cleaned, so that paths like //admin and
+	// /admin/./x can't bypass the rules.
+	p := cleanPath(req.Path())
+	for _, r := range a.spec.Rules {
+		if r.match(req, p, user, groups) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// cleanPath returns the cleaned path of the unescaped path, the
+// trailing slash is kept.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func (r *Rule) match(req context.HTTPRequest, reqPath, user string, groups []string) bool {
+	if len(r.Methods) > 0 && !stringtool.StrInSlice(req.Method(), r.Methods) {
+		return false
+	}
+
+	if r.Path != nil && !r.Path.Match(reqPath) {
+		return false
+	}
+
+	if len(r.Users) > 0 {
+		if user == "" {
+			return false
+		}
+		if !stringtool.StrInSlice(anyUser, r.Users) && !stringtool.StrInSlice(user, r.Users) {
+			return false
+		}
+	}
+
+	if len(r.Groups) > 0 && !anyIn(groups, r.Groups) {
+		return false
+	}
+
+	if r.sourceIPs != nil {
+		ip := net.ParseIP(req.RealIP())
+		if ip == nil {
+			return false
+		}
+		if ok, err := r.sourceIPs.Contains(ip); err != nil || !ok {
+			return false
+		}
+	}
+
+	for key, sm := range r.Headers {
+		values := req.Header().GetAll(key)
+		if len(values) == 0 {
+			if !sm.Empty {
+				return false
+			}
+			continue
+		}
+		if !anyMatch(values, sm) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func anyIn(values []string, candidates []string) bool {
+	for _, v := range values {
+		if stringtool.StrInSlice(v, candidates) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyMatch(values []string, sm *urlrule.StringMatch) bool {
+	for _, v := range values {
+		if sm.Match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (a *Authorizer) Status() interface{} { return nil }
+
+// Close closes Authorizer.
+func (a *Authorizer) Close() {}
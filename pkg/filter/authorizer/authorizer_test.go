@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authorizer
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func createAuthorizer(yamlSpec string) *Authorizer {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		panic(err.Error())
+	}
+	a := &Authorizer{}
+	a.Init(spec)
+	return a
+}
+
+func prepareCtx(method, rawPath, realIP string, header map[string]string) *contexttest.MockedHTTPContext {
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return method
+	}
+	// NOTE: The path of a request is unescaped like URL.Path.
+	u, _ := url.Parse("http://example.com" + rawPath)
+	ctx.MockedRequest.MockedPath = func() string {
+		return u.Path
+	}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return realIP
+	}
+	h := http.Header{}
+	for k, v := range header {
+		h.Set(k, v)
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(h)
+	}
+
+	statusCode := http.StatusOK
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+		statusCode = code
+	}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		return statusCode
+	}
+	return ctx
+}
+
+const yamlSpec = `
+kind: Authorizer
+name: authorizer
+groupsHeader: X-User-Groups
+rules:
+- name: internal-requires-header
+  action: deny
+  path:
+    prefix: /internal/
+  sourceIPs: ["0.0.0.0/0"]
+  headers:
+    X-Internal:
+      empty: true
+- name: internal
+  action: allow
+  path:
+    prefix: /internal/
+- name: admins
+  action: allow
+  path:
+    prefix: /admin/
+  groups: [admin]
+- name: readonly
+  action: allow
+  methods: [GET, HEAD]
+  path:
+    regex: ^/(api|docs)/
+  users: ["*"]
+- name: bob-writes
+  action: allow
+  methods: [POST]
+  path:
+    prefix: /api/
+  users: [bob]
+  sourceIPs: ["10.0.0.0/8"]
+`
+
+func TestAuthorizer(t *testing.T) {
+	a := createAuthorizer(yamlSpec)
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		realIP  string
+		header  map[string]string
+		allowed bool
+	}{
+		{"anonymous read", http.MethodGet, "/api/users", "1.2.3.4", nil, false},
+		{"authenticated read", http.MethodGet, "/api/users", "1.2.3.4", map[string]string{"X-AUTH-USER": "alice"}, true},
+		{"read not matching path", http.MethodGet, "/other", "1.2.3.4", map[string]string{"X-AUTH-USER": "alice"}, false},
+		{"write by others", http.MethodPost, "/api/users", "10.1.1.1", map[string]string{"X-AUTH-USER": "alice"}, false},
+		{"write by bob", http.MethodPost, "/api/users", "10.1.1.1", map[string]string{"X-AUTH-USER": "bob"}, true},
+		{"write by bob from outside", http.MethodPost, "/api/users", "1.2.3.4", map[string]string{"X-AUTH-USER": "bob"}, false},
+		{"admin", http.MethodDelete, "/admin/users", "1.2.3.4", map[string]string{"X-User-Groups": "dev, admin"}, true},
+		{"not admin", http.MethodDelete, "/admin/users", "1.2.3.4", map[string]string{"X-User-Groups": "dev"}, false},
+		{"internal without header", http.MethodGet, "/internal/x", "10.1.1.1", nil, false},
+		{"internal", http.MethodGet, "/internal/x", "10.1.1.1", map[string]string{"X-Internal": "1"}, true},
+	}
+
+	for _, c := range cases {
+		ctx := prepareCtx(c.method, c.path, c.realIP, c.header)
+		result := a.Handle(ctx)
+		if c.allowed && result != "" {
+			t.Errorf("%s: should be allowed, but got %s", c.name, result)
+		}
+		if !c.allowed && (result != resultForbidden || ctx.Response().StatusCode() != http.StatusForbidden) {
+			t.Errorf("%s: should be forbidden, but got %q, %d", c.name, result, ctx.Response().StatusCode())
+		}
+	}
+}
+
+func TestPathBypass(t *testing.T) {
+	a := createAuthorizer(`
+kind: Authorizer
+name: authorizer
+rules:
+- name: no-admin
+  action: deny
+  path:
+    prefix: /admin/
+- name: all
+  action: allow
+  path:
+    prefix: /
+`)
+
+	for _, p := range []string{"/admin/x", "//admin/x", "/admin/./x", "/%61dmin/x", "/api/../admin/x", "/admin//"} {
+		if result := a.Handle(prepareCtx(http.MethodGet, p, "1.2.3.4", nil)); result != resultForbidden {
+			t.Errorf("%s should be forbidden, but got %q", p, result)
+		}
+	}
+	if result := a.Handle(prepareCtx(http.MethodGet, "/api/x", "1.2.3.4", nil)); result != "" {
+		t.Errorf("/api/x should be allowed, but got %q", result)
+	}
+}
+
+func TestDefaultActionAndDryRun(t *testing.T) {
+	a := createAuthorizer(`
+kind: Authorizer
+name: authorizer
+defaultAction: allow
+rules:
+- name: no-delete
+  action: deny
+  methods: [DELETE]
+`)
+	if result := a.Handle(prepareCtx(http.MethodGet, "/", "1.2.3.4", nil)); result != "" {
+		t.Errorf("should be allowed by default")
+	}
+	if result := a.Handle(prepareCtx(http.MethodDelete, "/", "1.2.3.4", nil)); result != resultForbidden {
+		t.Errorf("should be forbidden")
+	}
+
+	a = createAuthorizer(`
+kind: Authorizer
+name: authorizer
+dryRun: true
+`)
+	ctx := prepareCtx(http.MethodDelete, "/", "1.2.3.4", nil)
+	if result := a.Handle(ctx); result != "" || ctx.Response().StatusCode() != http.StatusOK {
+		t.Errorf("should be allowed in dry run mode")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{Rules: []*Rule{{Name: "a", Action: actionAllow}, {Name: "a", Action: actionDeny}}}
+	if spec.Validate() == nil {
+		t.Errorf("duplicated rule names should fail the validation")
+	}
+
+	spec = Spec{Rules: []*Rule{{Name: "a", Action: actionAllow, Groups: []string{"admin"}}}}
+	if spec.Validate() == nil {
+		t.Errorf("groups without groupsHeader should fail the validation")
+	}
+}
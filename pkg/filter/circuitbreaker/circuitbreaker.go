@@ -29,6 +29,7 @@ import (
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	libcb "github.com/megaease/easegress/pkg/util/circuitbreaker"
 	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/grpcstatus"
 	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
@@ -59,6 +60,10 @@ type (
 		MaxWaitDurationInHalfOpen        string `yaml:"maxWaitDurationInHalfOpenState" jsonschema:"omitempty,format=duration"`
 		WaitDurationInOpen               string `yaml:"waitDurationInOpenState" jsonschema:"omitempty,format=duration"`
 		FailureStatusCodes               []int  `yaml:"failureStatusCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		// NOTE: Only the gRPC status in the response headers (Trailers-Only
+		// responses) is checked, the one in the trailers is not available
+		// until the body has been streamed to the client.
+		FailureGRPCCodes []int `yaml:"failureGRPCCodes,omitempty" jsonschema:"omitempty,uniqueItems=true,format=grpccode-array"`
 	}
 
 	// URLRule defines the circuit breaker rule for a URL pattern
@@ -318,6 +323,9 @@ func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
 			}
 		}
 	}
+	if !hasErr && len(u.policy.FailureGRPCCodes) > 0 {
+		hasErr = grpcstatus.HasCode(ctx.Response().Header().Std(), u.policy.FailureGRPCCodes)
+	}
 	u.cb.RecordResult(stateID, hasErr, d)
 
 	return result
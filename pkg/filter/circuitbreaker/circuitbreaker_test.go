@@ -28,6 +28,7 @@ import (
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	libcb "github.com/megaease/easegress/pkg/util/circuitbreaker"
+	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
 
@@ -121,6 +122,97 @@ urls:
 	}
 }
 
+func TestCircuitBreakerGRPC(t *testing.T) {
+	const yamlSpec = `
+kind: CircuitBreaker
+name: circuitbreaker
+policies:
+- name: default
+  slowCallRateThreshold: 100
+  failureRateThreshold: 50
+  slidingWindowType: COUNT_BASED
+  slidingWindowSize: 10
+  minimumNumberOfCalls: 5
+  failureGRPCCodes: [14]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /helloworld.Greeter/
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	cb := &CircuitBreaker{}
+	cb.Init(spec)
+	defer cb.Close()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", "0")
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodPost
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/helloworld.Greeter/SayHello"
+	}
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter {
+		return httptest.NewRecorder()
+	}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		return http.StatusOK
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+
+	for i := 0; i < 5; i++ {
+		if cb.Handle(ctx) == resultShortCircuited {
+			t.Error("should not be short circuited")
+		}
+	}
+
+	// UNAVAILABLE
+	header.Set("Grpc-Status", "14")
+	for i := 0; i < 5; i++ {
+		cb.Handle(ctx)
+	}
+	if cb.Handle(ctx) != resultShortCircuited {
+		t.Error("should be short circuited")
+	}
+}
+
+func TestCircuitBreakerInvalidGRPCCodes(t *testing.T) {
+	const yamlSpec = `
+kind: CircuitBreaker
+name: circuitbreaker
+policies:
+- name: default
+  slowCallRateThreshold: 100
+  failureRateThreshold: 50
+  slidingWindowType: COUNT_BASED
+  slidingWindowSize: 10
+  minimumNumberOfCalls: 5
+  failureGRPCCodes: [14, 17]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /helloworld.Greeter/
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	if _, e := httppipeline.NewFilterSpec(rawSpec, nil); e == nil {
+		t.Error("gRPC code 17 should be invalid")
+	}
+}
+
 func TestBuildPolicy(t *testing.T) {
 	url := &URLRule{
 		policy: &Policy{
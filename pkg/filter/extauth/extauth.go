@@ -0,0 +1,346 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ExtAuth.
+	Kind = "ExtAuth"
+
+	resultDenied = "denied"
+	resultFailed = "failed"
+
+	defaultTimeout         = 5 * time.Second
+	defaultMaxCacheEntries = 10000
+
+	// maxBodyBytes is the max bytes of the body of denied responses
+	// returned to the client.
+	maxBodyBytes = 64 * 1024
+
+	headerForwardedMethod = "X-Forwarded-Method"
+	headerForwardedProto  = "X-Forwarded-Proto"
+	headerForwardedHost   = "X-Forwarded-Host"
+	headerForwardedURI    = "X-Forwarded-Uri"
+	headerForwardedFor    = "X-Forwarded-For"
+)
+
+var (
+	results = []string{resultDenied, resultFailed}
+
+	defaultRequestHeaders = []string{httpheader.KeyAuthorization, "Cookie"}
+
+	// hopHeaders are not returned to the client in denied responses.
+	hopHeaders = []string{
+		httpheader.KeyContentLength,
+		"Connection",
+		"Keep-Alive",
+		"Transfer-Encoding",
+		"Upgrade",
+	}
+
+	// for unit testing cases to mock 'time.Now' only
+	nowFunc = time.Now
+)
+
+func init() {
+	httppipeline.Register(&ExtAuth{})
+}
+
+type (
+	// ExtAuth is filter ExtAuth.
+	ExtAuth struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		client   *http.Client
+		cacheTTL time.Duration
+
+		cacheMutex sync.Mutex
+		cache      *simplelru.LRU
+	}
+
+	// Spec describes the ExtAuth.
+	Spec struct {
+		// URL is the URL of the auth service.
+		URL string `yaml:"url" jsonschema:"required,format=uri"`
+		// RequestHeaders are the headers of the original request sent
+		// to the auth service, default is Authorization and Cookie.
+		RequestHeaders []string `yaml:"requestHeaders,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		// UpstreamHeaders are the headers of the allowing response
+		// injected into the original request.
+		UpstreamHeaders []string `yaml:"upstreamHeaders,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		Timeout         string   `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+		// FailOpen allows requests when the auth service is unavailable.
+		FailOpen bool `yaml:"failOpen" jsonschema:"omitempty"`
+		// CacheTTL is the time to cache allowing decisions, decisions
+		// are not cached if it's empty.
+		CacheTTL        string `yaml:"cacheTTL,omitempty" jsonschema:"omitempty,format=duration"`
+		MaxCacheEntries int    `yaml:"maxCacheEntries,omitempty" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// decision is a cached allowing decision.
+	decision struct {
+		header    http.Header
+		expiresAt time.Time
+	}
+)
+
+// Kind returns the kind of ExtAuth.
+func (ea *ExtAuth) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ExtAuth.
+func (ea *ExtAuth) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ExtAuth.
+func (ea *ExtAuth) Description() string {
+	return "ExtAuth authorizes requests by an external auth service."
+}
+
+// Results returns the results of ExtAuth.
+func (ea *ExtAuth) Results() []string {
+	return results
+}
+
+// Init initializes ExtAuth.
+func (ea *ExtAuth) Init(filterSpec *httppipeline.FilterSpec) {
+	ea.filterSpec, ea.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ea.reload()
+}
+
+// Inherit inherits previous generation of ExtAuth.
+func (ea *ExtAuth) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ea.Init(filterSpec)
+}
+
+func (ea *ExtAuth) reload() {
+	if len(ea.spec.RequestHeaders) == 0 {
+		ea.spec.RequestHeaders = defaultRequestHeaders
+	}
+
+	timeout := defaultTimeout
+	if d, err := time.ParseDuration(ea.spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	ea.client = &http.Client{
+		Timeout: timeout,
+		// NOTE: Redirections are returned to the client as denials,
+		// e.g. redirecting to the login page.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if d, err := time.ParseDuration(ea.spec.CacheTTL); err == nil && d > 0 {
+		ea.cacheTTL = d
+		maxEntries := ea.spec.MaxCacheEntries
+		if maxEntries <= 0 {
+			maxEntries = defaultMaxCacheEntries
+		}
+		ea.cache, _ = simplelru.NewLRU(maxEntries, nil)
+	}
+}
+
+// Handle authorizes HTTPContext by the auth service.
+func (ea *ExtAuth) Handle(ctx context.HTTPContext) string {
+	result := ea.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ea *ExtAuth) handle(ctx context.HTTPContext) string {
+	req := ctx.Request()
+	for _, h := range ea.spec.UpstreamHeaders {
+		req.Header().Del(h)
+	}
+
+	authReq, err := ea.newAuthRequest(req)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("extAuthErr: ", err.Error()))
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		return resultFailed
+	}
+
+	var cacheKey string
+	if ea.cache != nil {
+		cacheKey = ea.cacheKey(authReq)
+		if header := ea.getCache(cacheKey); header != nil {
+			ea.inject(req, header)
+			return ""
+		}
+	}
+
+	resp, err := ea.client.Do(authReq)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("extAuthErr: ", err.Error()))
+		if ea.spec.FailOpen {
+			return ""
+		}
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		return resultFailed
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyBytes))
+		ea.inject(req, resp.Header)
+		if ea.cache != nil {
+			ea.putCache(cacheKey, resp.Header)
+		}
+		return ""
+	}
+
+	ea.deny(ctx, resp)
+	return resultDenied
+}
+
+// newAuthRequest creates the request to the auth service, which carries
+// the method, URI and client IP of the original request by X-Forwarded-*
+// headers, and the selected headers of the original request. The request
+// is canceled once the original request is canceled.
+func (ea *ExtAuth) newAuthRequest(req context.HTTPRequest) (*http.Request, error) {
+	authReq, err := http.NewRequestWithContext(req.Std().Context(), http.MethodGet, ea.spec.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request failed: %v", err)
+	}
+
+	for _, h := range ea.spec.RequestHeaders {
+		for _, v := range req.Header().GetAll(h) {
+			authReq.Header.Add(h, v)
+		}
+	}
+
+	authReq.Header.Set(headerForwardedMethod, req.Method())
+	authReq.Header.Set(headerForwardedProto, req.Scheme())
+	authReq.Header.Set(headerForwardedHost, req.Host())
+	authReq.Header.Set(headerForwardedURI, req.Std().URL.RequestURI())
+	authReq.Header.Set(headerForwardedFor, req.RealIP())
+
+	return authReq, nil
+}
+
+func (ea *ExtAuth) inject(req context.HTTPRequest, header http.Header) {
+	for _, h := range ea.spec.UpstreamHeaders {
+		for _, v := range header.Values(h) {
+			req.Header().Add(h, v)
+		}
+	}
+}
+
+// deny returns the status code, headers and body of the auth service
+// response to the client.
+func (ea *ExtAuth) deny(ctx context.HTTPContext, resp *http.Response) {
+	w := ctx.Response()
+	w.SetStatusCode(resp.StatusCode)
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		logger.Errorf("%s: read body of auth service failed: %v", ea.filterSpec.Name(), err)
+	}
+	w.SetBody(bytes.NewReader(body))
+
+	ctx.AddTag(fmt.Sprintf("extAuth: denied with status code %d", resp.StatusCode))
+}
+
+// cacheKey returns the key of the decision, it's the hash of all the
+// information sent to the auth service.
+func (ea *ExtAuth) cacheKey(authReq *http.Request) string {
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	for _, name := range ea.spec.RequestHeaders {
+		write(name)
+		write(strings.Join(authReq.Header.Values(name), "\n"))
+	}
+	for _, name := range []string{headerForwardedMethod, headerForwardedProto,
+		headerForwardedHost, headerForwardedURI, headerForwardedFor} {
+		write(authReq.Header.Get(name))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (ea *ExtAuth) getCache(key string) http.Header {
+	ea.cacheMutex.Lock()
+	defer ea.cacheMutex.Unlock()
+
+	v, ok := ea.cache.Get(key)
+	if !ok {
+		return nil
+	}
+
+	d := v.(*decision)
+	if nowFunc().After(d.expiresAt) {
+		ea.cache.Remove(key)
+		return nil
+	}
+	return d.header
+}
+
+func (ea *ExtAuth) putCache(key string, header http.Header) {
+	d := &decision{header: http.Header{}, expiresAt: nowFunc().Add(ea.cacheTTL)}
+	for _, h := range ea.spec.UpstreamHeaders {
+		for _, v := range header.Values(h) {
+			d.header.Add(h, v)
+		}
+	}
+
+	ea.cacheMutex.Lock()
+	defer ea.cacheMutex.Unlock()
+	ea.cache.Add(key, d)
+}
+
+// Status returns status.
+func (ea *ExtAuth) Status() interface{} { return nil }
+
+// Close closes ExtAuth.
+func (ea *ExtAuth) Close() {}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extauth
+
+import (
+	"bytes"
+	stdcontext "context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func createExtAuth(spec *Spec) *ExtAuth {
+	meta := &httppipeline.FilterMetaSpec{
+		Name:     "ext-auth",
+		Kind:     Kind,
+		Pipeline: "pipeline-demo",
+	}
+	ea := &ExtAuth{}
+	ea.Init(httppipeline.MockFilterSpec(nil, nil, "", meta, spec))
+	return ea
+}
+
+func prepareCtx(req *http.Request) *contexttest.MockedHTTPContext {
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedStd = func() *http.Request {
+		return req
+	}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return req.Method
+	}
+	ctx.MockedRequest.MockedScheme = func() string {
+		return req.URL.Scheme
+	}
+	ctx.MockedRequest.MockedHost = func() string {
+		return req.Host
+	}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return "192.168.1.1"
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(req.Header)
+	}
+
+	statusCode := http.StatusOK
+	header := http.Header{}
+	body := &bytes.Buffer{}
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+		statusCode = code
+	}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		return statusCode
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) {
+		body.Reset()
+		body.ReadFrom(r)
+	}
+	ctx.MockedResponse.MockedBody = func() io.Reader {
+		return body
+	}
+	return ctx
+}
+
+func prepareOrderCtx(header map[string]string) *contexttest.MockedHTTPContext {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/api/orders?id=1", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return prepareCtx(req)
+}
+
+func newAuthServer(calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if r.Header.Get("X-Forwarded-Method") != http.MethodPost ||
+			r.Header.Get("X-Forwarded-Uri") != "/api/orders?id=1" ||
+			r.Header.Get("X-Forwarded-For") != "192.168.1.1" ||
+			r.Header.Get("X-Other") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "invalid token")
+			return
+		}
+
+		w.Header().Set("X-User-Id", "alice")
+		w.Header().Set("X-Internal", "secret")
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestExtAuth(t *testing.T) {
+	var calls int32
+	server := newAuthServer(&calls)
+	defer server.Close()
+
+	ea := createExtAuth(&Spec{
+		URL:             server.URL,
+		UpstreamHeaders: []string{"X-User-Id"},
+		CacheTTL:        "1m",
+	})
+
+	ctx := prepareOrderCtx(map[string]string{
+		"Authorization": "Bearer good",
+		"X-Other":       "other",
+		"X-User-Id":     "mallory",
+	})
+	if result := ea.Handle(ctx); result != "" {
+		t.Fatalf("request should be allowed, but got %s", result)
+	}
+	header := ctx.Request().Header()
+	if values := header.GetAll("X-User-Id"); len(values) != 1 || values[0] != "alice" {
+		t.Errorf("unexpected X-User-Id: %v", values)
+	}
+	if header.Get("X-Internal") != "" {
+		t.Errorf("headers not in upstreamHeaders should not be injected")
+	}
+
+	ctx = prepareOrderCtx(map[string]string{"Authorization": "Bearer bad"})
+	w := ctx.Response()
+	if result := ea.Handle(ctx); result != resultDenied || w.StatusCode() != http.StatusUnauthorized {
+		t.Fatalf("request should be denied, but got %s, %d", result, w.StatusCode())
+	}
+	body, _ := io.ReadAll(w.Body())
+	if string(body) != "invalid token" || w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Errorf("unexpected denied response: %s, %v", body, w.Header().Std())
+	}
+
+	// allowing decisions are cached, denying ones are not.
+	atomic.StoreInt32(&calls, 0)
+	ctx = prepareOrderCtx(map[string]string{"Authorization": "Bearer good"})
+	result := ea.Handle(ctx)
+	if result != "" || ctx.Request().Header().Get("X-User-Id") != "alice" || atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("allowing decision should be cached")
+	}
+	ea.Handle(prepareOrderCtx(map[string]string{"Authorization": "Bearer bad"}))
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("denying decision should not be cached")
+	}
+
+	// cached decisions expire.
+	nowFunc = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	defer func() { nowFunc = time.Now }()
+	ea.Handle(prepareOrderCtx(map[string]string{"Authorization": "Bearer good"}))
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("cached decision should expire")
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	var calls int32
+	server := newAuthServer(&calls)
+	server.Close()
+
+	ea := createExtAuth(&Spec{URL: server.URL, Timeout: "100ms"})
+	ctx := prepareOrderCtx(nil)
+	if result := ea.Handle(ctx); result != resultFailed || ctx.Response().StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("request should fail, but got %s, %d", result, ctx.Response().StatusCode())
+	}
+
+	ea = createExtAuth(&Spec{URL: server.URL, Timeout: "100ms", FailOpen: true})
+	if result := ea.Handle(prepareOrderCtx(nil)); result != "" {
+		t.Errorf("request should be allowed, but got %s", result)
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ea := createExtAuth(&Spec{URL: server.URL, Timeout: "10s"})
+
+	// the auth request is canceled with the original request.
+	stdctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(stdctx, http.MethodGet, "http://example.com/", nil)
+
+	start := time.Now()
+	if result := ea.Handle(prepareCtx(req)); result != resultFailed {
+		t.Errorf("request should fail, but got %s", result)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("auth request took too long: %v", d)
+	}
+}
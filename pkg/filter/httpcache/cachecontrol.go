@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// cacheControl is the parsed directives of Cache-Control headers,
+// the key is the lower cased directive name.
+//
+// Reference: https://tools.ietf.org/html/rfc7234#section-5.2
+type cacheControl map[string]string
+
+func parseCacheControl(values []string) cacheControl {
+	cc := cacheControl{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `" `)
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+
+			// NOTE: The first one wins if a directive is duplicated.
+			if _, exists := cc[name]; !exists {
+				cc[name] = arg
+			}
+		}
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, exists := cc[name]
+	return exists
+}
+
+// duration returns the delta-seconds argument of the directive,
+// the second return value is false if the directive is absent or invalid.
+func (cc cacheControl) duration(name string) (time.Duration, bool) {
+	arg, exists := cc[name]
+	if !exists {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// freshnessLifetime calculates the freshness lifetime of a response
+// for a shared cache, the second return value is false if the response
+// has no explicit expiration time.
+//
+// Reference: https://tools.ietf.org/html/rfc7234#section-4.2.1
+func freshnessLifetime(cc cacheControl, header http.Header, now time.Time) (time.Duration, bool) {
+	if d, ok := cc.duration("s-maxage"); ok {
+		return d, true
+	}
+	if d, ok := cc.duration("max-age"); ok {
+		return d, true
+	}
+
+	expires := header.Get(httpheader.KeyExpires)
+	if expires == "" {
+		return 0, false
+	}
+	// NOTE: An invalid Expires means already expired.
+	expiresAt, err := http.ParseTime(expires)
+	if err != nil {
+		return 0, true
+	}
+
+	date := now
+	if t, err := http.ParseTime(header.Get(httpheader.KeyDate)); err == nil {
+		date = t
+	}
+	if lifetime := expiresAt.Sub(date); lifetime > 0 {
+		return lifetime, true
+	}
+
+	return 0, true
+}
+
+// parseAge parses the Age header, it returns 0 for invalid values.
+func parseAge(header http.Header) time.Duration {
+	seconds, err := strconv.ParseInt(header.Get(httpheader.KeyAge), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// varyNames returns the canonical header names listed in Vary headers.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values(httpheader.KeyVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// etagMatch reports whether the If-None-Match header value matches etag,
+// by weak comparison.
+//
+// Reference: https://tools.ietf.org/html/rfc7232#section-3.2
+func etagMatch(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
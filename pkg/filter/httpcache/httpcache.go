@@ -0,0 +1,565 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpcache
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of HTTPCache.
+	Kind = "HTTPCache"
+
+	resultCached = "cached"
+
+	defaultMaxEntryBytes = 1024 * 1024
+)
+
+const (
+	freshnessFresh = iota
+	freshnessStaleWhileRevalidate
+	freshnessExpired
+)
+
+var results = []string{resultCached}
+
+// cacheableCodes are the status codes cacheable by default.
+// Reference: https://tools.ietf.org/html/rfc7231#section-6.1
+var cacheableCodes = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// hopByHopHeaders are not stored.
+// Reference: https://tools.ietf.org/html/rfc7230#section-6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// fnHandleNext handles ctx by the filters after the filter of filterSpec
+// in its pipeline, it is a variable so that it could be mocked in tests.
+var fnHandleNext = func(filterSpec *httppipeline.FilterSpec, ctx context.HTTPContext) (string, error) {
+	super := filterSpec.Super()
+	if super == nil {
+		return "", fmt.Errorf("no supervisor")
+	}
+
+	entity, exists := super.GetBusinessController(filterSpec.Pipeline())
+	if !exists {
+		return "", fmt.Errorf("pipeline %s not found", filterSpec.Pipeline())
+	}
+	pipeline, ok := entity.Instance().(*httppipeline.HTTPPipeline)
+	if !ok {
+		return "", fmt.Errorf("%s is not an HTTPPipeline", filterSpec.Pipeline())
+	}
+
+	return pipeline.HandleAfter(ctx, filterSpec.Name())
+}
+
+func init() {
+	httppipeline.Register(&HTTPCache{})
+}
+
+type (
+	// HTTPCache is the filter caching responses of GET requests
+	// in memory, it follows the semantics of a shared HTTP cache.
+	HTTPCache struct {
+		// NOTE: Put counters at the beginning for 64-bit alignment.
+		hits          uint64
+		misses        uint64
+		staleHits     uint64
+		revalidations uint64
+
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		maxEntryBytes int64
+		defaultTTL    time.Duration
+		cache         *lru
+	}
+
+	// discardResponseWriter discards the responses of the revalidations
+	// in background.
+	discardResponseWriter struct {
+		header http.Header
+	}
+
+	// Spec describes the HTTPCache.
+	Spec struct {
+		MaxBytes      int64  `yaml:"maxBytes" jsonschema:"required,minimum=1"`
+		MaxEntryBytes int64  `yaml:"maxEntryBytes,omitempty" jsonschema:"omitempty,minimum=1"`
+		DefaultTTL    string `yaml:"defaultTTL,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of HTTPCache.
+	Status struct {
+		Hits          uint64 `yaml:"hits"`
+		Misses        uint64 `yaml:"misses"`
+		StaleHits     uint64 `yaml:"staleHits"`
+		Revalidations uint64 `yaml:"revalidations"`
+		Entries       int    `yaml:"entries"`
+		Bytes         int64  `yaml:"bytes"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.MaxEntryBytes > spec.MaxBytes {
+		return fmt.Errorf("maxEntryBytes(%d) is greater than maxBytes(%d)", spec.MaxEntryBytes, spec.MaxBytes)
+	}
+	return nil
+}
+
+// Kind returns the kind of HTTPCache.
+func (c *HTTPCache) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of HTTPCache.
+func (c *HTTPCache) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of HTTPCache.
+func (c *HTTPCache) Description() string {
+	return "HTTPCache caches responses following the HTTP caching semantics."
+}
+
+// Results returns the results of HTTPCache.
+func (c *HTTPCache) Results() []string {
+	return results
+}
+
+// Init initializes HTTPCache.
+func (c *HTTPCache) Init(filterSpec *httppipeline.FilterSpec) {
+	c.filterSpec, c.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	c.reload(nil)
+}
+
+// Inherit inherits previous generation of HTTPCache.
+func (c *HTTPCache) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	c.filterSpec, c.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	c.reload(previousGeneration.(*HTTPCache))
+	previousGeneration.Close()
+}
+
+func (c *HTTPCache) reload(previousGeneration *HTTPCache) {
+	c.maxEntryBytes = defaultMaxEntryBytes
+	if c.spec.MaxEntryBytes > 0 {
+		c.maxEntryBytes = c.spec.MaxEntryBytes
+	}
+	if c.maxEntryBytes > c.spec.MaxBytes {
+		c.maxEntryBytes = c.spec.MaxBytes
+	}
+
+	if c.spec.DefaultTTL != "" {
+		d, err := time.ParseDuration(c.spec.DefaultTTL)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", c.spec.DefaultTTL, err)
+		}
+		c.defaultTTL = d
+	}
+
+	// NOTE: Keep the cached responses across generations, the entries
+	// stored by the previous generation are still valid.
+	if previousGeneration != nil {
+		c.cache = previousGeneration.cache
+		c.cache.resize(c.spec.MaxBytes)
+		return
+	}
+	c.cache = newLRU(c.spec.MaxBytes)
+}
+
+func (c *HTTPCache) key(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	return stringtool.Cat(r.Scheme(), "://", r.Host(), r.Path(), "?", r.Query())
+}
+
+// Handle handles HTTP request.
+func (c *HTTPCache) Handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if r.Method() != http.MethodGet {
+		return ctx.CallNextHandler("")
+	}
+
+	reqCC := parseCacheControl(r.Header().GetAll(httpheader.KeyCacheControl))
+	if reqCC.has("no-store") {
+		return ctx.CallNextHandler("")
+	}
+
+	key := c.key(ctx)
+	e := c.cache.get(key, r.Header())
+	if e == nil {
+		return c.fetch(ctx, key)
+	}
+
+	now := fasttime.Now()
+	switch c.freshness(e, reqCC, now) {
+	case freshnessFresh:
+		atomic.AddUint64(&c.hits, 1)
+		ctx.AddTag("httpCache: hit")
+		c.serve(ctx, e, now)
+		return ctx.CallNextHandler(resultCached)
+
+	case freshnessStaleWhileRevalidate:
+		// NOTE: The stale response is served at once, and only one
+		// request starts revalidating it in background.
+		if atomic.CompareAndSwapInt32(&e.revalidating, 0, 1) {
+			c.revalidateInBackground(ctx, key, e)
+		}
+		atomic.AddUint64(&c.staleHits, 1)
+		ctx.AddTag("httpCache: stale")
+		c.serve(ctx, e, now)
+		return ctx.CallNextHandler(resultCached)
+	}
+
+	return c.revalidate(ctx, key, e)
+}
+
+// revalidateInBackground revalidates the stale response by the rest of
+// the pipeline in background, with a copy of the request, because the
+// request is finished once the stale response is served.
+func (c *HTTPCache) revalidateInBackground(ctx context.HTTPContext, key string, e *entry) {
+	stdr := ctx.Request().Std().Clone(stdcontext.Background())
+	stdr.Header = ctx.Request().Header().Std().Clone()
+	stdr.Body = http.NoBody
+
+	go func() {
+		defer atomic.StoreInt32(&e.revalidating, 0)
+
+		w := &discardResponseWriter{header: http.Header{}}
+		bgCtx := context.New(w, stdr, tracing.NoopTracing, "httpCache revalidate")
+		bgCtx.SetHandlerCaller(func(lastResult string) string {
+			result, err := fnHandleNext(c.filterSpec, bgCtx)
+			if err != nil {
+				// NOTE: The status code prevents the empty response being stored.
+				logger.Errorf("revalidate %s failed: %v", key, err)
+				bgCtx.Response().SetStatusCode(http.StatusServiceUnavailable)
+			}
+			return result
+		})
+
+		c.revalidate(bgCtx, key, e)
+		bgCtx.Finish()
+	}()
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {}
+
+func (c *HTTPCache) freshness(e *entry, reqCC cacheControl, now time.Time) int {
+	if e.noCache || reqCC.has("no-cache") {
+		return freshnessExpired
+	}
+
+	age := e.age(now)
+	if maxAge, ok := reqCC.duration("max-age"); ok && age > maxAge {
+		return freshnessExpired
+	}
+
+	if age < e.lifetime {
+		return freshnessFresh
+	}
+	if !e.mustRevalidate && age < e.lifetime+e.staleWhileRevalidate {
+		return freshnessStaleWhileRevalidate
+	}
+
+	return freshnessExpired
+}
+
+// fetch forwards the request to the next handler and tries to store
+// the response.
+func (c *HTTPCache) fetch(ctx context.HTTPContext, key string) string {
+	atomic.AddUint64(&c.misses, 1)
+	ctx.AddTag("httpCache: miss")
+
+	result := ctx.CallNextHandler("")
+	c.store(ctx, key, fasttime.Now())
+	return result
+}
+
+// revalidate forwards the request with the validators of the stored
+// response, and serves the stored response if the upstream responds 304.
+func (c *HTTPCache) revalidate(ctx context.HTTPContext, key string, e *entry) string {
+	header := ctx.Request().Header()
+
+	// NOTE: The conditional request from the client is forwarded as it is,
+	// its 304 response is for the client rather than the cache.
+	var added []string
+	if header.Get(httpheader.KeyIfNoneMatch) == "" && header.Get(httpheader.KeyIfModifiedSince) == "" {
+		if etag := e.etag(); etag != "" {
+			header.Set(httpheader.KeyIfNoneMatch, etag)
+			added = append(added, httpheader.KeyIfNoneMatch)
+		}
+		if lastModified := e.lastModified(); lastModified != "" {
+			header.Set(httpheader.KeyIfModifiedSince, lastModified)
+			added = append(added, httpheader.KeyIfModifiedSince)
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+	for _, name := range added {
+		header.Del(name)
+	}
+
+	now := fasttime.Now()
+	w := ctx.Response()
+	if w.StatusCode() != http.StatusNotModified || len(added) == 0 {
+		atomic.AddUint64(&c.misses, 1)
+		ctx.AddTag("httpCache: miss")
+		c.store(ctx, key, now)
+		return result
+	}
+
+	atomic.AddUint64(&c.revalidations, 1)
+	ctx.AddTag("httpCache: revalidated")
+
+	if body, ok := w.Body().(io.ReadCloser); ok {
+		if err := body.Close(); err != nil {
+			logger.Warnf("close body failed: %v", err)
+		}
+	}
+
+	e = c.refresh(e, w.Header().Std(), now)
+	c.cache.put(key, e)
+	c.serve(ctx, e, now)
+
+	return result
+}
+
+// serve writes the stored response to the context, it responds 304
+// if the request is conditional and the stored response matches.
+func (c *HTTPCache) serve(ctx context.HTTPContext, e *entry, now time.Time) {
+	r, w := ctx.Request(), ctx.Response()
+
+	for key, values := range e.header {
+		w.Header().Del(key)
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set(httpheader.KeyAge, strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+
+	if notModified(r.Header(), e) {
+		w.Header().Del(httpheader.KeyContentLength)
+		w.SetStatusCode(http.StatusNotModified)
+		w.SetBody(nil)
+		return
+	}
+
+	w.SetStatusCode(e.statusCode)
+	w.SetBody(bytes.NewReader(e.body))
+}
+
+// notModified evaluates the conditional request against the stored response.
+// Reference: https://tools.ietf.org/html/rfc7232#section-6
+func notModified(header *httpheader.HTTPHeader, e *entry) bool {
+	if e.statusCode != http.StatusOK {
+		return false
+	}
+
+	if ifNoneMatch := header.Get(httpheader.KeyIfNoneMatch); ifNoneMatch != "" {
+		return etagMatch(ifNoneMatch, e.etag())
+	}
+
+	ifModifiedSince, err := http.ParseTime(header.Get(httpheader.KeyIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(e.lastModified())
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
+}
+
+// store stores the response in the context if it is storable,
+// the body is stored after it is flushed to the client completely.
+// Reference: https://tools.ietf.org/html/rfc7234#section-3
+func (c *HTTPCache) store(ctx context.HTTPContext, key string, now time.Time) {
+	r, w := ctx.Request(), ctx.Response()
+
+	if !cacheableCodes[w.StatusCode()] {
+		return
+	}
+
+	header := w.Header().Std()
+	cc := parseCacheControl(header.Values(httpheader.KeyCacheControl))
+	if cc.has("no-store") || cc.has("private") {
+		return
+	}
+	// NOTE: Responses setting cookies are never shared between clients.
+	if header.Get(httpheader.KeySetCookie) != "" {
+		return
+	}
+	if r.Header().Get(httpheader.KeyAuthorization) != "" &&
+		!cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return
+	}
+
+	varyValues := map[string]string{}
+	for _, name := range varyNames(header) {
+		if name == "*" {
+			return
+		}
+		varyValues[name] = strings.Join(r.Header().GetAll(name), ",")
+	}
+
+	e := &entry{
+		statusCode: w.StatusCode(),
+		header:     header.Clone(),
+		varyValues: varyValues,
+	}
+	for _, key := range hopByHopHeaders {
+		e.header.Del(key)
+	}
+	c.setFreshness(e, now)
+
+	// NOTE: The response is useless if it's neither fresh nor revalidatable.
+	if e.lifetime <= 0 && e.etag() == "" && e.lastModified() == "" {
+		return
+	}
+
+	if w.Body() == nil {
+		e.calcSize()
+		c.cache.put(key, e)
+		return
+	}
+
+	bodyLength := int64(0)
+	w.OnFlushBody(func(body []byte, complete bool) []byte {
+		if e == nil {
+			return body
+		}
+
+		bodyLength += int64(len(body))
+		if bodyLength > c.maxEntryBytes {
+			e = nil
+			return body
+		}
+
+		e.body = append(e.body, body...)
+		if complete {
+			e.calcSize()
+			c.cache.put(key, e)
+		}
+
+		return body
+	})
+}
+
+// refresh creates a new entry from the stored one, with the header
+// updated by the 304 response.
+// Reference: https://tools.ietf.org/html/rfc7234#section-4.3.4
+func (c *HTTPCache) refresh(e *entry, header http.Header, now time.Time) *entry {
+	ne := &entry{
+		statusCode: e.statusCode,
+		header:     e.header.Clone(),
+		body:       e.body,
+		varyValues: e.varyValues,
+	}
+
+	for key, values := range header {
+		if key == httpheader.KeyContentLength {
+			continue
+		}
+		ne.header[key] = values
+	}
+	for _, key := range hopByHopHeaders {
+		ne.header.Del(key)
+	}
+
+	c.setFreshness(ne, now)
+	ne.calcSize()
+
+	return ne
+}
+
+func (c *HTTPCache) setFreshness(e *entry, now time.Time) {
+	cc := parseCacheControl(e.header.Values(httpheader.KeyCacheControl))
+
+	e.responseTime = now
+	e.initialAge = parseAge(e.header)
+	e.header.Del(httpheader.KeyAge)
+
+	lifetime, explicit := freshnessLifetime(cc, e.header, now)
+	if !explicit {
+		lifetime = c.defaultTTL
+	}
+	e.lifetime = lifetime
+
+	e.staleWhileRevalidate, _ = cc.duration("stale-while-revalidate")
+	e.mustRevalidate = cc.has("must-revalidate") || cc.has("proxy-revalidate")
+	e.noCache = cc.has("no-cache")
+}
+
+// Status returns status.
+func (c *HTTPCache) Status() interface{} {
+	entries, bytes := c.cache.stat()
+	return &Status{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		StaleHits:     atomic.LoadUint64(&c.staleHits),
+		Revalidations: atomic.LoadUint64(&c.revalidations),
+		Entries:       entries,
+		Bytes:         bytes,
+	}
+}
+
+// Close closes HTTPCache.
+func (c *HTTPCache) Close() {}
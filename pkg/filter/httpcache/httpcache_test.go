@@ -0,0 +1,388 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+type upstream struct {
+	calls   int
+	handler func(ctx context.HTTPContext)
+}
+
+func newHTTPCache(spec *Spec) *HTTPCache {
+	meta := &httppipeline.FilterMetaSpec{
+		Name:     "http-cache",
+		Kind:     Kind,
+		Pipeline: "pipeline-demo",
+	}
+	c := &HTTPCache{}
+	c.Init(httppipeline.MockFilterSpec(nil, nil, "", meta, spec))
+	return c
+}
+
+func respond(code int, body string, header map[string]string) func(ctx context.HTTPContext) {
+	return func(ctx context.HTTPContext) {
+		w := ctx.Response()
+		w.SetStatusCode(code)
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+		w.SetBody(strings.NewReader(body))
+	}
+}
+
+func (u *upstream) do(c *HTTPCache, header map[string]string) (*httptest.ResponseRecorder, string) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/cached?a=1", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	ctx := context.New(w, req, tracing.NoopTracing, "no trace")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		u.calls++
+		u.handler(ctx)
+		return ""
+	})
+
+	result := c.Handle(ctx)
+	ctx.Finish()
+	return w, result
+}
+
+func body(w *httptest.ResponseRecorder) string {
+	b, _ := io.ReadAll(w.Body)
+	return string(b)
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{MaxBytes: 1024}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.MaxEntryBytes = 2048
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestFreshHit(t *testing.T) {
+	c := newHTTPCache(&Spec{MaxBytes: 1024 * 1024})
+	u := &upstream{handler: respond(http.StatusOK, "hello", map[string]string{
+		httpheader.KeyCacheControl: "max-age=60",
+		httpheader.KeyAge:          "10",
+	})}
+
+	w, result := u.do(c, nil)
+	if result != "" || w.Code != http.StatusOK || body(w) != "hello" {
+		t.Fatalf("unexpected response: %s, %d", result, w.Code)
+	}
+
+	w, result = u.do(c, nil)
+	if result != resultCached || w.Code != http.StatusOK || body(w) != "hello" {
+		t.Fatalf("unexpected response: %s, %d", result, w.Code)
+	}
+	if u.calls != 1 {
+		t.Errorf("upstream should be called once, but got %d", u.calls)
+	}
+	if age := w.Header().Get(httpheader.KeyAge); age != "10" {
+		t.Errorf("unexpected age: %s", age)
+	}
+
+	// request no-cache forces revalidation, a response without
+	// validators is fetched again.
+	u.do(c, map[string]string{httpheader.KeyCacheControl: "no-cache"})
+	if u.calls != 2 {
+		t.Errorf("upstream should be called twice, but got %d", u.calls)
+	}
+
+	// conditional request from the client.
+	u.handler = respond(http.StatusOK, "hello", map[string]string{
+		httpheader.KeyCacheControl: "max-age=60",
+		httpheader.KeyETag:         `"v1"`,
+	})
+	u.do(c, map[string]string{httpheader.KeyCacheControl: "no-cache"})
+	w, _ = u.do(c, map[string]string{httpheader.KeyIfNoneMatch: `W/"v1"`})
+	if w.Code != http.StatusNotModified || body(w) != "" {
+		t.Errorf("expected 304, but got %d", w.Code)
+	}
+
+	status := c.Status().(*Status)
+	if status.Hits != 2 || status.Misses != 3 || status.Entries != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestNotStored(t *testing.T) {
+	c := newHTTPCache(&Spec{MaxBytes: 1024 * 1024, DefaultTTL: "1m"})
+
+	cases := []struct {
+		code          int
+		reqHeader     map[string]string
+		respHeader    map[string]string
+		defaultStored bool
+	}{
+		{http.StatusOK, nil, map[string]string{httpheader.KeyCacheControl: "no-store"}, false},
+		{http.StatusOK, nil, map[string]string{httpheader.KeyCacheControl: "private, max-age=60"}, false},
+		{http.StatusOK, nil, map[string]string{httpheader.KeySetCookie: "a=b"}, false},
+		{http.StatusOK, nil, map[string]string{httpheader.KeyVary: "*"}, false},
+		{http.StatusInternalServerError, nil, map[string]string{httpheader.KeyCacheControl: "max-age=60"}, false},
+		{http.StatusOK, map[string]string{httpheader.KeyCacheControl: "no-store"}, nil, false},
+		{http.StatusOK, map[string]string{httpheader.KeyAuthorization: "Basic YTpi"}, nil, false},
+		{http.StatusOK, map[string]string{httpheader.KeyAuthorization: "Basic YTpi"},
+			map[string]string{httpheader.KeyCacheControl: "public, max-age=60"}, true},
+		{http.StatusOK, nil, nil, true},
+	}
+
+	for i, tc := range cases {
+		c.cache = newLRU(c.spec.MaxBytes)
+		u := &upstream{handler: respond(tc.code, "hello", tc.respHeader)}
+		u.do(c, tc.reqHeader)
+		u.do(c, tc.reqHeader)
+
+		stored := u.calls == 1
+		if stored != tc.defaultStored {
+			t.Errorf("case %d: stored should be %v", i, tc.defaultStored)
+		}
+	}
+}
+
+func TestVary(t *testing.T) {
+	c := newHTTPCache(&Spec{MaxBytes: 1024 * 1024})
+	u := &upstream{}
+	u.handler = func(ctx context.HTTPContext) {
+		encoding := ctx.Request().Header().Get(httpheader.KeyAcceptEncoding)
+		respond(http.StatusOK, "body-"+encoding, map[string]string{
+			httpheader.KeyCacheControl: "max-age=60",
+			httpheader.KeyVary:         "accept-encoding",
+		})(ctx)
+	}
+
+	gzip := map[string]string{httpheader.KeyAcceptEncoding: "gzip"}
+	br := map[string]string{httpheader.KeyAcceptEncoding: "br"}
+
+	u.do(c, gzip)
+	u.do(c, br)
+	w1, _ := u.do(c, gzip)
+	w2, _ := u.do(c, br)
+
+	if u.calls != 2 {
+		t.Errorf("upstream should be called twice, but got %d", u.calls)
+	}
+	if body(w1) != "body-gzip" || body(w2) != "body-br" {
+		t.Errorf("unexpected variants")
+	}
+	if entries, _ := c.cache.stat(); entries != 2 {
+		t.Errorf("expected 2 entries, but got %d", entries)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	c := newHTTPCache(&Spec{MaxBytes: 1024 * 1024})
+	u := &upstream{}
+	u.handler = func(ctx context.HTTPContext) {
+		header := map[string]string{
+			httpheader.KeyCacheControl: "max-age=0",
+			httpheader.KeyETag:         `"v1"`,
+			"X-Version":                "1",
+		}
+		if ctx.Request().Header().Get(httpheader.KeyIfNoneMatch) == `"v1"` {
+			header["X-Version"] = "2"
+			respond(http.StatusNotModified, "", header)(ctx)
+			return
+		}
+		respond(http.StatusOK, "hello", header)(ctx)
+	}
+
+	u.do(c, nil)
+	w, result := u.do(c, nil)
+	if u.calls != 2 || result != "" {
+		t.Fatalf("unexpected calls %d, result %s", u.calls, result)
+	}
+	if w.Code != http.StatusOK || body(w) != "hello" || w.Header().Get("X-Version") != "2" {
+		t.Errorf("unexpected response: %d, %v", w.Code, w.Header())
+	}
+
+	status := c.Status().(*Status)
+	if status.Revalidations != 1 || status.Misses != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	// the 304 from the upstream for the client's conditional request
+	// is forwarded as it is.
+	w, _ = u.do(c, map[string]string{httpheader.KeyIfNoneMatch: `"v1"`})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, but got %d", w.Code)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	c := newHTTPCache(&Spec{MaxBytes: 1024 * 1024})
+	u := &upstream{handler: respond(http.StatusOK, "v1", map[string]string{
+		httpheader.KeyCacheControl: "max-age=10, stale-while-revalidate=60",
+		httpheader.KeyAge:          "20",
+	})}
+	u.do(c, nil)
+
+	fnHandleNextBackup := fnHandleNext
+	defer func() {
+		fnHandleNext = fnHandleNextBackup
+	}()
+	var revalidations int32
+	release := make(chan struct{})
+	fnHandleNext = func(filterSpec *httppipeline.FilterSpec, ctx context.HTTPContext) (string, error) {
+		atomic.AddInt32(&revalidations, 1)
+		<-release
+		respond(http.StatusOK, "v2", map[string]string{
+			httpheader.KeyCacheControl: "max-age=10",
+		})(ctx)
+		return "", nil
+	}
+
+	// the stale response is served without waiting for the revalidation,
+	// and only one revalidation runs for concurrent requests.
+	for i := 0; i < 2; i++ {
+		w, result := u.do(c, nil)
+		if result != resultCached || body(w) != "v1" {
+			t.Errorf("request should be served with the stale response")
+		}
+	}
+	if u.calls != 1 {
+		t.Errorf("upstream should not be called by the requests, but got %d", u.calls)
+	}
+	close(release)
+
+	for i := 0; i < 50; i++ {
+		e := c.cache.get("http://example.com/cached?a=1", httpheader.New(http.Header{}))
+		if e != nil && string(e.body) == "v2" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&revalidations); n != 1 {
+		t.Errorf("stale response should be revalidated once, but got %d", n)
+	}
+
+	w, _ := u.do(c, nil)
+	if u.calls != 1 || body(w) != "v2" {
+		t.Errorf("unexpected calls %d, body %s", u.calls, body(w))
+	}
+
+	status := c.Status().(*Status)
+	if status.StaleHits != 2 || status.Hits != 1 || status.Misses != 2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	// must-revalidate disables serving stale responses.
+	u.handler = respond(http.StatusOK, "v3", map[string]string{
+		httpheader.KeyCacheControl: "max-age=10, stale-while-revalidate=60, must-revalidate",
+		httpheader.KeyAge:          "20",
+	})
+	u.do(c, map[string]string{httpheader.KeyCacheControl: "no-cache"})
+	u.do(c, nil)
+	if u.calls != 3 {
+		t.Errorf("upstream should be called 3 times, but got %d", u.calls)
+	}
+}
+
+func TestMaxEntryBytes(t *testing.T) {
+	c := newHTTPCache(&Spec{MaxBytes: 1024 * 1024, MaxEntryBytes: 4})
+	u := &upstream{handler: respond(http.StatusOK, "hello", map[string]string{
+		httpheader.KeyCacheControl: "max-age=60",
+	})}
+
+	u.do(c, nil)
+	w, _ := u.do(c, nil)
+	if u.calls != 2 || body(w) != "hello" {
+		t.Errorf("response larger than maxEntryBytes should not be stored")
+	}
+}
+
+func TestLRU(t *testing.T) {
+	l := newLRU(1000)
+	newEntry := func(size int64) *entry {
+		return &entry{size: size, header: http.Header{}}
+	}
+	header := httpheader.New(http.Header{})
+
+	l.put("a", newEntry(400))
+	l.put("b", newEntry(400))
+	if l.get("a", header) == nil {
+		t.Fatal("a should be cached")
+	}
+
+	// b is the least recently used.
+	l.put("c", newEntry(400))
+	if l.get("b", header) != nil || l.get("a", header) == nil || l.get("c", header) == nil {
+		t.Error("b should be evicted")
+	}
+	if entries, bytes := l.stat(); entries != 2 || bytes != 800 {
+		t.Errorf("unexpected stat: %d, %d", entries, bytes)
+	}
+
+	l.resize(500)
+	if entries, bytes := l.stat(); entries != 1 || bytes != 400 {
+		t.Errorf("unexpected stat: %d, %d", entries, bytes)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cc := parseCacheControl([]string{`max-age=60, No-Cache`, `s-maxage="30", max-age=10, private`})
+	if d, ok := cc.duration("max-age"); !ok || d.Seconds() != 60 {
+		t.Errorf("unexpected max-age: %v", d)
+	}
+	if d, ok := cc.duration("s-maxage"); !ok || d.Seconds() != 30 {
+		t.Errorf("unexpected s-maxage: %v", d)
+	}
+	if !cc.has("no-cache") || !cc.has("private") || cc.has("public") {
+		t.Errorf("unexpected directives: %v", cc)
+	}
+
+	header := http.Header{}
+	header.Set(httpheader.KeyDate, "Mon, 02 Jan 2006 15:04:05 GMT")
+	header.Set(httpheader.KeyExpires, "Mon, 02 Jan 2006 15:05:05 GMT")
+	if d, ok := freshnessLifetime(cacheControl{}, header, time.Time{}); !ok || d.Seconds() != 60 {
+		t.Errorf("unexpected lifetime: %v", d)
+	}
+
+	if !etagMatch(`"a", W/"b"`, `"b"`) || etagMatch(`"a"`, `"b"`) || !etagMatch("*", `"b"`) {
+		t.Errorf("unexpected etag match")
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpcache
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// maxVariants is the max number of variants of the same URL,
+	// the oldest one is dropped when exceeded.
+	maxVariants = 16
+	// entryOverhead is the estimated bytes of an entry besides its
+	// header and body.
+	entryOverhead = 256
+)
+
+type (
+	// entry is a cached response, it must not be modified after stored,
+	// except the revalidating flag.
+	entry struct {
+		statusCode int
+		header     http.Header
+		body       []byte
+		size       int64
+
+		// varyValues is the values of request headers nominated by Vary.
+		varyValues map[string]string
+
+		responseTime         time.Time
+		initialAge           time.Duration
+		lifetime             time.Duration
+		staleWhileRevalidate time.Duration
+		mustRevalidate       bool
+		noCache              bool
+
+		revalidating int32
+	}
+
+	lruItem struct {
+		key      string
+		variants []*entry
+		size     int64
+	}
+
+	// lru is a cache evicting the least recently used URL,
+	// with all its variants, when the total size exceeds maxBytes.
+	lru struct {
+		mutex    sync.Mutex
+		maxBytes int64
+		bytes    int64
+		entries  int
+		ll       *list.List
+		items    map[string]*list.Element
+	}
+)
+
+func (e *entry) age(now time.Time) time.Duration {
+	age := e.initialAge
+	if resident := now.Sub(e.responseTime); resident > 0 {
+		age += resident
+	}
+	return age
+}
+
+func (e *entry) etag() string {
+	return e.header.Get(httpheader.KeyETag)
+}
+
+func (e *entry) lastModified() string {
+	return e.header.Get(httpheader.KeyLastModified)
+}
+
+func (e *entry) calcSize() {
+	size := int64(entryOverhead + len(e.body))
+	for k, values := range e.header {
+		for _, v := range values {
+			size += int64(len(k) + len(v))
+		}
+	}
+	for k, v := range e.varyValues {
+		size += int64(len(k) + len(v))
+	}
+	e.size = size
+}
+
+func (e *entry) matchRequest(header *httpheader.HTTPHeader) bool {
+	for name, value := range e.varyValues {
+		if strings.Join(header.GetAll(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *entry) sameVariant(other *entry) bool {
+	if len(e.varyValues) != len(other.varyValues) {
+		return false
+	}
+	for name, value := range e.varyValues {
+		v, exists := other.varyValues[name]
+		if !exists || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *entry) sameVaryNames(other *entry) bool {
+	if len(e.varyValues) != len(other.varyValues) {
+		return false
+	}
+	for name := range e.varyValues {
+		if _, exists := other.varyValues[name]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func newLRU(maxBytes int64) *lru {
+	return &lru{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// get returns the variant of key matching the request header.
+func (l *lru) get(key string, header *httpheader.HTTPHeader) *entry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	element, exists := l.items[key]
+	if !exists {
+		return nil
+	}
+
+	item := element.Value.(*lruItem)
+	for i := len(item.variants) - 1; i >= 0; i-- {
+		if e := item.variants[i]; e.matchRequest(header) {
+			l.ll.MoveToFront(element)
+			return e
+		}
+	}
+
+	return nil
+}
+
+// put stores the entry as a variant of key, it replaces the same variant,
+// and drops variants with different Vary header names.
+func (l *lru) put(key string, e *entry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	element, exists := l.items[key]
+	if !exists {
+		element = l.ll.PushFront(&lruItem{key: key})
+		l.items[key] = element
+	} else {
+		l.ll.MoveToFront(element)
+	}
+	item := element.Value.(*lruItem)
+
+	variants := make([]*entry, 0, len(item.variants)+1)
+	for _, v := range item.variants {
+		if v.sameVaryNames(e) && !v.sameVariant(e) {
+			variants = append(variants, v)
+		}
+	}
+	if len(variants) >= maxVariants {
+		variants = variants[len(variants)-maxVariants+1:]
+	}
+	variants = append(variants, e)
+	l.setVariants(item, variants)
+
+	for l.bytes > l.maxBytes && l.ll.Len() > 0 {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *lru) setVariants(item *lruItem, variants []*entry) {
+	l.bytes -= item.size
+	l.entries -= len(item.variants)
+
+	item.variants, item.size = variants, 0
+	for _, v := range variants {
+		item.size += v.size
+	}
+
+	l.bytes += item.size
+	l.entries += len(item.variants)
+}
+
+func (l *lru) removeElement(element *list.Element) {
+	item := element.Value.(*lruItem)
+	l.setVariants(item, nil)
+	l.ll.Remove(element)
+	delete(l.items, item.key)
+}
+
+func (l *lru) stat() (entries int, bytes int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.entries, l.bytes
+}
+
+// resize changes the max bytes, and evicts entries if necessary.
+func (l *lru) resize(maxBytes int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.maxBytes = maxBytes
+	for l.bytes > l.maxBytes && l.ll.Len() > 0 {
+		l.removeElement(l.ll.Back())
+	}
+}
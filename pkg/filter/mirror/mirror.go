@@ -0,0 +1,483 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mirror
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+const (
+	// Kind is the kind of Mirror.
+	Kind = "Mirror"
+
+	defaultMaxConcurrency = 10
+	defaultQueueSize      = 100
+	defaultMaxBodySize    = 1024 * 1024
+	defaultTimeout        = 10 * time.Second
+
+	maxRecentDiffs = 10
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&Mirror{})
+}
+
+type (
+	// Mirror is the filter shadowing a sample of requests to another
+	// pipeline, the responses of the mirror pipeline are discarded but
+	// could be compared with the primary responses.
+	Mirror struct {
+		// NOTE: Put counters at the beginning for 64-bit alignment.
+		mirrored        uint64
+		dropped         uint64
+		skipped         uint64
+		failed          uint64
+		compared        uint64
+		diffs           uint64
+		statusCodeDiffs uint64
+		headerDiffs     uint64
+		bodyDiffs       uint64
+
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		getPipeline func(name string) (protocol.HTTPHandler, bool)
+		timeout     time.Duration
+		jobs        chan *job
+		done        chan struct{}
+
+		recentDiffsLock sync.Mutex
+		recentDiffs     []*Diff
+	}
+
+	// Spec describes the Mirror.
+	Spec struct {
+		Pipeline       string       `yaml:"pipeline" jsonschema:"required"`
+		Percentage     float64      `yaml:"percentage" jsonschema:"minimum=0,maximum=100"`
+		MaxConcurrency int          `yaml:"maxConcurrency,omitempty" jsonschema:"omitempty,minimum=1"`
+		QueueSize      int          `yaml:"queueSize" jsonschema:"minimum=0"`
+		MaxBodySize    int64        `yaml:"maxBodySize,omitempty" jsonschema:"omitempty,minimum=1"`
+		Timeout        string       `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+		Compare        *CompareSpec `yaml:"compare,omitempty" jsonschema:"omitempty"`
+	}
+
+	// CompareSpec describes how to compare the mirror responses with
+	// the primary responses.
+	CompareSpec struct {
+		Headers     []string `yaml:"headers,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		Body        bool     `yaml:"body" jsonschema:"omitempty"`
+		MaxBodySize int64    `yaml:"maxBodySize,omitempty" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Status is the status of Mirror.
+	Status struct {
+		Mirrored        uint64  `yaml:"mirrored"`
+		Dropped         uint64  `yaml:"dropped"`
+		Skipped         uint64  `yaml:"skipped"`
+		Failed          uint64  `yaml:"failed"`
+		Compared        uint64  `yaml:"compared"`
+		Diffs           uint64  `yaml:"diffs"`
+		StatusCodeDiffs uint64  `yaml:"statusCodeDiffs"`
+		HeaderDiffs     uint64  `yaml:"headerDiffs"`
+		BodyDiffs       uint64  `yaml:"bodyDiffs"`
+		RecentDiffs     []*Diff `yaml:"recentDiffs,omitempty"`
+	}
+
+	// Diff is the difference between a mirror response and
+	// its primary response.
+	Diff struct {
+		Time    string   `yaml:"time"`
+		Method  string   `yaml:"method"`
+		Path    string   `yaml:"path"`
+		Details []string `yaml:"details"`
+	}
+
+	job struct {
+		req     *http.Request
+		primary *response
+	}
+
+	// response is a response kept for comparison, only the first
+	// bytes of its body are kept.
+	response struct {
+		statusCode int
+		header     http.Header
+		body       []byte
+		bodySize   int64
+	}
+
+	// responseWriter is the http.ResponseWriter of the mirror pipeline,
+	// it keeps at most limit bytes of the body.
+	responseWriter struct {
+		response
+		limit int64
+	}
+)
+
+func newResponseWriter(limit int64) *responseWriter {
+	return &responseWriter{
+		response: response{
+			statusCode: http.StatusOK,
+			header:     http.Header{},
+		},
+		limit: limit,
+	}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.response.write(p, w.limit)
+	return len(p), nil
+}
+
+func (r *response) write(p []byte, limit int64) {
+	r.bodySize += int64(len(p))
+	if remain := limit - int64(len(r.body)); remain > 0 {
+		if int64(len(p)) > remain {
+			p = p[:remain]
+		}
+		r.body = append(r.body, p...)
+	}
+}
+
+// Kind returns the kind of Mirror.
+func (m *Mirror) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of Mirror.
+func (m *Mirror) DefaultSpec() interface{} {
+	return &Spec{
+		Percentage:     100,
+		MaxConcurrency: defaultMaxConcurrency,
+		QueueSize:      defaultQueueSize,
+		MaxBodySize:    defaultMaxBodySize,
+		Timeout:        defaultTimeout.String(),
+	}
+}
+
+// Description returns the description of Mirror.
+func (m *Mirror) Description() string {
+	return "Mirror shadows a sample of requests to another pipeline."
+}
+
+// Results returns the results of Mirror.
+func (m *Mirror) Results() []string {
+	return results
+}
+
+// Init initializes Mirror.
+func (m *Mirror) Init(filterSpec *httppipeline.FilterSpec) {
+	m.filterSpec, m.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+
+	entity, exists := filterSpec.Super().GetSystemController(rawconfigtrafficcontroller.Kind)
+	if !exists {
+		panic(fmt.Errorf("BUG: raw config traffic controller not found"))
+	}
+
+	rctc, ok := entity.Instance().(*rawconfigtrafficcontroller.RawConfigTrafficController)
+	if !ok {
+		panic(fmt.Errorf("BUG: want *RawConfigTrafficController, got %T", entity.Instance()))
+	}
+	m.getPipeline = rctc.GetHTTPPipeline
+
+	m.reload()
+}
+
+// Inherit inherits previous generation of Mirror.
+func (m *Mirror) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	m.Init(filterSpec)
+}
+
+func (m *Mirror) reload() {
+	if m.spec.MaxConcurrency <= 0 {
+		m.spec.MaxConcurrency = defaultMaxConcurrency
+	}
+	if m.spec.MaxBodySize <= 0 {
+		m.spec.MaxBodySize = defaultMaxBodySize
+	}
+	if m.spec.Compare != nil && m.spec.Compare.MaxBodySize <= 0 {
+		m.spec.Compare.MaxBodySize = defaultMaxBodySize
+	}
+
+	m.timeout = defaultTimeout
+	if m.spec.Timeout != "" {
+		timeout, err := time.ParseDuration(m.spec.Timeout)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", m.spec.Timeout, err)
+		} else {
+			m.timeout = timeout
+		}
+	}
+
+	m.jobs = make(chan *job, m.spec.QueueSize)
+	m.done = make(chan struct{})
+	for i := 0; i < m.spec.MaxConcurrency; i++ {
+		go m.run()
+	}
+}
+
+// Handle mirrors the request if it is sampled.
+func (m *Mirror) Handle(ctx context.HTTPContext) string {
+	req := m.newMirrorRequest(ctx)
+	if req == nil {
+		return ctx.CallNextHandler("")
+	}
+
+	if m.spec.Compare == nil {
+		m.submit(&job{req: req})
+		return ctx.CallNextHandler("")
+	}
+
+	result := ctx.CallNextHandler("")
+	m.capturePrimary(ctx, req)
+	return result
+}
+
+// newMirrorRequest creates the request to mirror, it returns nil if
+// the request is not sampled or its body is too large. The mirror
+// request holds a copy of the body, so the primary request is not
+// affected by the speed of the mirror pipeline.
+func (m *Mirror) newMirrorRequest(ctx context.HTTPContext) *http.Request {
+	if m.spec.Percentage < 100 && rand.Float64()*100 >= m.spec.Percentage {
+		return nil
+	}
+
+	r := ctx.Request()
+	body, err := io.ReadAll(io.LimitReader(r.Body(), m.spec.MaxBodySize+1))
+	r.SetBody(io.MultiReader(bytes.NewReader(body), r.Body()))
+	if err != nil {
+		logger.Errorf("read body of %s %s failed: %v", r.Method(), r.Path(), err)
+		atomic.AddUint64(&m.failed, 1)
+		return nil
+	}
+	if int64(len(body)) > m.spec.MaxBodySize {
+		atomic.AddUint64(&m.skipped, 1)
+		return nil
+	}
+
+	stdr := r.Std()
+	u := *stdr.URL
+	u.Path, u.RawPath, u.RawQuery = r.Path(), "", r.Query()
+
+	req, err := http.NewRequest(r.Method(), u.String(), bytes.NewReader(body))
+	if err != nil {
+		logger.Errorf("create mirror request of %s %s failed: %v", r.Method(), r.Path(), err)
+		atomic.AddUint64(&m.failed, 1)
+		return nil
+	}
+	req.Header = r.Header().Std().Clone()
+	req.Host = r.Host()
+	req.RemoteAddr = stdr.RemoteAddr
+
+	return req
+}
+
+// capturePrimary captures the primary response for comparison, the
+// job is submitted after the whole body has been flushed to the client.
+func (m *Mirror) capturePrimary(ctx context.HTTPContext, req *http.Request) {
+	w := ctx.Response()
+	primary := &response{
+		statusCode: w.StatusCode(),
+		header:     w.Header().Std().Clone(),
+	}
+
+	if !m.spec.Compare.Body || w.Body() == nil {
+		m.submit(&job{req: req, primary: primary})
+		return
+	}
+
+	limit := m.spec.Compare.MaxBodySize
+	w.OnFlushBody(func(body []byte, complete bool) []byte {
+		primary.write(body, limit)
+		if complete {
+			m.submit(&job{req: req, primary: primary})
+		}
+		return body
+	})
+}
+
+// submit never blocks, the job is dropped if the queue is full.
+func (m *Mirror) submit(j *job) {
+	select {
+	case m.jobs <- j:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+func (m *Mirror) run() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case j := <-m.jobs:
+			m.execute(j)
+		}
+	}
+}
+
+func (m *Mirror) execute(j *job) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("mirror %s %s to pipeline %s panic: %v\n%s",
+				j.req.Method, j.req.URL.Path, m.spec.Pipeline, err, debug.Stack())
+			atomic.AddUint64(&m.failed, 1)
+		}
+	}()
+
+	handler, exists := m.getPipeline(m.spec.Pipeline)
+	if !exists {
+		logger.Warnf("mirror pipeline %s not found", m.spec.Pipeline)
+		atomic.AddUint64(&m.failed, 1)
+		return
+	}
+
+	stdctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), m.timeout)
+	defer cancel()
+
+	var limit int64
+	if j.primary != nil && m.spec.Compare.Body {
+		limit = m.spec.Compare.MaxBodySize
+	}
+	w := newResponseWriter(limit)
+
+	ctx := context.New(w, j.req.WithContext(stdctx), tracing.NoopTracing, "no trace")
+	handler.Handle(ctx)
+	ctx.Finish()
+	atomic.AddUint64(&m.mirrored, 1)
+
+	if j.primary != nil {
+		m.compare(j, &w.response)
+	}
+}
+
+func (m *Mirror) compare(j *job, mirror *response) {
+	primary := j.primary
+	details := []string{}
+
+	if primary.statusCode != mirror.statusCode {
+		details = append(details, fmt.Sprintf("status code: %d != %d",
+			primary.statusCode, mirror.statusCode))
+		atomic.AddUint64(&m.statusCodeDiffs, 1)
+	}
+
+	headerDiff := false
+	for _, key := range m.spec.Compare.Headers {
+		pv, mv := primary.header.Values(key), mirror.header.Values(key)
+		if !equalValues(pv, mv) {
+			details = append(details, fmt.Sprintf("header %s: %q != %q", key, pv, mv))
+			headerDiff = true
+		}
+	}
+	if headerDiff {
+		atomic.AddUint64(&m.headerDiffs, 1)
+	}
+
+	if m.spec.Compare.Body {
+		if primary.bodySize != mirror.bodySize || !bytes.Equal(primary.body, mirror.body) {
+			details = append(details, fmt.Sprintf("body: %d bytes != %d bytes",
+				primary.bodySize, mirror.bodySize))
+			atomic.AddUint64(&m.bodyDiffs, 1)
+		}
+	}
+
+	atomic.AddUint64(&m.compared, 1)
+	if len(details) == 0 {
+		return
+	}
+
+	atomic.AddUint64(&m.diffs, 1)
+	diff := &Diff{
+		Time:    time.Now().Format(time.RFC3339),
+		Method:  j.req.Method,
+		Path:    j.req.URL.Path,
+		Details: details,
+	}
+	logger.Debugf("mirror response of %s %s differs: %v", diff.Method, diff.Path, details)
+
+	m.recentDiffsLock.Lock()
+	defer m.recentDiffsLock.Unlock()
+	m.recentDiffs = append(m.recentDiffs, diff)
+	if len(m.recentDiffs) > maxRecentDiffs {
+		m.recentDiffs = m.recentDiffs[len(m.recentDiffs)-maxRecentDiffs:]
+	}
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns status.
+func (m *Mirror) Status() interface{} {
+	m.recentDiffsLock.Lock()
+	recentDiffs := append([]*Diff(nil), m.recentDiffs...)
+	m.recentDiffsLock.Unlock()
+
+	return &Status{
+		Mirrored:        atomic.LoadUint64(&m.mirrored),
+		Dropped:         atomic.LoadUint64(&m.dropped),
+		Skipped:         atomic.LoadUint64(&m.skipped),
+		Failed:          atomic.LoadUint64(&m.failed),
+		Compared:        atomic.LoadUint64(&m.compared),
+		Diffs:           atomic.LoadUint64(&m.diffs),
+		StatusCodeDiffs: atomic.LoadUint64(&m.statusCodeDiffs),
+		HeaderDiffs:     atomic.LoadUint64(&m.headerDiffs),
+		BodyDiffs:       atomic.LoadUint64(&m.bodyDiffs),
+		RecentDiffs:     recentDiffs,
+	}
+}
+
+// Close closes Mirror.
+func (m *Mirror) Close() {
+	close(m.done)
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mirror
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+type testHandler func(ctx context.HTTPContext) string
+
+func (h testHandler) Handle(ctx context.HTTPContext) string {
+	return h(ctx)
+}
+
+func newTestMirror(t *testing.T, yamlSpec string, handler protocol.HTTPHandler) *Mirror {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	filterSpec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m := &Mirror{filterSpec: filterSpec, spec: filterSpec.FilterSpec().(*Spec)}
+	m.getPipeline = func(name string) (protocol.HTTPHandler, bool) {
+		if name != "mirror-pipeline" {
+			return nil, false
+		}
+		return handler, true
+	}
+	m.reload()
+	return m
+}
+
+// serve runs the request through the mirror filter, the primary
+// pipeline responds with the given status code and body.
+func serve(m *Mirror, body string, statusCode int, respBody string) {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/api?x=1", strings.NewReader(body))
+	req.Header.Set("X-Test", "primary")
+	ctx := context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		data, _ := io.ReadAll(ctx.Request().Body())
+		if string(data) != body {
+			panic("primary request body changed")
+		}
+		ctx.Response().SetStatusCode(statusCode)
+		ctx.Response().Header().Set("X-Version", "v1")
+		ctx.Response().SetBody(strings.NewReader(respBody))
+		return lastResult
+	})
+	m.Handle(ctx)
+	ctx.Finish()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("condition not met in time")
+}
+
+func TestMirror(t *testing.T) {
+	reqs := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	handler := testHandler(func(ctx context.HTTPContext) string {
+		data, _ := io.ReadAll(ctx.Request().Body())
+		reqs <- ctx.Request().Std()
+		bodies <- string(data)
+		return ""
+	})
+
+	m := newTestMirror(t, `
+kind: Mirror
+name: mirror
+pipeline: mirror-pipeline
+`, handler)
+	defer m.Close()
+
+	serve(m, "hello", http.StatusOK, "world")
+
+	select {
+	case req := <-reqs:
+		if req.Method != http.MethodPost || req.URL.Path != "/api" || req.URL.RawQuery != "x=1" {
+			t.Errorf("unexpected mirror request: %s %s", req.Method, req.URL)
+		}
+		if req.Host != "example.com" || req.Header.Get("X-Test") != "primary" {
+			t.Errorf("unexpected mirror request host %s or header %v", req.Host, req.Header)
+		}
+		if body := <-bodies; body != "hello" {
+			t.Errorf("mirror request body should be hello, but got %s", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("request is not mirrored")
+	}
+
+	waitFor(t, func() bool { return m.Status().(*Status).Mirrored == 1 })
+}
+
+func TestMirrorSkip(t *testing.T) {
+	var calls int32
+	handler := testHandler(func(ctx context.HTTPContext) string {
+		atomic.AddInt32(&calls, 1)
+		return ""
+	})
+
+	m := newTestMirror(t, `
+kind: Mirror
+name: mirror
+pipeline: mirror-pipeline
+percentage: 0
+`, handler)
+	for i := 0; i < 10; i++ {
+		serve(m, "hello", http.StatusOK, "world")
+	}
+	m.Close()
+
+	m = newTestMirror(t, `
+kind: Mirror
+name: mirror
+pipeline: mirror-pipeline
+maxBodySize: 4
+`, handler)
+	serve(m, "hello", http.StatusOK, "world")
+	m.Close()
+
+	if s := m.Status().(*Status); s.Skipped != 1 {
+		t.Errorf("skipped should be 1, but got %d", s.Skipped)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("mirror pipeline should not be called, but got %d calls", n)
+	}
+}
+
+func TestMirrorDrop(t *testing.T) {
+	block := make(chan struct{})
+	handler := testHandler(func(ctx context.HTTPContext) string {
+		<-block
+		return ""
+	})
+
+	m := newTestMirror(t, `
+kind: Mirror
+name: mirror
+pipeline: mirror-pipeline
+maxConcurrency: 1
+queueSize: 1
+`, handler)
+	defer m.Close()
+
+	for i := 0; i < 5; i++ {
+		serve(m, "hello", http.StatusOK, "world")
+	}
+
+	// one request is being handled and one is in the queue.
+	waitFor(t, func() bool { return m.Status().(*Status).Dropped >= 3 })
+	close(block)
+	waitFor(t, func() bool {
+		s := m.Status().(*Status)
+		return s.Mirrored+s.Dropped == 5
+	})
+}
+
+func TestMirrorCompare(t *testing.T) {
+	handler := testHandler(func(ctx context.HTTPContext) string {
+		switch ctx.Request().Path() {
+		case "/api":
+			ctx.Response().SetStatusCode(http.StatusInternalServerError)
+			ctx.Response().Header().Set("X-Version", "v2")
+			ctx.Response().SetBody(strings.NewReader("word"))
+		}
+		return ""
+	})
+
+	m := newTestMirror(t, `
+kind: Mirror
+name: mirror
+pipeline: mirror-pipeline
+compare:
+  headers: ["X-Version"]
+  body: true
+`, handler)
+
+	serve(m, "hello", http.StatusOK, "world")
+	waitFor(t, func() bool { return m.Status().(*Status).Compared == 1 })
+
+	s := m.Status().(*Status)
+	if s.Diffs != 1 || s.StatusCodeDiffs != 1 || s.HeaderDiffs != 1 || s.BodyDiffs != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	if len(s.RecentDiffs) != 1 || len(s.RecentDiffs[0].Details) != 3 {
+		t.Fatalf("unexpected recent diffs: %+v", s.RecentDiffs)
+	}
+	if d := s.RecentDiffs[0]; d.Method != http.MethodPost || d.Path != "/api" {
+		t.Errorf("unexpected diff: %+v", d)
+	}
+
+	// same responses except the body beyond the compared prefix.
+	m.Close()
+	handler = testHandler(func(ctx context.HTTPContext) string {
+		ctx.Response().Header().Set("X-Version", "v1")
+		ctx.Response().SetBody(strings.NewReader("world"))
+		return ""
+	})
+	m = newTestMirror(t, `
+kind: Mirror
+name: mirror
+pipeline: mirror-pipeline
+compare:
+  headers: ["X-Version"]
+  body: true
+  maxBodySize: 2
+`, handler)
+	defer m.Close()
+
+	serve(m, "hello", http.StatusOK, "world")
+	waitFor(t, func() bool { return m.Status().(*Status).Compared == 1 })
+	if s := m.Status().(*Status); s.Diffs != 0 {
+		t.Errorf("responses should be the same, but got %+v", s.RecentDiffs[0])
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	w := newResponseWriter(3)
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("ab"))
+	w.Write([]byte("cde"))
+
+	if w.statusCode != http.StatusNotFound || string(w.body) != "abc" || w.bodySize != 5 {
+		t.Errorf("unexpected response: %d %q %d", w.statusCode, w.body, w.bodySize)
+	}
+}
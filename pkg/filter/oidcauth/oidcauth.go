@@ -0,0 +1,506 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidcauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filter/validator"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of OIDCAuth.
+	Kind = "OIDCAuth"
+
+	resultRedirected   = "redirected"
+	resultUnauthorized = "unauthorized"
+	resultServerError  = "serverError"
+
+	defaultCookieName     = "easegress_oidc"
+	defaultSessionTimeout = 24 * time.Hour
+	defaultTimeout        = 10 * time.Second
+	defaultClockSkew      = time.Minute
+	// defaultTokenLifetime is used when the IdP returns neither an ID
+	// token nor expires_in when refreshing.
+	defaultTokenLifetime = 5 * time.Minute
+	loginTimeout         = 10 * time.Minute
+
+	stateCookieSuffix = "_state"
+)
+
+var (
+	results = []string{resultRedirected, resultUnauthorized, resultServerError}
+
+	defaultScopes = []string{"openid", "profile", "email"}
+
+	// for unit testing cases to mock 'time.Now' only
+	nowFunc = time.Now
+)
+
+func init() {
+	httppipeline.Register(&OIDCAuth{})
+}
+
+type (
+	// OIDCAuth is filter OIDCAuth.
+	OIDCAuth struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		provider       *provider
+		codec          *cookieCodec
+		callbackPath   string
+		secureCookie   bool
+		sessionTimeout time.Duration
+	}
+
+	// Spec describes the OIDCAuth.
+	Spec struct {
+		// Issuer is the issuer URL of the OpenID provider, the provider
+		// is discovered by its /.well-known/openid-configuration.
+		Issuer       string `yaml:"issuer" jsonschema:"required,format=uri"`
+		ClientID     string `yaml:"clientId" jsonschema:"required"`
+		ClientSecret string `yaml:"clientSecret,omitempty" jsonschema:"omitempty"`
+		// RedirectURL is the callback URL registered in the provider,
+		// requests to its path are handled by the filter.
+		RedirectURL string   `yaml:"redirectURL" jsonschema:"required,format=uri"`
+		Scopes      []string `yaml:"scopes,omitempty" jsonschema:"omitempty"`
+
+		CookieName string `yaml:"cookieName,omitempty" jsonschema:"omitempty"`
+		// CookieSecret is the secret to encrypt the session cookie.
+		CookieSecret   string `yaml:"cookieSecret" jsonschema:"required,minLength=16"`
+		CookieDomain   string `yaml:"cookieDomain,omitempty" jsonschema:"omitempty"`
+		SessionTimeout string `yaml:"sessionTimeout,omitempty" jsonschema:"omitempty,format=duration"`
+
+		// LogoutPath is the path to log out, the session cookie is
+		// removed and the user is redirected to the provider to log out.
+		LogoutPath            string `yaml:"logoutPath,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PostLogoutRedirectURL string `yaml:"postLogoutRedirectURL,omitempty" jsonschema:"omitempty,format=uri"`
+
+		// ClaimsToHeaders forwards the claims of the ID token to the upstream.
+		ClaimsToHeaders validator.ClaimsToHeaders `yaml:"claimsToHeaders,omitempty" jsonschema:"omitempty"`
+
+		// Timeout is the timeout of requests to the provider.
+		Timeout string `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	u, err := url.Parse(spec.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("invalid redirectURL: %v", err)
+	}
+	if !u.IsAbs() || u.Path == "" || u.Path == "/" {
+		return fmt.Errorf("redirectURL must be an absolute URL with a non-root path")
+	}
+	if spec.LogoutPath == u.Path {
+		return fmt.Errorf("logoutPath conflicts with the path of redirectURL")
+	}
+	return nil
+}
+
+// Kind returns the kind of OIDCAuth.
+func (o *OIDCAuth) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of OIDCAuth.
+func (o *OIDCAuth) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of OIDCAuth.
+func (o *OIDCAuth) Description() string {
+	return "OIDCAuth authenticates browser users by OpenID Connect."
+}
+
+// Results returns the results of OIDCAuth.
+func (o *OIDCAuth) Results() []string {
+	return results
+}
+
+// Init initializes OIDCAuth.
+func (o *OIDCAuth) Init(filterSpec *httppipeline.FilterSpec) {
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	o.reload()
+}
+
+// Inherit inherits previous generation of OIDCAuth.
+func (o *OIDCAuth) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	o.Init(filterSpec)
+}
+
+func (o *OIDCAuth) reload() {
+	if o.spec.CookieName == "" {
+		o.spec.CookieName = defaultCookieName
+	}
+	if len(o.spec.Scopes) == 0 {
+		o.spec.Scopes = defaultScopes
+	}
+
+	o.sessionTimeout = defaultSessionTimeout
+	if d, err := time.ParseDuration(o.spec.SessionTimeout); err == nil && d > 0 {
+		o.sessionTimeout = d
+	}
+	timeout := defaultTimeout
+	if d, err := time.ParseDuration(o.spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	u, _ := url.Parse(o.spec.RedirectURL)
+	o.callbackPath = u.Path
+	o.secureCookie = u.Scheme == "https"
+
+	o.codec = newCookieCodec(o.spec.CookieSecret)
+	o.provider = newProvider(o.spec, timeout)
+}
+
+// Handle authenticates HTTPContext.
+func (o *OIDCAuth) Handle(ctx context.HTTPContext) string {
+	result := o.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (o *OIDCAuth) handle(ctx context.HTTPContext) string {
+	req := ctx.Request()
+
+	switch req.Path() {
+	case o.callbackPath:
+		return o.callback(ctx)
+	case o.spec.LogoutPath:
+		if o.spec.LogoutPath != "" {
+			return o.logout(ctx)
+		}
+	}
+
+	o.spec.ClaimsToHeaders.Strip(req.Header())
+
+	s := o.loadSession(req)
+	if s != nil && s.expired(nowFunc()) {
+		s = o.refresh(ctx, s)
+	}
+	if s == nil {
+		return o.login(ctx)
+	}
+
+	o.spec.ClaimsToHeaders.Forward(req.Header(), s.Claims)
+	return ""
+}
+
+func (o *OIDCAuth) loadSession(req context.HTTPRequest) *session {
+	cookie, err := req.Cookie(o.spec.CookieName)
+	if err != nil {
+		return nil
+	}
+
+	s := &session{}
+	if err = o.codec.decode(purposeSession, cookie.Value, s); err != nil {
+		return nil
+	}
+
+	if nowFunc().Sub(time.Unix(s.CreatedAt, 0)) >= o.sessionTimeout {
+		return nil
+	}
+
+	return s
+}
+
+// refresh refreshes the tokens of the expired session, it returns nil
+// if the session could not be refreshed.
+func (o *OIDCAuth) refresh(ctx context.HTTPContext, s *session) *session {
+	if s.RefreshToken == "" {
+		return nil
+	}
+
+	metadata, idToken, err := o.provider.discover()
+	if err != nil {
+		logger.Errorf("%s: discover provider failed: %v", o.filterSpec.Name(), err)
+		return nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", s.RefreshToken)
+	tr, err := o.provider.token(metadata.TokenEndpoint, form)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("oidc refresh failed: ", err.Error()))
+		return nil
+	}
+
+	ns, err := o.newSession(tr, idToken, "", s)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("oidc refresh failed: ", err.Error()))
+		return nil
+	}
+
+	if err = o.saveSession(ctx, ns); err != nil {
+		logger.Errorf("%s: save session failed: %v", o.filterSpec.Name(), err)
+		return nil
+	}
+
+	return ns
+}
+
+// newSession creates a session from the token response, the claims of
+// the previous session are kept if there's no ID token in the response.
+func (o *OIDCAuth) newSession(tr *tokenResponse, idToken *validator.JWTValidator, nonce string, prev *session) (*session, error) {
+	now := nowFunc()
+	s := &session{
+		RefreshToken: tr.RefreshToken,
+		CreatedAt:    now.Unix(),
+	}
+
+	if prev != nil {
+		s.CreatedAt = prev.CreatedAt
+		s.Claims = prev.Claims
+		// NOTE: Refresh tokens are not always rotated.
+		if s.RefreshToken == "" {
+			s.RefreshToken = prev.RefreshToken
+		}
+	}
+
+	switch {
+	case tr.IDToken != "":
+		claims, err := idToken.ValidateToken(tr.IDToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID token: %v", err)
+		}
+		if nonce != "" && claims["nonce"] != nonce {
+			return nil, fmt.Errorf("invalid ID token: nonce mismatch")
+		}
+		exp, ok := claims["exp"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid ID token: no exp claim")
+		}
+		s.ExpiresAt = int64(exp)
+		s.Claims = o.keepClaims(claims)
+	case prev == nil:
+		return nil, fmt.Errorf("no ID token in the token response")
+	case tr.ExpiresIn > 0:
+		s.ExpiresAt = now.Unix() + tr.ExpiresIn
+	default:
+		s.ExpiresAt = now.Add(defaultTokenLifetime).Unix()
+	}
+
+	return s, nil
+}
+
+// keepClaims keeps only the claims to forward to the upstream, to
+// limit the size of the session cookie.
+func (o *OIDCAuth) keepClaims(claims map[string]interface{}) map[string]interface{} {
+	kept := map[string]interface{}{}
+	for claim := range o.spec.ClaimsToHeaders {
+		if v, ok := claims[claim]; ok {
+			kept[claim] = v
+		}
+	}
+	return kept
+}
+
+func (o *OIDCAuth) saveSession(ctx context.HTTPContext, s *session) error {
+	value, err := o.codec.encode(purposeSession, s)
+	if err != nil {
+		return err
+	}
+
+	maxAge := time.Unix(s.CreatedAt, 0).Add(o.sessionTimeout).Sub(nowFunc())
+	o.setCookie(ctx, o.spec.CookieName, value, int(maxAge/time.Second))
+	return nil
+}
+
+// setCookie sets a cookie, the cookie is removed if maxAge is negative.
+func (o *OIDCAuth) setCookie(ctx context.HTTPContext, name, value string, maxAge int) {
+	ctx.Response().SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   o.spec.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   o.secureCookie,
+		HttpOnly: true,
+		// NOTE: Lax is required to send the state cookie to the
+		// callback, which is redirected from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (o *OIDCAuth) redirect(ctx context.HTTPContext, location string) string {
+	w := ctx.Response()
+	w.Header().Set(httpheader.KeyLocation, location)
+	w.SetStatusCode(http.StatusFound)
+	return resultRedirected
+}
+
+func (o *OIDCAuth) unauthorized(ctx context.HTTPContext, err error) string {
+	ctx.Response().SetStatusCode(http.StatusUnauthorized)
+	ctx.AddTag(stringtool.Cat("oidc: ", err.Error()))
+	return resultUnauthorized
+}
+
+func (o *OIDCAuth) serverError(ctx context.HTTPContext, err error) string {
+	ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+	ctx.AddTag(stringtool.Cat("oidc: ", err.Error()))
+	return resultServerError
+}
+
+// login redirects the user to the provider to log in, only GET and
+// HEAD requests are redirected since the request body would be lost.
+func (o *OIDCAuth) login(ctx context.HTTPContext) string {
+	req := ctx.Request()
+	if m := req.Method(); m != http.MethodGet && m != http.MethodHead {
+		return o.unauthorized(ctx, fmt.Errorf("no valid session"))
+	}
+
+	metadata, _, err := o.provider.discover()
+	if err != nil {
+		return o.serverError(ctx, err)
+	}
+
+	ls := &loginState{
+		State:     randomString(16),
+		Nonce:     randomString(16),
+		Verifier:  randomString(32),
+		URL:       req.Std().URL.RequestURI(),
+		ExpiresAt: nowFunc().Add(loginTimeout).Unix(),
+	}
+	value, err := o.codec.encode(purposeState, ls)
+	if err != nil {
+		return o.serverError(ctx, err)
+	}
+	o.setCookie(ctx, o.spec.CookieName+stateCookieSuffix, value, int(loginTimeout/time.Second))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.spec.ClientID)
+	query.Set("redirect_uri", o.spec.RedirectURL)
+	query.Set("scope", strings.Join(o.spec.Scopes, " "))
+	query.Set("state", ls.State)
+	query.Set("nonce", ls.Nonce)
+	query.Set("code_challenge", codeChallenge(ls.Verifier))
+	query.Set("code_challenge_method", "S256")
+
+	return o.redirect(ctx, addQuery(metadata.AuthorizationEndpoint, query))
+}
+
+// callback handles the authorization response from the provider.
+func (o *OIDCAuth) callback(ctx context.HTTPContext) string {
+	req := ctx.Request()
+	stateCookie := o.spec.CookieName + stateCookieSuffix
+
+	cookie, err := req.Cookie(stateCookie)
+	if err != nil {
+		return o.unauthorized(ctx, fmt.Errorf("no login state"))
+	}
+	o.setCookie(ctx, stateCookie, "", -1)
+
+	ls := &loginState{}
+	if err = o.codec.decode(purposeState, cookie.Value, ls); err != nil {
+		return o.unauthorized(ctx, fmt.Errorf("invalid login state: %v", err))
+	}
+	if nowFunc().Unix() >= ls.ExpiresAt {
+		return o.unauthorized(ctx, fmt.Errorf("login state expired"))
+	}
+
+	query := req.Std().URL.Query()
+	if query.Get("state") != ls.State {
+		return o.unauthorized(ctx, fmt.Errorf("state mismatch"))
+	}
+	if e := query.Get("error"); e != "" {
+		return o.unauthorized(ctx, fmt.Errorf("%s: %s", e, query.Get("error_description")))
+	}
+
+	metadata, idToken, err := o.provider.discover()
+	if err != nil {
+		return o.serverError(ctx, err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", query.Get("code"))
+	form.Set("redirect_uri", o.spec.RedirectURL)
+	form.Set("code_verifier", ls.Verifier)
+	tr, err := o.provider.token(metadata.TokenEndpoint, form)
+	if err != nil {
+		return o.unauthorized(ctx, fmt.Errorf("exchange code failed: %v", err))
+	}
+
+	s, err := o.newSession(tr, idToken, ls.Nonce, nil)
+	if err != nil {
+		return o.unauthorized(ctx, err)
+	}
+	if err = o.saveSession(ctx, s); err != nil {
+		return o.serverError(ctx, err)
+	}
+
+	// NOTE: Protocol relative URLs are not allowed to avoid open redirection.
+	location := ls.URL
+	if !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") {
+		location = "/"
+	}
+	return o.redirect(ctx, location)
+}
+
+// logout removes the session cookie, and redirects the user to the
+// provider to log out if the provider supports RP-initiated logout.
+func (o *OIDCAuth) logout(ctx context.HTTPContext) string {
+	o.setCookie(ctx, o.spec.CookieName, "", -1)
+
+	location := o.spec.PostLogoutRedirectURL
+	if location == "" {
+		location = "/"
+	}
+
+	metadata, _, err := o.provider.discover()
+	if err != nil || metadata.EndSessionEndpoint == "" {
+		return o.redirect(ctx, location)
+	}
+
+	query := url.Values{}
+	query.Set("client_id", o.spec.ClientID)
+	if o.spec.PostLogoutRedirectURL != "" {
+		query.Set("post_logout_redirect_uri", o.spec.PostLogoutRedirectURL)
+	}
+
+	return o.redirect(ctx, addQuery(metadata.EndSessionEndpoint, query))
+}
+
+func addQuery(endpoint string, query url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + query.Encode()
+	}
+	return endpoint + "?" + query.Encode()
+}
+
+// Status returns status.
+func (o *OIDCAuth) Status() interface{} { return nil }
+
+// Close closes OIDCAuth.
+func (o *OIDCAuth) Close() {
+	o.provider.close()
+}
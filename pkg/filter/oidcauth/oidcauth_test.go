@@ -0,0 +1,386 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidcauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// idp is a minimal OpenID provider for testing.
+type idp struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex     sync.Mutex
+	challenge string
+	nonce     string
+	refreshes int
+}
+
+func newIDP(t *testing.T) *idp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &idp{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"end_session_endpoint":   p.URL + "/logout",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA", "kid": "k1",
+				"n": encode(key.N.Bytes()),
+				"e": encode(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+func (p *idp) idToken(nonce string) string {
+	claims := jwt.MapClaims{
+		"iss":    p.URL,
+		"aud":    "dashboard",
+		"sub":    "alice",
+		"groups": []string{"dev", "ops"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	s, _ := token.SignedString(p.key)
+	return s
+}
+
+func (p *idp) token(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	r.ParseForm()
+	id, secret, _ := r.BasicAuth()
+	if id != "dashboard" || secret != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		if r.Form.Get("code") != "code" || codeChallenge(r.Form.Get("code_verifier")) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access",
+			"id_token":      p.idToken(p.nonce),
+			"refresh_token": "refresh",
+			"expires_in":    3600,
+		})
+	case "refresh_token":
+		if r.Form.Get("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		p.refreshes++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"expires_in":   3600,
+		})
+	}
+}
+
+func createOIDCAuth(p *idp) *OIDCAuth {
+	spec := &Spec{
+		Issuer:                p.URL,
+		ClientID:              "dashboard",
+		ClientSecret:          "secret",
+		RedirectURL:           "https://gateway.example.com/oauth2/callback",
+		CookieSecret:          "0123456789abcdef",
+		LogoutPath:            "/logout",
+		PostLogoutRedirectURL: "https://gateway.example.com/",
+		ClaimsToHeaders:       map[string]string{"sub": "X-User-Id", "groups": "X-User-Groups"},
+	}
+	meta := &httppipeline.FilterMetaSpec{
+		Name:     "oidc-auth",
+		Kind:     Kind,
+		Pipeline: "pipeline-demo",
+	}
+	o := &OIDCAuth{}
+	o.Init(httppipeline.MockFilterSpec(nil, nil, "", meta, spec))
+	return o
+}
+
+func prepareCtx(method, target string, cookies []*http.Cookie, header map[string]string) *contexttest.MockedHTTPContext {
+	req, _ := http.NewRequest(method, target, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedStd = func() *http.Request {
+		return req
+	}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return req.Method
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return req.URL.Path
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(req.Header)
+	}
+	ctx.MockedRequest.MockedCookie = func(name string) (*http.Cookie, error) {
+		return req.Cookie(name)
+	}
+
+	w := httptest.NewRecorder()
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+		w.Code = code
+	}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		return w.Code
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(w.Header())
+	}
+	ctx.MockedResponse.MockedSetCookie = func(cookie *http.Cookie) {
+		http.SetCookie(w, cookie)
+	}
+	return ctx
+}
+
+func cookieOf(ctx *contexttest.MockedHTTPContext, name string) *http.Cookie {
+	resp := &http.Response{Header: ctx.Response().Header().Std()}
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{RedirectURL: "https://gateway.example.com/callback", LogoutPath: "/logout"}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.LogoutPath = "/callback"
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	spec.RedirectURL = "https://gateway.example.com"
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestCookieCodec(t *testing.T) {
+	c := newCookieCodec("0123456789abcdef")
+	value, err := c.encode(purposeSession, &session{RefreshToken: "refresh", ExpiresAt: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &session{}
+	if err = c.decode(purposeSession, value, s); err != nil || s.RefreshToken != "refresh" || s.ExpiresAt != 100 {
+		t.Errorf("unexpected session: %+v, %v", s, err)
+	}
+
+	tampered := []byte(value)
+	if i := len(tampered) / 2; tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+	if c.decode(purposeSession, string(tampered), s) == nil {
+		t.Errorf("tampered cookie should be rejected")
+	}
+	if newCookieCodec("another secret").decode(purposeSession, value, s) == nil {
+		t.Errorf("cookie encrypted by another secret should be rejected")
+	}
+	if c.decode(purposeState, value, &loginState{}) == nil {
+		t.Errorf("session cookie should not be accepted as state cookie")
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newIDP(t)
+	defer p.Close()
+	o := createOIDCAuth(p)
+	defer o.Close()
+
+	// not logged in, redirect to the provider.
+	ctx := prepareCtx(http.MethodGet, "https://gateway.example.com/dashboard?tab=1", nil, nil)
+	w := ctx.Response()
+	if result := o.Handle(ctx); result != resultRedirected || w.StatusCode() != http.StatusFound {
+		t.Fatalf("unexpected response: %s, %d", result, w.StatusCode())
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	query := location.Query()
+	if !strings.HasPrefix(location.String(), p.URL+"/authorize") ||
+		query.Get("client_id") != "dashboard" ||
+		query.Get("code_challenge_method") != "S256" ||
+		query.Get("redirect_uri") != "https://gateway.example.com/oauth2/callback" {
+		t.Fatalf("unexpected location: %s", location)
+	}
+	stateCookie := cookieOf(ctx, defaultCookieName+stateCookieSuffix)
+	if stateCookie == nil || !stateCookie.HttpOnly || !stateCookie.Secure {
+		t.Fatalf("unexpected state cookie: %v", stateCookie)
+	}
+
+	// non-GET requests are rejected instead of redirected.
+	ctx = prepareCtx(http.MethodPost, "https://gateway.example.com/api", nil, nil)
+	if result := o.Handle(ctx); result != resultUnauthorized || ctx.Response().StatusCode() != http.StatusUnauthorized {
+		t.Fatalf("unexpected response: %s, %d", result, ctx.Response().StatusCode())
+	}
+
+	// the provider authorizes the user.
+	p.mutex.Lock()
+	p.challenge, p.nonce = query.Get("code_challenge"), query.Get("nonce")
+	p.mutex.Unlock()
+
+	// callback with mismatched state.
+	ctx = prepareCtx(http.MethodGet, "https://gateway.example.com/oauth2/callback?code=code&state=x",
+		[]*http.Cookie{stateCookie}, nil)
+	if o.Handle(ctx) != resultUnauthorized {
+		t.Fatalf("callback with mismatched state should fail")
+	}
+
+	target := "https://gateway.example.com/oauth2/callback?code=code&state=" + query.Get("state")
+	ctx = prepareCtx(http.MethodGet, target, []*http.Cookie{stateCookie}, nil)
+	if result := o.Handle(ctx); result != resultRedirected || ctx.Response().Header().Get("Location") != "/dashboard?tab=1" {
+		t.Fatalf("unexpected response: %s, %v", result, ctx.Response().Header().Std())
+	}
+	sessionCookie := cookieOf(ctx, defaultCookieName)
+	if sessionCookie == nil || sessionCookie.Value == "" {
+		t.Fatalf("session cookie should be set")
+	}
+
+	// logged in, the claims are forwarded and spoofed headers are stripped.
+	ctx = prepareCtx(http.MethodGet, "https://gateway.example.com/dashboard",
+		[]*http.Cookie{sessionCookie}, map[string]string{"X-User-Id": "mallory"})
+	if result := o.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	header := ctx.Request().Header()
+	if header.Get("X-User-Id") != "alice" || header.Get("X-User-Groups") != "dev,ops" {
+		t.Errorf("unexpected upstream header: %v", header.Std())
+	}
+
+	// the tokens expired, the session is refreshed.
+	nowFunc = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	defer func() { nowFunc = time.Now }()
+	ctx = prepareCtx(http.MethodGet, "https://gateway.example.com/dashboard",
+		[]*http.Cookie{sessionCookie}, nil)
+	result := o.Handle(ctx)
+	if result != "" || ctx.Request().Header().Get("X-User-Id") != "alice" || p.refreshes != 1 {
+		t.Fatalf("session should be refreshed: %s, %d", result, p.refreshes)
+	}
+	if cookieOf(ctx, defaultCookieName) == nil {
+		t.Errorf("refreshed session cookie should be set")
+	}
+
+	// the session timed out.
+	nowFunc = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	ctx = prepareCtx(http.MethodGet, "https://gateway.example.com/dashboard",
+		[]*http.Cookie{sessionCookie}, nil)
+	if o.Handle(ctx) != resultRedirected {
+		t.Fatalf("timed out session should be redirected to log in")
+	}
+	nowFunc = time.Now
+
+	// log out.
+	ctx = prepareCtx(http.MethodGet, "https://gateway.example.com/logout",
+		[]*http.Cookie{sessionCookie}, nil)
+	result = o.Handle(ctx)
+	location, _ = url.Parse(ctx.Response().Header().Get("Location"))
+	if result != resultRedirected || !strings.HasPrefix(location.String(), p.URL+"/logout") ||
+		location.Query().Get("post_logout_redirect_uri") != "https://gateway.example.com/" {
+		t.Fatalf("unexpected response: %s, %s", result, location)
+	}
+	if c := cookieOf(ctx, defaultCookieName); c == nil || c.MaxAge >= 0 {
+		t.Errorf("session cookie should be removed: %v", c)
+	}
+}
+
+func TestOpenRedirect(t *testing.T) {
+	p := newIDP(t)
+	defer p.Close()
+	o := createOIDCAuth(p)
+	defer o.Close()
+
+	ctx := prepareCtx(http.MethodGet, "https://gateway.example.com//evil.example.com/", nil, nil)
+	o.Handle(ctx)
+	location, _ := url.Parse(ctx.Response().Header().Get("Location"))
+	query := location.Query()
+	p.challenge, p.nonce = query.Get("code_challenge"), query.Get("nonce")
+
+	target := "https://gateway.example.com/oauth2/callback?code=code&state=" + query.Get("state")
+	stateCookie := cookieOf(ctx, defaultCookieName+stateCookieSuffix)
+	ctx = prepareCtx(http.MethodGet, target, []*http.Cookie{stateCookie}, nil)
+	if result := o.Handle(ctx); result != resultRedirected || ctx.Response().Header().Get("Location") != "/" {
+		t.Fatalf("unexpected response: %s, %v", result, ctx.Response().Header().Std())
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidcauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/filter/validator"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	// minDiscoveryInterval is the min interval of retrying failed
+	// discoveries, to avoid flooding the IdP.
+	minDiscoveryInterval = 5 * time.Second
+)
+
+type (
+	// provider is the OpenID provider discovered from the issuer.
+	provider struct {
+		spec   *Spec
+		client *http.Client
+
+		mutex         sync.Mutex
+		metadata      *providerMetadata
+		idToken       *validator.JWTValidator
+		lastDiscovery time.Time
+	}
+
+	providerMetadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		EndSessionEndpoint    string `json:"end_session_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		IDToken      string `json:"id_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+)
+
+func newProvider(spec *Spec, timeout time.Duration) *provider {
+	return &provider{
+		spec:   spec,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// discover returns the metadata of the provider, and the validator of
+// ID tokens. The discovery is retried by later requests if it fails.
+func (p *provider) discover() (*providerMetadata, *validator.JWTValidator, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, p.idToken, nil
+	}
+
+	if time.Since(p.lastDiscovery) < minDiscoveryInterval {
+		return nil, nil, fmt.Errorf("discovery of %s failed recently", p.spec.Issuer)
+	}
+	p.lastDiscovery = time.Now()
+
+	resp, err := p.client.Get(strings.TrimSuffix(p.spec.Issuer, "/") + discoveryPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("discovery of %s failed: status code %d", p.spec.Issuer, resp.StatusCode)
+	}
+
+	metadata := &providerMetadata{}
+	if err = json.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(p.spec.Issuer, "/") {
+		return nil, nil, fmt.Errorf("issuer mismatch: expected %s, but got %s", p.spec.Issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("incomplete metadata of %s", p.spec.Issuer)
+	}
+
+	p.metadata = metadata
+	p.idToken = validator.NewJWTValidator(&validator.JWTValidatorSpec{
+		JWKS:      &validator.JWKSSpec{URL: metadata.JWKSURI, Timeout: p.spec.Timeout},
+		Issuer:    metadata.Issuer,
+		Audiences: []string{p.spec.ClientID},
+		ClockSkew: defaultClockSkew.String(),
+	})
+
+	return p.metadata, p.idToken, nil
+}
+
+// token sends a token request of the grant type to the token endpoint.
+func (p *provider) token(endpoint string, form url.Values) (*tokenResponse, error) {
+	form.Set("client_id", p.spec.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.spec.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.spec.ClientID), url.QueryEscape(p.spec.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &tokenResponse{}
+	if err = json.Unmarshal(body, tr); err != nil {
+		return nil, fmt.Errorf("invalid token response: status code %d", resp.StatusCode)
+	}
+	if tr.Error != "" {
+		return nil, fmt.Errorf("%s: %s", tr.Error, tr.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: status code %d", resp.StatusCode)
+	}
+
+	return tr, nil
+}
+
+func (p *provider) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.idToken != nil {
+		p.idToken.Close()
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidcauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// purposeSession and purposeState are the purposes of the cookies,
+	// they are authenticated as the additional data of AES-GCM, so that
+	// a cookie of one purpose can't be used as the other one.
+	purposeSession = "session"
+	purposeState   = "state"
+)
+
+type (
+	// session is the login session kept in the session cookie.
+	session struct {
+		RefreshToken string                 `json:"rt,omitempty"`
+		Claims       map[string]interface{} `json:"c,omitempty"`
+		// ExpiresAt is the expiry of the tokens, the tokens are
+		// refreshed, or the user logs in again after that.
+		ExpiresAt int64 `json:"exp"`
+		// CreatedAt is the login time, the session expires after
+		// the session timeout regardless of refreshing.
+		CreatedAt int64 `json:"iat"`
+	}
+
+	// loginState is the state of an authorization request, it's kept
+	// in the state cookie until the callback.
+	loginState struct {
+		State     string `json:"s"`
+		Nonce     string `json:"n"`
+		Verifier  string `json:"v"`
+		URL       string `json:"u"`
+		ExpiresAt int64  `json:"exp"`
+	}
+
+	// cookieCodec encrypts and authenticates cookie values by AES-GCM,
+	// so that clients could neither read nor forge them.
+	cookieCodec struct {
+		aead cipher.AEAD
+	}
+)
+
+func newCookieCodec(secret string) *cookieCodec {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &cookieCodec{aead: aead}
+}
+
+func (c *cookieCodec) encode(purpose string, v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := c.aead.Seal(nonce, nonce, plaintext, []byte(purpose))
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func (c *cookieCodec) decode(purpose, value string, v interface{}) error {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n {
+		return fmt.Errorf("cookie value too short")
+	}
+
+	plaintext, err := c.aead.Open(nil, ciphertext[:n], ciphertext[n:], []byte(purpose))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
+
+// randomString returns a URL safe random string of n bytes entropy,
+// it's used as state, nonce and PKCE code verifier.
+func randomString(n int) string {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// codeChallenge returns the S256 PKCE code challenge of the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (s *session) expired(now time.Time) bool {
+	return now.Unix() >= s.ExpiresAt
+}
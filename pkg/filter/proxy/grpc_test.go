@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestGRPCProxy(t *testing.T) {
+	sendRequest := fnSendRequest
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+	defer func() {
+		fnSendRequest = sendRequest
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("test", healthpb.HealthCheckResponse_SERVING)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	yamlSpec := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+mainPool:
+  protocol: h2c
+  servers:
+  - url: http://%s
+  loadBalance:
+    policy: roundRobin
+`, listener.Addr())
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	proxy := &Proxy{}
+	proxy.Init(spec)
+	defer proxy.Close()
+
+	gateway := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.New(w, r, tracing.NoopTracing, "no trace")
+		ctx.SetHandlerCaller(func(lastResult string) string {
+			return lastResult
+		})
+		if r.Header.Get("X-Reject") != "" {
+			ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		} else {
+			proxy.Handle(ctx)
+		}
+		ctx.Finish()
+	}), &http2.Server{}))
+	defer gateway.Close()
+
+	conn, err := grpc.Dial(gateway.Listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 5*time.Second)
+	defer cancel()
+
+	// The status of a unary call is in the trailer.
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("want SERVING, got %v", resp.Status)
+	}
+
+	// The status of an error is in the header of the Trailers-Only response.
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("want NotFound, got %v", err)
+	}
+
+	// The error generated by the gateway is converted to gRPC status.
+	rejectCtx := metadata.AppendToOutgoingContext(ctx, "x-reject", "true")
+	_, err = client.Check(rejectCtx, &healthpb.HealthCheckRequest{Service: "test"})
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("want Unavailable, got %v", err)
+	}
+
+	// The messages of streams are flushed immediately.
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []healthpb.HealthCheckResponse_ServingStatus{
+		healthpb.HealthCheckResponse_SERVING,
+		healthpb.HealthCheckResponse_NOT_SERVING,
+	} {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Status != want {
+			t.Errorf("want %v, got %v", want, resp.Status)
+		}
+		healthServer.SetServingStatus("test", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	cancel()
+
+	// The gRPC status is counted in statistics.
+	var totalCodes map[int]uint64
+	for i := 0; i < 100; i++ {
+		totalCodes = proxy.mainPool.status().Stat.TotalCodes()
+		if totalCodes[http.StatusNotFound] == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if totalCodes[http.StatusOK] == 0 || totalCodes[http.StatusNotFound] != 1 {
+		t.Errorf("unexpected codes: %v", totalCodes)
+	}
+}
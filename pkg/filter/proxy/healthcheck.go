@@ -0,0 +1,285 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 3 * time.Second
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultHealthCheckHealthyThreshold   = 1
+)
+
+type (
+	// HealthCheckSpec is the spec of the active health check of a pool.
+	HealthCheckSpec struct {
+		Path string `yaml:"path" jsonschema:"required,pattern=^/"`
+		// Codes are the status codes which mean the server is healthy,
+		// all 2xx and 3xx codes are used if it is empty.
+		Codes              []int  `yaml:"codes,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		Interval           string `yaml:"interval,omitempty" jsonschema:"omitempty,format=duration"`
+		Timeout            string `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+		UnhealthyThreshold int    `yaml:"unhealthyThreshold,omitempty" jsonschema:"omitempty,minimum=1"`
+		HealthyThreshold   int    `yaml:"healthyThreshold,omitempty" jsonschema:"omitempty,minimum=1"`
+	}
+
+	healthChecker struct {
+		spec               *HealthCheckSpec
+		interval           time.Duration
+		timeout            time.Duration
+		unhealthyThreshold int
+		healthyThreshold   int
+
+		client *http.Client
+
+		mutex  sync.RWMutex
+		health map[string]*serverHealth
+
+		done chan struct{}
+	}
+
+	// serverHealth is the health state of a server, it is keyed by
+	// the server URL so that it survives the update of servers.
+	serverHealth struct {
+		healthy   bool
+		fails     int
+		passes    int
+		lastError string
+	}
+)
+
+// Validate validates HealthCheckSpec.
+func (s HealthCheckSpec) Validate() error {
+	var err error
+	interval, timeout := defaultHealthCheckInterval, defaultHealthCheckTimeout
+	if s.Interval != "" {
+		if interval, err = time.ParseDuration(s.Interval); err != nil {
+			return fmt.Errorf("invalid interval of health check: %v", err)
+		}
+	}
+	if s.Timeout != "" {
+		if timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout of health check: %v", err)
+		}
+	}
+	if interval <= 0 || timeout <= 0 {
+		return fmt.Errorf("interval and timeout of health check must be positive")
+	}
+	if timeout > interval {
+		return fmt.Errorf("timeout(%s) of health check is longer than interval(%s)", timeout, interval)
+	}
+	return nil
+}
+
+// newHealthChecker creates a health checker, the probes use the TLS config
+// of the pool, so they verify and are verified by the servers like requests.
+func newHealthChecker(spec *HealthCheckSpec, protocol string, tlsConfig *tls.Config) *healthChecker {
+	hc := &healthChecker{
+		spec:               spec,
+		interval:           defaultHealthCheckInterval,
+		timeout:            defaultHealthCheckTimeout,
+		unhealthyThreshold: spec.UnhealthyThreshold,
+		healthyThreshold:   spec.HealthyThreshold,
+		health:             make(map[string]*serverHealth),
+		done:               make(chan struct{}),
+	}
+
+	if d, err := time.ParseDuration(spec.Interval); err == nil && d > 0 {
+		hc.interval = d
+	}
+	if d, err := time.ParseDuration(spec.Timeout); err == nil && d > 0 {
+		hc.timeout = d
+	}
+	if hc.unhealthyThreshold <= 0 {
+		hc.unhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	if hc.healthyThreshold <= 0 {
+		hc.healthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: protocol == protocolHTTP2,
+	}
+	if protocol == protocolH2C {
+		transport = newH2CTransport(&net.Dialer{Timeout: hc.timeout})
+	}
+
+	hc.client = &http.Client{
+		Timeout:   hc.timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return hc
+}
+
+// run probes the servers returned by fn periodically, and calls onChange
+// once the health state of any server changed in a round.
+func (hc *healthChecker) run(fn func() []*Server, onChange func()) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		if hc.checkServers(fn()) {
+			onChange()
+		}
+
+		select {
+		case <-hc.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *healthChecker) checkServers(servers []*Server) bool {
+	errs := make([]error, len(servers))
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(servers))
+	for i, server := range servers {
+		go func(i int, server *Server) {
+			defer wg.Done()
+			errs[i] = hc.probe(server)
+		}(i, server)
+	}
+	wg.Wait()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	changed := false
+	health := make(map[string]*serverHealth, len(servers))
+	for i, server := range servers {
+		h := hc.health[server.URL]
+		if h == nil {
+			h = &serverHealth{healthy: true}
+		}
+		health[server.URL] = h
+
+		if hc.updateHealth(server, h, errs[i]) {
+			changed = true
+		}
+	}
+	// NOTE: Servers removed from the pool are dropped here.
+	hc.health = health
+
+	return changed
+}
+
+func (hc *healthChecker) updateHealth(server *Server, h *serverHealth, err error) bool {
+	if err != nil {
+		h.passes, h.lastError = 0, err.Error()
+		h.fails++
+		if h.healthy && h.fails >= hc.unhealthyThreshold {
+			h.healthy = false
+			logger.Warnf("server %s turned unhealthy: %v", server.URL, err)
+			return true
+		}
+		return false
+	}
+
+	h.fails, h.lastError = 0, ""
+	h.passes++
+	if !h.healthy && h.passes >= hc.healthyThreshold {
+		h.healthy = true
+		logger.Infof("server %s turned healthy", server.URL)
+		return true
+	}
+	return false
+}
+
+func (hc *healthChecker) probe(server *Server) error {
+	url := strings.TrimRight(server.URL, "/") + hc.spec.Path
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), hc.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if !hc.isExpectedCode(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (hc *healthChecker) isExpectedCode(code int) bool {
+	if len(hc.spec.Codes) == 0 {
+		return code >= 200 && code < 400
+	}
+
+	for _, c := range hc.spec.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (hc *healthChecker) isHealthy(server *Server) bool {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+
+	h := hc.health[server.URL]
+	return h == nil || h.healthy
+}
+
+func (hc *healthChecker) status(server *Server, s *ServerStatus) {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+
+	s.Healthy = true
+	if h := hc.health[server.URL]; h != nil {
+		s.Healthy = h.healthy
+		s.Error = h.lastError
+	}
+}
+
+func (hc *healthChecker) close() {
+	close(hc.done)
+	hc.client.CloseIdleConnections()
+}
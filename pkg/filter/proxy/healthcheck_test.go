@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+)
+
+func TestHealthCheckSpecValidate(t *testing.T) {
+	spec := HealthCheckSpec{Path: "/healthz"}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.Interval, spec.Timeout = "1s", "2s"
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	spec.Timeout = "500ms"
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.Interval = "10x"
+	if spec.Validate() == nil {
+		t.Error("validate should fail with invalid interval")
+	}
+
+	spec.Interval, spec.Timeout = "1s", "10x"
+	if spec.Validate() == nil {
+		t.Error("validate should fail with invalid timeout")
+	}
+}
+
+func TestHealthCheckTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	spec := &HealthCheckSpec{Path: "/"}
+	servers := []*Server{{URL: server.URL}}
+
+	// the probe verifies the server certificate by the TLS config of the pool.
+	hc := newHealthChecker(spec, "", nil)
+	defer hc.close()
+	if hc.probe(servers[0]) == nil {
+		t.Errorf("probe should fail with untrusted server certificate")
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(server.Certificate())
+	hc = newHealthChecker(spec, "", &tls.Config{RootCAs: certPool})
+	defer hc.close()
+	if err := hc.probe(servers[0]); err != nil {
+		t.Errorf("probe should succeed: %v", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var failing int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer bad.Close()
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer good.Close()
+
+	poolSpec := &PoolSpec{
+		Servers: []*Server{{URL: bad.URL}, {URL: good.URL}},
+		HealthCheck: &HealthCheckSpec{
+			Path:               "/healthz",
+			Interval:           "10ms",
+			Timeout:            "10ms",
+			UnhealthyThreshold: 2,
+			HealthyThreshold:   2,
+		},
+	}
+	s := newServers(nil, poolSpec, nil)
+	defer s.close()
+
+	waitFor := func(healthy bool) {
+		for i := 0; i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			if s.status()[0].Healthy == healthy {
+				return
+			}
+		}
+		t.Fatalf("server should turn healthy=%v", healthy)
+	}
+
+	if s.len() != 2 {
+		t.Errorf("all servers should be healthy at first")
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	waitFor(false)
+
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 5; i++ {
+		server, _ := s.next(ctx)
+		if server.URL != good.URL {
+			t.Errorf("unhealthy server should not be picked")
+		}
+	}
+	if s.status()[0].Error == "" {
+		t.Errorf("error of unhealthy server should be recorded")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	waitFor(true)
+	if s.len() != 2 {
+		t.Errorf("recovered server should be back to load balance")
+	}
+}
+
+func TestHealthCheckAllUnhealthy(t *testing.T) {
+	hc := newHealthChecker(&HealthCheckSpec{Path: "/", UnhealthyThreshold: 1}, "", nil)
+	s := &servers{
+		poolSpec:      &PoolSpec{},
+		healthChecker: hc,
+	}
+	servers := []*Server{{URL: "http://127.0.0.1:1"}, {URL: "http://127.0.0.1:2"}}
+	s.useCandidates(newStaticServers(servers, nil, nil))
+
+	if !hc.checkServers(servers) {
+		t.Errorf("health of servers should change")
+	}
+	s.refresh()
+
+	if s.len() != 2 {
+		t.Errorf("all servers should be used when none of them are healthy")
+	}
+}
+
+func TestHealthCheckSpecYAML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	proxy := newTestProxy(t, `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: `+server.URL+`
+  loadBalance:
+    policy: roundRobin
+  healthCheck:
+    path: /healthz
+    interval: 10ms
+    timeout: 10ms
+`)
+	defer proxy.Close()
+
+	hc := proxy.mainPool.servers.healthChecker
+	if hc == nil {
+		t.Fatalf("health checker should be created")
+	}
+	if hc.spec.Path != "/healthz" {
+		t.Errorf("unexpected path: %s", hc.spec.Path)
+	}
+
+	status := proxy.Status().(*Status).MainPool.Servers
+	if len(status) != 1 || !status[0].Healthy {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
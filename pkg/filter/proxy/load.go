@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/fasttime"
+)
+
+const (
+	// ewmaDecayTime is the time constant of the decay of the latency EWMA.
+	ewmaDecayTime = 10 * time.Second
+
+	// ewmaFailurePenalty is the minimal latency observed for a failed
+	// request, so that a server failing fast doesn't look the best.
+	ewmaFailurePenalty = time.Second
+)
+
+type (
+	// serverLoads are the loads of servers used by load-aware policies,
+	// they are keyed by the server URL so that they survive the update
+	// of servers.
+	serverLoads struct {
+		mutex sync.RWMutex
+		loads map[string]*serverLoad
+	}
+
+	// serverLoad is the load of a server.
+	serverLoad struct {
+		// inflight is the number of in-flight requests, accessed atomically.
+		inflight int32
+
+		mutex      sync.Mutex
+		ewma       float64 // nanoseconds
+		lastUpdate time.Time
+	}
+
+	// serverBody is the response body of a server, the request
+	// to the server is done once the body is closed.
+	serverBody struct {
+		io.ReadCloser
+		load *serverLoad
+		once sync.Once
+	}
+)
+
+// get returns the load of the server, it is created if not existed.
+func (sl *serverLoads) get(server *Server) *serverLoad {
+	sl.mutex.RLock()
+	l := sl.loads[server.URL]
+	sl.mutex.RUnlock()
+	if l != nil {
+		return l
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	if l = sl.loads[server.URL]; l == nil {
+		if sl.loads == nil {
+			sl.loads = make(map[string]*serverLoad)
+		}
+		l = &serverLoad{}
+		sl.loads[server.URL] = l
+	}
+	return l
+}
+
+// retain drops the loads of the servers removed from the pool.
+func (sl *serverLoads) retain(servers []*Server) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	loads := make(map[string]*serverLoad, len(servers))
+	for _, server := range servers {
+		if l := sl.loads[server.URL]; l != nil {
+			loads[server.URL] = l
+		}
+	}
+	sl.loads = loads
+}
+
+// requestStart is called when a request is sent to the server.
+func (l *serverLoad) requestStart() {
+	atomic.AddInt32(&l.inflight, 1)
+}
+
+// requestDone is called when a request to the server is done.
+func (l *serverLoad) requestDone() {
+	atomic.AddInt32(&l.inflight, -1)
+}
+
+func (l *serverLoad) inflightRequests() int32 {
+	return atomic.LoadInt32(&l.inflight)
+}
+
+// observeLatency updates the peak EWMA of the latency of the server,
+// it takes the new latency directly if it is higher than the average,
+// so that the average reacts to latency spikes at once but recovers slowly.
+func (l *serverLoad) observeLatency(latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := fasttime.Now()
+	rtt := float64(latency)
+	if rtt > l.ewma {
+		l.ewma = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(l.lastUpdate)) / float64(ewmaDecayTime))
+		l.ewma = l.ewma*w + rtt*(1-w)
+	}
+	l.lastUpdate = now
+}
+
+// cost returns the latency-weighted load of the server.
+func (l *serverLoad) cost() float64 {
+	l.mutex.Lock()
+	ewma := l.ewma
+	if ewma > 0 {
+		// decay the average since the last update, so that
+		// a server which is not picked gets another chance.
+		w := math.Exp(-float64(fasttime.Now().Sub(l.lastUpdate)) / float64(ewmaDecayTime))
+		ewma *= w
+	}
+	l.mutex.Unlock()
+
+	inflight := float64(l.inflightRequests())
+	if ewma == 0 {
+		return inflight
+	}
+	return ewma * (inflight + 1)
+}
+
+// Close implements io.Closer.
+func (b *serverBody) Close() error {
+	b.once.Do(b.load.requestDone)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestServerLoads(t *testing.T) {
+	s := &servers{
+		poolSpec: &PoolSpec{},
+	}
+	lb := &LoadBalance{Policy: PolicyLeastConn}
+
+	s.useCandidates(newStaticServers([]*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+	}, nil, lb))
+	static := s.snapshot()
+	if static.loads != &s.loads {
+		t.Fatalf("loads should be shared by the pool")
+	}
+	static.loads.get(static.servers[0]).requestStart()
+	static.loads.get(static.servers[1]).requestStart()
+
+	// servers are re-created by the update of service instances.
+	s.useCandidates(newStaticServers([]*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9093"},
+	}, nil, lb))
+	static = s.snapshot()
+	if n := static.loads.get(static.servers[0]).inflightRequests(); n != 1 {
+		t.Errorf("load of existing server should be kept, but got %d", n)
+	}
+	if n := static.loads.get(static.servers[1]).inflightRequests(); n != 0 {
+		t.Errorf("load of new server should be empty, but got %d", n)
+	}
+	if _, ok := s.loads.loads["http://127.0.0.1:9092"]; ok {
+		t.Errorf("load of removed server should be dropped")
+	}
+}
+
+func TestPoolServerLoad(t *testing.T) {
+	proxy := newTestProxy(t, `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: http://127.0.0.1:9095
+  loadBalance:
+    policy: peakEWMA
+`)
+	defer proxy.Close()
+
+	fnSendRequestBackup := fnSendRequest
+	defer func() {
+		fnSendRequest = fnSendRequestBackup
+	}()
+
+	p := proxy.mainPool
+	load := p.servers.loads.get(p.spec.Servers[0])
+
+	var body io.Reader
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(http.Header{})
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(http.Header{})
+	}
+	ctx.MockedResponse.MockedSetBody = func(b io.Reader) {
+		body = b
+	}
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("this is the body")),
+		}, nil
+	}
+	if result := proxy.Handle(ctx); result != "" {
+		t.Fatalf("proxy.Handle should succeed, but got %s", result)
+	}
+	if n := load.inflightRequests(); n != 1 {
+		t.Errorf("request should be in flight until the body is closed, but got %d", n)
+	}
+	body.(io.Closer).Close()
+	body.(io.Closer).Close()
+	if n := load.inflightRequests(); n != 0 {
+		t.Errorf("request should be done after the body is closed, but got %d", n)
+	}
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return nil, fmt.Errorf("mocked error")
+	}
+	if result := proxy.Handle(ctx); result != resultServerError {
+		t.Fatalf("proxy.Handle should fail, but got %s", result)
+	}
+	if n := load.inflightRequests(); n != 0 {
+		t.Errorf("failed request should be done, but got %d", n)
+	}
+	if cost := load.cost(); cost < float64(ewmaFailurePenalty)/2 {
+		t.Errorf("failed request should be observed with penalty, but got %v", time.Duration(cost))
+	}
+}
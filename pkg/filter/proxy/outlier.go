@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+)
+
+const (
+	defaultOutlierConsecutiveErrors  = 5
+	defaultOutlierBaseEjectionTime   = 30 * time.Second
+	defaultOutlierMaxEjectionTime    = 300 * time.Second
+	defaultOutlierMaxEjectionPercent = 50
+)
+
+type (
+	// OutlierDetectionSpec is the spec of the passive outlier detection of a pool.
+	OutlierDetectionSpec struct {
+		ConsecutiveErrors  int    `yaml:"consecutiveErrors,omitempty" jsonschema:"omitempty,minimum=1"`
+		BaseEjectionTime   string `yaml:"baseEjectionTime,omitempty" jsonschema:"omitempty,format=duration"`
+		MaxEjectionTime    string `yaml:"maxEjectionTime,omitempty" jsonschema:"omitempty,format=duration"`
+		MaxEjectionPercent int    `yaml:"maxEjectionPercent,omitempty" jsonschema:"omitempty,minimum=1,maximum=100"`
+	}
+
+	outlierDetector struct {
+		consecutiveErrors  int
+		baseEjectionTime   time.Duration
+		maxEjectionTime    time.Duration
+		maxEjectionPercent int
+
+		mutex  sync.Mutex
+		states map[string]*outlierState
+
+		// onChange is called after a server is ejected or
+		// its ejection expired.
+		onChange func()
+		done     chan struct{}
+	}
+
+	// outlierState is keyed by the server URL so that it
+	// survives the update of servers.
+	outlierState struct {
+		errors       int
+		ejections    int
+		ejectedUntil time.Time
+	}
+)
+
+// Validate validates OutlierDetectionSpec.
+func (s OutlierDetectionSpec) Validate() error {
+	var err error
+	base, max := defaultOutlierBaseEjectionTime, defaultOutlierMaxEjectionTime
+	if s.BaseEjectionTime != "" {
+		if base, err = time.ParseDuration(s.BaseEjectionTime); err != nil {
+			return fmt.Errorf("invalid baseEjectionTime: %v", err)
+		}
+	}
+	if s.MaxEjectionTime != "" {
+		if max, err = time.ParseDuration(s.MaxEjectionTime); err != nil {
+			return fmt.Errorf("invalid maxEjectionTime: %v", err)
+		}
+	}
+	if base <= 0 || max < base {
+		return fmt.Errorf("baseEjectionTime(%s) must be positive and not longer than maxEjectionTime(%s)", base, max)
+	}
+	return nil
+}
+
+func newOutlierDetector(spec *OutlierDetectionSpec, onChange func()) *outlierDetector {
+	od := &outlierDetector{
+		consecutiveErrors:  spec.ConsecutiveErrors,
+		baseEjectionTime:   defaultOutlierBaseEjectionTime,
+		maxEjectionTime:    defaultOutlierMaxEjectionTime,
+		maxEjectionPercent: spec.MaxEjectionPercent,
+		states:             make(map[string]*outlierState),
+		onChange:           onChange,
+		done:               make(chan struct{}),
+	}
+
+	if d, err := time.ParseDuration(spec.BaseEjectionTime); err == nil && d > 0 {
+		od.baseEjectionTime = d
+	}
+	if d, err := time.ParseDuration(spec.MaxEjectionTime); err == nil && d >= od.baseEjectionTime {
+		od.maxEjectionTime = d
+	} else if od.maxEjectionTime < od.baseEjectionTime {
+		od.maxEjectionTime = od.baseEjectionTime
+	}
+	if od.consecutiveErrors <= 0 {
+		od.consecutiveErrors = defaultOutlierConsecutiveErrors
+	}
+	if od.maxEjectionPercent <= 0 {
+		od.maxEjectionPercent = defaultOutlierMaxEjectionPercent
+	}
+
+	return od
+}
+
+// record records the result of a request to server, candidates
+// is the number of servers in the pool.
+func (od *outlierDetector) record(server *Server, failed bool, candidates int) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state := od.states[server.URL]
+	if !failed {
+		if state != nil {
+			state.errors = 0
+		}
+		return
+	}
+
+	if state == nil {
+		state = &outlierState{}
+		od.states[server.URL] = state
+	}
+
+	now := fasttime.Now()
+	state.errors++
+	if state.errors < od.consecutiveErrors || now.Before(state.ejectedUntil) {
+		return
+	}
+
+	if !od.canEject(now, candidates) {
+		return
+	}
+
+	// NOTE: A server which stays in the pool for maxEjectionTime
+	// since its last ejection is forgiven.
+	if now.Sub(state.ejectedUntil) > od.maxEjectionTime {
+		state.ejections = 0
+	}
+	state.ejections++
+	state.errors = 0
+
+	d := od.baseEjectionTime * time.Duration(state.ejections)
+	if d > od.maxEjectionTime {
+		d = od.maxEjectionTime
+	}
+	state.ejectedUntil = now.Add(d)
+
+	logger.Warnf("server %s is ejected for %s after %d consecutive errors",
+		server.URL, d, od.consecutiveErrors)
+
+	go od.notify(d)
+}
+
+func (od *outlierDetector) canEject(now time.Time, candidates int) bool {
+	ejected := 0
+	for _, state := range od.states {
+		if now.Before(state.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	max := candidates * od.maxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+
+	return ejected < max
+}
+
+// notify calls onChange at once to remove the ejected server,
+// and again after the ejection expired to bring it back.
+func (od *outlierDetector) notify(d time.Duration) {
+	od.onChange()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-od.done:
+	case <-timer.C:
+		od.onChange()
+	}
+}
+
+// retain drops the states of servers which are removed from the pool.
+func (od *outlierDetector) retain(servers []*Server) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	states := make(map[string]*outlierState, len(servers))
+	for _, server := range servers {
+		if state := od.states[server.URL]; state != nil {
+			states[server.URL] = state
+		}
+	}
+	od.states = states
+}
+
+func (od *outlierDetector) isEjected(server *Server) bool {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state := od.states[server.URL]
+	return state != nil && fasttime.Now().Before(state.ejectedUntil)
+}
+
+func (od *outlierDetector) status(server *Server, s *ServerStatus) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	if state := od.states[server.URL]; state != nil {
+		s.Ejected = fasttime.Now().Before(state.ejectedUntil)
+		s.Ejections = state.ejections
+	}
+}
+
+func (od *outlierDetector) close() {
+	close(od.done)
+}
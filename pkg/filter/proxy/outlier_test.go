@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+)
+
+func TestOutlierDetectionSpecValidate(t *testing.T) {
+	spec := OutlierDetectionSpec{}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.BaseEjectionTime, spec.MaxEjectionTime = "10s", "5s"
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	spec.MaxEjectionTime = "1m"
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.BaseEjectionTime = "10x"
+	if spec.Validate() == nil {
+		t.Error("validate should fail with invalid baseEjectionTime")
+	}
+
+	spec.BaseEjectionTime, spec.MaxEjectionTime = "10s", "1x"
+	if spec.Validate() == nil {
+		t.Error("validate should fail with invalid maxEjectionTime")
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+		{URL: "http://127.0.0.1:9094"},
+	}
+	poolSpec := &PoolSpec{
+		Servers: servers,
+		OutlierDetection: &OutlierDetectionSpec{
+			ConsecutiveErrors:  3,
+			BaseEjectionTime:   "50ms",
+			MaxEjectionTime:    "80ms",
+			MaxEjectionPercent: 50,
+		},
+	}
+	s := newServers(nil, poolSpec, nil)
+	defer s.close()
+
+	waitFor := func(n int) {
+		for i := 0; i < 100; i++ {
+			if s.len() == n {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("pool should have %d servers, but got %d", n, s.len())
+	}
+
+	// a success resets the consecutive errors.
+	s.recordResult(servers[0], true)
+	s.recordResult(servers[0], true)
+	s.recordResult(servers[0], false)
+	s.recordResult(servers[0], true)
+	s.recordResult(servers[0], true)
+	if s.outlierDetector.isEjected(servers[0]) {
+		t.Errorf("server should not be ejected")
+	}
+
+	s.recordResult(servers[0], true)
+	waitFor(3)
+
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 6; i++ {
+		server, _ := s.next(ctx)
+		if server == servers[0] {
+			t.Errorf("ejected server should not be picked")
+		}
+	}
+
+	// at most 50% of servers can be ejected.
+	for i := 0; i < 3; i++ {
+		s.recordResult(servers[1], true)
+		s.recordResult(servers[2], true)
+	}
+	waitFor(2)
+	if s.outlierDetector.isEjected(servers[2]) {
+		t.Errorf("server should not be ejected because of max ejection percent")
+	}
+
+	waitFor(4)
+
+	status := s.status()
+	if status[0].Ejected || status[0].Ejections != 1 {
+		t.Errorf("unexpected status: %+v", status[0])
+	}
+
+	// ejection time grows but is capped by max ejection time.
+	for i := 0; i < 3; i++ {
+		s.recordResult(servers[0], true)
+	}
+	state := s.outlierDetector.states[servers[0].URL]
+	if state.ejections != 2 {
+		t.Errorf("ejections should be 2, but got %d", state.ejections)
+	}
+	if d := time.Until(state.ejectedUntil); d <= 50*time.Millisecond || d > 80*time.Millisecond {
+		t.Errorf("unexpected ejection time %s", d)
+	}
+}
+
+func TestOutlierDetectionSpecYAML(t *testing.T) {
+	proxy := newTestProxy(t, `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: http://127.0.0.1:9095
+  - url: http://127.0.0.1:9096
+  loadBalance:
+    policy: roundRobin
+  outlierDetection:
+    consecutiveErrors: 3
+    baseEjectionTime: 1m
+`)
+	defer proxy.Close()
+
+	od := proxy.mainPool.servers.outlierDetector
+	if od == nil {
+		t.Fatalf("outlier detector should be created")
+	}
+	if od.consecutiveErrors != 3 || od.baseEjectionTime != time.Minute {
+		t.Errorf("unexpected outlier detector: %d, %s", od.consecutiveErrors, od.baseEjectionTime)
+	}
+
+	status := proxy.Status().(*Status).MainPool.Servers
+	if len(status) != 2 || status[0].Ejected {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
@@ -18,10 +18,12 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/opentracing/opentracing-go"
@@ -32,6 +34,8 @@ import (
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/callbackreader"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/grpcstatus"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
@@ -45,17 +49,21 @@ type (
 
 		tagPrefix     string
 		writeResponse bool
+		protocol      string
 
 		filter *httpfilter.HTTPFilter
 
 		servers     *servers
 		httpStat    *httpstat.HTTPStat
 		memoryCache *memorycache.MemoryCache
+		sticky      *stickyCookie
+		signer      *requestSigner
 	}
 
 	// PoolSpec describes a pool of servers.
 	PoolSpec struct {
 		SpanName        string            `yaml:"spanName" jsonschema:"omitempty"`
+		Protocol        string            `yaml:"protocol,omitempty" jsonschema:"omitempty,enum=http1,enum=http2,enum=h2c"`
 		Filter          *httpfilter.Spec  `yaml:"filter" jsonschema:"omitempty"`
 		ServersTags     []string          `yaml:"serversTags" jsonschema:"omitempty,uniqueItems=true"`
 		Servers         []*Server         `yaml:"servers" jsonschema:"omitempty"`
@@ -63,11 +71,24 @@ type (
 		ServiceName     string            `yaml:"serviceName" jsonschema:"omitempty"`
 		LoadBalance     *LoadBalance      `yaml:"loadBalance" jsonschema:"required"`
 		MemoryCache     *memorycache.Spec `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+		HealthCheck     *HealthCheckSpec  `yaml:"healthCheck,omitempty" jsonschema:"omitempty"`
+
+		OutlierDetection *OutlierDetectionSpec `yaml:"outlierDetection,omitempty" jsonschema:"omitempty"`
 	}
 
 	// PoolStatus is the status of Pool.
 	PoolStatus struct {
-		Stat *httpstat.Status `yaml:"stat"`
+		Stat    *httpstat.Status `yaml:"stat"`
+		Servers []*ServerStatus  `yaml:"servers,omitempty"`
+	}
+
+	// ServerStatus is the status of a server in the pool.
+	ServerStatus struct {
+		URL       string `yaml:"url"`
+		Healthy   bool   `yaml:"healthy"`
+		Error     string `yaml:"error,omitempty"`
+		Ejected   bool   `yaml:"ejected"`
+		Ejections int    `yaml:"ejections,omitempty"`
 	}
 )
 
@@ -88,6 +109,15 @@ func (s PoolSpec) Validate() error {
 			serversGotWeight, len(s.Servers))
 	}
 
+	for _, server := range s.Servers {
+		if s.Protocol == protocolHTTP2 && !strings.HasPrefix(server.URL, "https://") {
+			return fmt.Errorf("server %s is not https, which is required by http2", server.URL)
+		}
+		if s.Protocol == protocolH2C && !strings.HasPrefix(server.URL, "http://") {
+			return fmt.Errorf("server %s is not http, which is required by h2c", server.URL)
+		}
+	}
+
 	if s.ServiceName == "" {
 		servers := newStaticServers(s.Servers, s.ServersTags, s.LoadBalance)
 		if servers.len() == 0 {
@@ -99,7 +129,7 @@ func (s PoolSpec) Validate() error {
 }
 
 func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
-	writeResponse bool, failureCodes []int) *pool {
+	writeResponse bool, failureCodes []int, signer *requestSigner, tlsConfig *tls.Config) *pool {
 
 	var filter *httpfilter.HTTPFilter
 	if spec.Filter != nil {
@@ -111,21 +141,32 @@ func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
 		memoryCache = memorycache.New(spec.MemoryCache)
 	}
 
+	var sticky *stickyCookie
+	if spec.LoadBalance != nil && spec.LoadBalance.StickySession != nil {
+		sticky = newStickyCookie(spec.LoadBalance.StickySession)
+	}
+
 	return &pool{
 		spec: spec,
 
 		tagPrefix:     tagPrefix,
 		writeResponse: writeResponse,
+		protocol:      spec.Protocol,
 
 		filter:      filter,
-		servers:     newServers(super, spec),
+		servers:     newServers(super, spec, tlsConfig),
 		httpStat:    httpstat.New(),
 		memoryCache: memoryCache,
+		sticky:      sticky,
+		signer:      signer,
 	}
 }
 
 func (p *pool) status() *PoolStatus {
-	s := &PoolStatus{Stat: p.httpStat.Status()}
+	s := &PoolStatus{
+		Stat:    p.httpStat.Status(),
+		Servers: p.servers.status(),
+	}
 	return s
 }
 
@@ -172,6 +213,16 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 	}
 	addLazyTag("addr", server.URL, -1)
 
+	// NOTE: The cookie is set whatever the response is, so the client
+	// sticks to the server even if the first request failed.
+	if p.writeResponse && p.sticky != nil {
+		defer func() {
+			ctx.Lock()
+			p.sticky.set(ctx, server)
+			ctx.Unlock()
+		}()
+	}
+
 	req, err := p.prepareRequest(ctx, server, reqBody, requestPool, httpstatResultPool)
 	if err != nil {
 		msg := stringtool.Cat("prepare request failed: ", err.Error())
@@ -181,8 +232,22 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 		return resultInternalError
 	}
 
+	if p.signer != nil {
+		// NOTE: The length of a streaming body is unknown, like chunked.
+		streaming := ctx.Request().Std().ContentLength < 0
+		if err := p.signer.sign(req.std, streaming); err != nil {
+			addLazyTag("signErr", err.Error(), -1)
+			setStatusCode(http.StatusInternalServerError)
+			return resultInternalError
+		}
+	}
+
+	load := p.servers.loads.get(server)
+	load.requestStart()
 	resp, span, err := p.doRequest(ctx, req, client)
 	if err != nil {
+		load.requestDone()
+
 		// NOTE: May add option to cancel the tracing if failed here.
 		// ctx.Span().Cancel()
 
@@ -194,10 +259,17 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 			return resultClientError
 		}
 
+		p.observeLatency(load, req, true)
+		p.servers.recordResult(server, true)
 		setStatusCode(http.StatusServiceUnavailable)
 		return resultServerError
 	}
 
+	// NOTE: The request is in flight until the response body is closed.
+	resp.Body = &serverBody{ReadCloser: resp.Body, load: load}
+
+	p.observeLatency(load, req, resp.StatusCode >= 500)
+	p.servers.recordResult(server, resp.StatusCode >= 500)
 	addLazyTag("code", "", resp.StatusCode)
 
 	ctx.Lock()
@@ -210,7 +282,6 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 		ctx.Response().SetStatusCode(resp.StatusCode)
 		ctx.Response().Header().SetRaw(resp.Header)
 		ctx.Response().SetBody(respBody)
-
 		return ""
 	}
 
@@ -226,6 +297,20 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 	return ""
 }
 
+// observeLatency observes the latency of the request for policy peakEWMA,
+// a failed request is observed with a penalty.
+func (p *pool) observeLatency(load *serverLoad, req *request, failed bool) {
+	if p.spec.LoadBalance == nil || p.spec.LoadBalance.Policy != PolicyPeakEWMA {
+		return
+	}
+
+	latency := fasttime.Since(req.startTime())
+	if failed && latency < ewmaFailurePenalty {
+		latency = ewmaFailurePenalty
+	}
+	load.observeLatency(latency)
+}
+
 func (p *pool) prepareRequest(
 	ctx context.HTTPContext,
 	server *Server,
@@ -263,6 +348,7 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 	req *request, resp *http.Response, span tracing.Span) io.Reader {
 
 	var count int
+	writeResponse := p.writeResponse
 
 	callbackBody := callbackreader.New(resp.Body)
 	callbackBody.OnAfter(func(num int, p []byte, n int, err error) ([]byte, int, error) {
@@ -270,6 +356,14 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 		if err == io.EOF {
 			req.finish()
 			span.Finish()
+			// NOTE: The trailers are available only after the body is read to EOF.
+			if writeResponse {
+				for key, values := range resp.Trailer {
+					for _, value := range values {
+						ctx.Response().Trailer().Add(key, value)
+					}
+				}
+			}
 		}
 
 		return p, n, err
@@ -287,6 +381,9 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 		// use recycled object
 		metric := httpstatMetricPool.Get().(*httpstat.Metric)
 		metric.StatusCode = resp.StatusCode
+		if p.writeResponse && !ctx.ClientDisconnected() {
+			metric.StatusCode = grpcstatus.StatusCode(resp.StatusCode, resp.Header, resp.Trailer)
+		}
 		metric.Duration = duration
 		metric.ReqSize = ctx.Request().Size()
 		metric.RespSize = uint64(responseMetaSize(resp) + count)
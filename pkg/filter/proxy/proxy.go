@@ -70,22 +70,26 @@ type (
 		candidatePools []*pool
 		mirrorPool     *pool
 
-		client *http.Client
+		client      *http.Client
+		http2Client *http.Client
+		h2cClient   *http.Client
 
 		compression *compression
+		signer      *requestSigner
 	}
 
 	// Spec describes the Proxy.
 	Spec struct {
-		Fallback            *FallbackSpec    `yaml:"fallback,omitempty" jsonschema:"omitempty"`
-		MainPool            *PoolSpec        `yaml:"mainPool" jsonschema:"required"`
-		CandidatePools      []*PoolSpec      `yaml:"candidatePools,omitempty" jsonschema:"omitempty"`
-		MirrorPool          *PoolSpec        `yaml:"mirrorPool,omitempty" jsonschema:"omitempty"`
-		FailureCodes        []int            `yaml:"failureCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
-		Compression         *CompressionSpec `yaml:"compression,omitempty" jsonschema:"omitempty"`
-		MTLS                *MTLS            `yaml:"mtls,omitempty" jsonschema:"omitempty"`
-		MaxIdleConns        int              `yaml:"maxIdleConns" jsonschema:"omitempty"`
-		MaxIdleConnsPerHost int              `yaml:"maxIdleConnsPerHost" jsonschema:"omitempty"`
+		Fallback            *FallbackSpec      `yaml:"fallback,omitempty" jsonschema:"omitempty"`
+		MainPool            *PoolSpec          `yaml:"mainPool" jsonschema:"required"`
+		CandidatePools      []*PoolSpec        `yaml:"candidatePools,omitempty" jsonschema:"omitempty"`
+		MirrorPool          *PoolSpec          `yaml:"mirrorPool,omitempty" jsonschema:"omitempty"`
+		FailureCodes        []int              `yaml:"failureCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		Compression         *CompressionSpec   `yaml:"compression,omitempty" jsonschema:"omitempty"`
+		MTLS                *MTLS              `yaml:"mtls,omitempty" jsonschema:"omitempty"`
+		RequestSigner       *RequestSignerSpec `yaml:"requestSigner,omitempty" jsonschema:"omitempty"`
+		MaxIdleConns        int                `yaml:"maxIdleConns" jsonschema:"omitempty"`
+		MaxIdleConnsPerHost int                `yaml:"maxIdleConnsPerHost" jsonschema:"omitempty"`
 	}
 
 	// FallbackSpec describes the fallback policy.
@@ -137,6 +141,17 @@ func (s Spec) Validate() error {
 		}
 	}
 
+	// NOTE: The bodies of HTTP/2 requests could be streams, like gRPC
+	// streams, which could not be read into memory to be signed.
+	if s.RequestSigner != nil && !s.RequestSigner.ExcludeBody {
+		pools := append([]*PoolSpec{s.MainPool, s.MirrorPool}, s.CandidatePools...)
+		for _, p := range pools {
+			if p != nil && isHTTP2(p.Protocol) {
+				return fmt.Errorf("requestSigner must exclude body for http2 and h2c pools")
+			}
+		}
+	}
+
 	if len(s.FailureCodes) == 0 {
 		if s.Fallback != nil {
 			return fmt.Errorf("fallback needs failureCodes")
@@ -215,8 +230,13 @@ func (b *Proxy) tlsConfig() *tls.Config {
 func (b *Proxy) reload() {
 	super := b.filterSpec.Super()
 
+	if b.spec.RequestSigner != nil {
+		b.signer = newRequestSigner(super, b.spec.RequestSigner)
+	}
+
+	tlsConfig := b.tlsConfig()
 	b.mainPool = newPool(super, b.spec.MainPool, "proxy#main",
-		true /*writeResponse*/, b.spec.FailureCodes)
+		true /*writeResponse*/, b.spec.FailureCodes, b.signer, tlsConfig)
 
 	if b.spec.Fallback != nil {
 		b.fallback = fallback.New(&b.spec.Fallback.Spec)
@@ -227,29 +247,38 @@ func (b *Proxy) reload() {
 		for k := range b.spec.CandidatePools {
 			candidatePools = append(candidatePools,
 				newPool(super, b.spec.CandidatePools[k], fmt.Sprintf("proxy#candidate#%d", k),
-					true, b.spec.FailureCodes))
+					true, b.spec.FailureCodes, b.signer, tlsConfig))
 		}
 		b.candidatePools = candidatePools
 	}
 	if b.spec.MirrorPool != nil {
 		b.mirrorPool = newPool(super, b.spec.MirrorPool, "proxy#mirror",
-			false /*writeResponse*/, b.spec.FailureCodes)
+			false /*writeResponse*/, b.spec.FailureCodes, b.signer, tlsConfig)
 	}
 
 	if b.spec.Compression != nil {
 		b.compression = newCompression(b.spec.Compression)
 	}
 
-	b.client = &http.Client{
-		// NOTE: Timeout could be no limit, real client or server could cancel it.
-		Timeout: 0,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 60 * time.Second,
-				DualStack: true,
-			}).DialContext,
+	b.client = b.newClient(protocolHTTP1)
+	b.http2Client = b.newClient(protocolHTTP2)
+	b.h2cClient = b.newClient(protocolH2C)
+}
+
+func (b *Proxy) newClient(protocol string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 60 * time.Second,
+		DualStack: true,
+	}
+
+	var transport http.RoundTripper
+	if protocol == protocolH2C {
+		transport = newH2CTransport(dialer)
+	} else {
+		transport = &http.Transport{
+			Proxy:              http.ProxyFromEnvironment,
+			DialContext:        dialer.DialContext,
 			TLSClientConfig:    b.tlsConfig(),
 			DisableCompression: false,
 			// NOTE: The large number of Idle Connections can
@@ -259,13 +288,34 @@ func (b *Proxy) reload() {
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
-		},
+			// NOTE: HTTP/2 is not attempted with the custom dialer
+			// and TLS config unless it is forced.
+			ForceAttemptHTTP2: protocol == protocolHTTP2,
+		}
+	}
+
+	return &http.Client{
+		// NOTE: Timeout could be no limit, real client or server could cancel it.
+		Timeout:   0,
+		Transport: transport,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 }
 
+// clientOf returns the client talking the protocol of the pool.
+func (b *Proxy) clientOf(p *pool) *http.Client {
+	switch p.protocol {
+	case protocolHTTP2:
+		return b.http2Client
+	case protocolH2C:
+		return b.h2cClient
+	default:
+		return b.client
+	}
+}
+
 // Status returns Proxy status.
 func (b *Proxy) Status() interface{} {
 	s := &Status{
@@ -295,6 +345,15 @@ func (b *Proxy) Close() {
 	if b.mirrorPool != nil {
 		b.mirrorPool.close()
 	}
+
+	if b.signer != nil {
+		b.signer.close()
+	}
+
+	// NOTE: The h2c connections are never closed for being idle.
+	b.client.CloseIdleConnections()
+	b.http2Client.CloseIdleConnections()
+	b.h2cClient.CloseIdleConnections()
 }
 
 func (b *Proxy) fallbackForCodes(ctx context.HTTPContext) bool {
@@ -326,7 +385,7 @@ func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
 
 		go func() {
 			defer wg.Done()
-			b.mirrorPool.handle(ctx, secondaryBody, b.client)
+			b.mirrorPool.handle(ctx, secondaryBody, b.clientOf(b.mirrorPool))
 		}()
 	}
 
@@ -348,7 +407,7 @@ func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
 		return ""
 	}
 
-	result = p.handle(ctx, ctx.Request().Body(), b.client)
+	result = p.handle(ctx, ctx.Request().Body(), b.clientOf(p))
 	if result != "" {
 		return result
 	}
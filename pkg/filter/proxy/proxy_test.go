@@ -201,6 +201,17 @@ func TestSpecValidate(t *testing.T) {
 	if spec.Validate() != nil {
 		t.Error("validate should succeed")
 	}
+
+	spec.RequestSigner = &RequestSignerSpec{}
+	spec.CandidatePools[0].Protocol = protocolH2C
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	spec.RequestSigner.ExcludeBody = true
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
 }
 
 func TestPoolSpecValidate(t *testing.T) {
@@ -237,3 +248,17 @@ func TestPoolSpecValidate(t *testing.T) {
 		t.Error("validate should succeed")
 	}
 }
+
+func newTestProxy(t *testing.T, yamlSpec string) *Proxy {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	proxy := &Proxy{}
+	proxy.Init(spec)
+	return proxy
+}
@@ -19,6 +19,7 @@ package proxy
 
 import (
 	"bytes"
+	stdcontext "context"
 	"fmt"
 	"io"
 	"net/http"
@@ -37,6 +38,7 @@ type (
 		server     *Server
 		std        *http.Request
 		statResult *httpstat.Result
+		traced     bool
 		createTime time.Time
 		_startTime time.Time
 		_endTime   time.Time
@@ -68,13 +70,31 @@ func (p *pool) newRequest(
 		url += "?" + r.Query()
 	}
 
-	newCtx := httpstat.WithHTTPStat(ctx, req.statResult)
-	stdr, err := http.NewRequestWithContext(newCtx, r.Method(), url, reqBody)
+	// NOTE: The client trace is not safe for HTTP/2, whose request body
+	// could still be sending after the response arrives, like gRPC streams.
+	var newCtx stdcontext.Context = ctx
+	req.traced = !isHTTP2(p.protocol)
+	if req.traced {
+		newCtx = httpstat.WithHTTPStat(ctx, req.statResult)
+	} else {
+		*req.statResult = httpstat.Result{}
+	}
+	// NOTE: The body is closed by the context, closing it concurrently
+	// in the transport is not safe for the request bodies of HTTP/2,
+	// whose streams could still be reading it, like gRPC streams.
+	body := reqBody
+	if isHTTP2(p.protocol) && reqBody != nil {
+		body = io.NopCloser(reqBody)
+	}
+	stdr, err := http.NewRequestWithContext(newCtx, r.Method(), url, body)
 	if err != nil {
 		return nil, fmt.Errorf("BUG: new request failed: %v", err)
 	}
 
 	stdr.Header = r.Header().Std()
+	if isHTTP2(p.protocol) {
+		stdr.Header = removeHopByHopHeaders(stdr.Header)
+	}
 	// only set host when server address is not host name.
 	if !server.addrIsHostName {
 		stdr.Host = r.Host()
@@ -122,6 +142,10 @@ func (r *request) finish() {
 }
 
 func (r *request) total() time.Duration {
+	if !r.traced {
+		return r.endTime().Sub(r.startTime())
+	}
+
 	if time.Time.IsZero(r._endTime) {
 		logger.Errorf("BUG: call total before finish")
 		return r.statResult.Total(fasttime.Now())
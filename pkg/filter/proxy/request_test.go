@@ -58,6 +58,10 @@ func TestRequest(t *testing.T) {
 	req, _ := p.newRequest(ctx, &server, sr, requestPool, httpstatResultPool)
 	defer requestPool.Put(req) // recycle request
 
+	if req.std.ContentLength != sr.Size() {
+		t.Errorf("content length of HTTP/1 request should be %d, but got %d", sr.Size(), req.std.ContentLength)
+	}
+
 	req.start()
 	tm := req.startTime()
 
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	// HashSourceIP uses the real IP of the client as the hash key.
+	HashSourceIP = "ip"
+	// HashSourceHeader uses the value of a header as the hash key.
+	HashSourceHeader = "header"
+	// HashSourceCookie uses the value of a cookie as the hash key.
+	HashSourceCookie = "cookie"
+	// HashSourceQuery uses the value of a query parameter as the hash key.
+	HashSourceQuery = "query"
+
+	// HashFallbackRandom sends requests without the hash key to random servers.
+	HashFallbackRandom = "random"
+	// HashFallbackIP uses the real IP of the client as the hash key if
+	// the request doesn't have the hash key.
+	HashFallbackIP = "ip"
+
+	defaultVirtualNodes = 100
+	// maxRingNodes caps the total number of virtual nodes of a ring,
+	// the virtual nodes of servers are scaled down proportionally if
+	// they exceed it.
+	maxRingNodes = 100000
+)
+
+type (
+	// hashRing is a consistent hash ring, adding or removing a server
+	// only moves the keys of the server to its neighbours.
+	hashRing struct {
+		nodes []ringNode
+	}
+
+	ringNode struct {
+		hash   uint64
+		server *Server
+	}
+)
+
+func hash64(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// NOTE: FNV doesn't spread similar keys well, so apply the
+	// finalizer of MurmurHash3 to get better distribution.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func newHashRing(servers []*Server, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	// servers with larger weight get more virtual nodes,
+	// weight 0 means no weight is configured.
+	counts, total := make([]int, len(servers)), 0
+	for i, server := range servers {
+		counts[i] = virtualNodes
+		if server.Weight > 0 {
+			counts[i] = virtualNodes * server.Weight
+		}
+		total += counts[i]
+	}
+	if total > maxRingNodes {
+		for i := range counts {
+			counts[i] = counts[i] * maxRingNodes / total
+			if counts[i] == 0 {
+				counts[i] = 1
+			}
+		}
+	}
+
+	nodes := make([]ringNode, 0, total)
+	for j, server := range servers {
+		for i := 0; i < counts[j]; i++ {
+			hash := hash64(server.URL + "#" + strconv.Itoa(i))
+			nodes = append(nodes, ringNode{hash: hash, server: server})
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].hash < nodes[j].hash
+	})
+
+	return &hashRing{nodes: nodes}
+}
+
+// get returns the server owning key among servers. members is the set
+// of servers, the servers on the ring not in it are skipped, it is nil
+// if all servers on the ring are members. If loadFactor is greater than
+// 0, servers whose in-flight requests exceed loadFactor times the average
+// are skipped too, and the key spills to the next server on the ring.
+func (r *hashRing) get(key string, loadFactor float64, servers []*Server,
+	members map[*Server]bool, loads *serverLoads) *Server {
+	hash := hash64(key)
+	n := len(r.nodes)
+	start := sort.Search(n, func(i int) bool {
+		return r.nodes[i].hash >= hash
+	})
+
+	var capacity int32
+	if loadFactor > 0 {
+		total := int32(1)
+		for _, server := range servers {
+			total += loads.get(server).inflightRequests()
+		}
+		capacity = int32(math.Ceil(loadFactor * float64(total) / float64(len(servers))))
+	}
+
+	var owner *Server
+	for i := 0; i < n; i++ {
+		server := r.nodes[(start+i)%n].server
+		if members != nil && !members[server] {
+			continue
+		}
+		if loadFactor <= 0 {
+			return server
+		}
+		if owner == nil {
+			owner = server
+		}
+		if loads.get(server).inflightRequests() < capacity {
+			return server
+		}
+	}
+
+	return owner
+}
+
+func (lb *LoadBalance) hashKey(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	switch lb.HashSource {
+	case HashSourceHeader:
+		return r.Header().Get(lb.HashKey)
+	case HashSourceCookie:
+		if cookie, err := r.Cookie(lb.HashKey); err == nil {
+			return cookie.Value
+		}
+		return ""
+	case HashSourceQuery:
+		return r.Std().URL.Query().Get(lb.HashKey)
+	default:
+		return r.RealIP()
+	}
+}
+
+func (ss *staticServers) ringHash(ctx context.HTTPContext) *Server {
+	key := ss.lb.hashKey(ctx)
+	if key == "" && ss.lb.HashFallback == HashFallbackIP {
+		key = ctx.Request().RealIP()
+	}
+	// NOTE: Requests without the key are spread randomly by default,
+	// or they will all go to the same server.
+	if key == "" {
+		return ss.random(ctx)
+	}
+
+	return ss.ring.get(key, ss.lb.BoundedLoadFactor, ss.servers, ss.members, ss.loads)
+}
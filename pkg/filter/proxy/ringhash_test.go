@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestRingHash(t *testing.T) {
+	var servers []*Server
+	for i := 0; i < 4; i++ {
+		servers = append(servers, &Server{URL: fmt.Sprintf("http://127.0.0.1:909%d", i)})
+	}
+
+	lb := &LoadBalance{Policy: PolicyRingHash, HashSource: HashSourceHeader}
+	if lb.Validate() == nil {
+		t.Error("LoadBalance.Validate should fail")
+	}
+	lb.HashKey = "X-User"
+	lb.BoundedLoadFactor = 0.5
+	if lb.Validate() == nil {
+		t.Error("LoadBalance.Validate should fail")
+	}
+	lb.BoundedLoadFactor = 0
+	if lb.Validate() != nil {
+		t.Error("LoadBalance.Validate should succeed")
+	}
+
+	header := http.Header{}
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+
+	pick := func(ss *staticServers, key string) *Server {
+		header.Set("X-User", key)
+		return ss.next(ctx)
+	}
+
+	ss := newStaticServers(servers, nil, lb)
+	counts := map[*Server]int{}
+	owners := map[string]*Server{}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		server := pick(ss, key)
+		if pick(ss, key) != server {
+			t.Fatalf("the same key should be mapped to the same server")
+		}
+		counts[server]++
+		owners[key] = server
+	}
+	for _, server := range servers {
+		if counts[server] < 1500 || counts[server] > 3500 {
+			t.Errorf("keys are not evenly distributed: %d", counts[server])
+		}
+	}
+
+	// removing a server only moves the keys of the server.
+	ss = newStaticServers(servers[:3], nil, lb)
+	for key, owner := range owners {
+		server := pick(ss, key)
+		if owner != servers[3] && server != owner {
+			t.Fatalf("key %s of remained server should not be moved", key)
+		}
+	}
+
+	// unavailable servers are filtered out without rebuilding the ring.
+	full := newStaticServers(servers, nil, lb)
+	sub := full.subset(servers[:3])
+	if sub.ring != full.ring {
+		t.Errorf("the ring should be shared")
+	}
+	for key, owner := range owners {
+		server := pick(sub, key)
+		if server == servers[3] {
+			t.Fatalf("key %s should not go to the unavailable server", key)
+		}
+		if owner != servers[3] && server != owner {
+			t.Fatalf("key %s of available server should not be moved", key)
+		}
+	}
+
+	// requests without the key fall back to the IP if configured.
+	header.Del("X-User")
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return "192.168.1.2"
+	}
+	lb.HashFallback = HashFallbackIP
+	ss = newStaticServers(servers, nil, lb)
+	server := ss.next(ctx)
+	for i := 0; i < 10; i++ {
+		if ss.next(ctx) != server {
+			t.Fatalf("requests without the key should be mapped by IP")
+		}
+	}
+	lb.HashFallback = ""
+
+	// hot keys spill to other servers with bounded load.
+	lb.BoundedLoadFactor = 1.25
+	ss = newStaticServers(servers, nil, lb)
+	owner := pick(ss, "hot-key")
+	for i := 0; i < 3; i++ {
+		ss.loads.get(owner).requestStart()
+	}
+	if pick(ss, "hot-key") == owner {
+		t.Errorf("overloaded server should be skipped")
+	}
+
+	// the IP is used by default.
+	lb = &LoadBalance{Policy: PolicyRingHash}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return "192.168.1.1"
+	}
+	ss = newStaticServers(servers, nil, lb)
+	if ss.next(ctx) != ss.next(ctx) {
+		t.Errorf("the same IP should be mapped to the same server")
+	}
+}
+
+func TestRingHashMaxNodes(t *testing.T) {
+	var servers []*Server
+	for i := 0; i < 200; i++ {
+		servers = append(servers, &Server{URL: fmt.Sprintf("http://127.0.0.1:%d", 10000+i), Weight: 100})
+	}
+	servers[0].Weight = 1
+
+	ring := newHashRing(servers, defaultVirtualNodes)
+	if len(ring.nodes) > maxRingNodes+len(servers) {
+		t.Errorf("too many virtual nodes: %d", len(ring.nodes))
+	}
+
+	counts := map[*Server]int{}
+	for _, node := range ring.nodes {
+		counts[node.server]++
+	}
+	if counts[servers[0]] == 0 || counts[servers[1]] <= counts[servers[0]] {
+		t.Errorf("virtual nodes should be scaled by weight: %d, %d", counts[servers[0]], counts[servers[1]])
+	}
+}
+
+func TestRingHashSpecYAML(t *testing.T) {
+	proxy := newTestProxy(t, `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: http://127.0.0.1:9095
+  - url: http://127.0.0.1:9096
+  - url: http://127.0.0.1:9097
+  loadBalance:
+    policy: ringHash
+    hashSource: header
+    hashKey: X-User
+`)
+	defer proxy.Close()
+
+	ss := proxy.mainPool.servers.snapshot()
+	if ss.ring == nil {
+		t.Fatalf("hash ring should be created")
+	}
+
+	header := http.Header{}
+	header.Set("X-User", "user-1")
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	server := ss.next(ctx)
+	for i := 0; i < 5; i++ {
+		if ss.next(ctx) != server {
+			t.Errorf("the same key should be mapped to the same server")
+		}
+	}
+}
@@ -18,6 +18,7 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
 	"math/rand"
 	"net"
@@ -46,6 +47,12 @@ const (
 	PolicyIPHash = "ipHash"
 	// PolicyHeaderHash is the policy of header hash.
 	PolicyHeaderHash = "headerHash"
+	// PolicyLeastConn is the policy of least in-flight requests.
+	PolicyLeastConn = "leastConn"
+	// PolicyPeakEWMA is the policy of least latency-weighted load.
+	PolicyPeakEWMA = "peakEWMA"
+	// PolicyRingHash is the policy of consistent hashing.
+	PolicyRingHash = "ringHash"
 
 	retryTimeout = 3 * time.Second
 )
@@ -58,8 +65,14 @@ type (
 		mutex           sync.Mutex
 		serviceRegistry *serviceregistry.ServiceRegistry
 		serviceWatcher  serviceregistry.ServiceWatcher
-		static          *staticServers
-		done            chan struct{}
+		healthChecker   *healthChecker
+		outlierDetector *outlierDetector
+		loads           serverLoads
+		// candidates are all servers picked by tags, and static are the ones
+		// used by load balance, which excludes unhealthy and ejected ones.
+		candidates *staticServers
+		static     *staticServers
+		done       chan struct{}
 	}
 
 	staticServers struct {
@@ -67,6 +80,13 @@ type (
 		weightsSum int
 		servers    []*Server
 		lb         LoadBalance
+		ring       *hashRing
+		// members are the servers on the ring used by load balance,
+		// it is nil if all of them are used.
+		members map[*Server]bool
+		sticky  *stickySession
+		// loads is only used by load-aware policies.
+		loads *serverLoads
 	}
 
 	// Server is proxy server.
@@ -79,8 +99,17 @@ type (
 
 	// LoadBalance is load balance for multiple servers.
 	LoadBalance struct {
-		Policy        string `yaml:"policy" jsonschema:"required,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash,enum=headerHash"`
+		Policy        string `yaml:"policy" jsonschema:"required,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash,enum=headerHash,enum=leastConn,enum=peakEWMA,enum=ringHash"`
 		HeaderHashKey string `yaml:"headerHashKey" jsonschema:"omitempty"`
+
+		// The options below are for policy ringHash.
+		HashSource        string  `yaml:"hashSource,omitempty" jsonschema:"omitempty,enum=ip,enum=header,enum=cookie,enum=query"`
+		HashKey           string  `yaml:"hashKey,omitempty" jsonschema:"omitempty"`
+		HashFallback      string  `yaml:"hashFallback,omitempty" jsonschema:"omitempty,enum=random,enum=ip"`
+		VirtualNodes      int     `yaml:"virtualNodes,omitempty" jsonschema:"omitempty,minimum=1"`
+		BoundedLoadFactor float64 `yaml:"boundedLoadFactor,omitempty" jsonschema:"omitempty"`
+
+		StickySession *StickySessionSpec `yaml:"stickySession,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -120,18 +149,38 @@ func (lb LoadBalance) Validate() error {
 		return fmt.Errorf("headerHash needs to specify headerHashKey")
 	}
 
+	if lb.Policy == PolicyRingHash {
+		if lb.HashSource != "" && lb.HashSource != HashSourceIP && lb.HashKey == "" {
+			return fmt.Errorf("hash source %s needs to specify hashKey", lb.HashSource)
+		}
+		if lb.BoundedLoadFactor != 0 && lb.BoundedLoadFactor < 1 {
+			return fmt.Errorf("boundedLoadFactor must not be less than 1")
+		}
+	}
+
 	return nil
 }
 
-func newServers(super *supervisor.Supervisor, poolSpec *PoolSpec) *servers {
+func newServers(super *supervisor.Supervisor, poolSpec *PoolSpec, tlsConfig *tls.Config) *servers {
 	s := &servers{
 		poolSpec: poolSpec,
 		super:    super,
 		done:     make(chan struct{}),
 	}
 
+	if poolSpec.HealthCheck != nil {
+		s.healthChecker = newHealthChecker(poolSpec.HealthCheck, poolSpec.Protocol, tlsConfig)
+	}
+	if poolSpec.OutlierDetection != nil {
+		s.outlierDetector = newOutlierDetector(poolSpec.OutlierDetection, s.refresh)
+	}
+
 	s.useStaticServers()
 
+	if s.healthChecker != nil {
+		go s.healthChecker.run(s.candidateServers, s.refresh)
+	}
+
 	if poolSpec.ServiceRegistry == "" || poolSpec.ServiceName == "" {
 		return s
 	}
@@ -199,15 +248,111 @@ func (s *servers) useService(serviceInstanceSpecs map[string]*serviceregistry.Se
 
 	logger.Infof("use dynamic service: %s/%s", s.poolSpec.ServiceRegistry, s.poolSpec.ServiceName)
 
+	s.useCandidates(dynamicServers)
+}
+
+func (s *servers) useStaticServers() {
+	s.useCandidates(newStaticServers(s.poolSpec.Servers, s.poolSpec.ServersTags, s.poolSpec.LoadBalance))
+}
+
+func (s *servers) useCandidates(candidates *staticServers) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.static = dynamicServers
+	s.candidates = s.shareLoads(candidates)
+	s.loads.retain(candidates.servers)
+	if s.outlierDetector != nil {
+		s.outlierDetector.retain(candidates.servers)
+	}
+	s.static = s.availableServers(candidates)
 }
 
-func (s *servers) useStaticServers() {
+// shareLoads makes ss use the loads of the pool.
+func (s *servers) shareLoads(ss *staticServers) *staticServers {
+	if ss.loads != nil {
+		ss.loads = &s.loads
+	}
+	return ss
+}
+
+// refresh rebuilds the servers for load balance from candidates, it is
+// called after the health of servers changed or servers are ejected.
+func (s *servers) refresh() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.static = newStaticServers(s.poolSpec.Servers, s.poolSpec.ServersTags, s.poolSpec.LoadBalance)
+	s.static = s.availableServers(s.candidates)
+}
+
+func (s *servers) isAvailable(server *Server) bool {
+	if s.healthChecker != nil && !s.healthChecker.isHealthy(server) {
+		return false
+	}
+	if s.outlierDetector != nil && s.outlierDetector.isEjected(server) {
+		return false
+	}
+	return true
+}
+
+// availableServers returns candidates itself if all of them are available,
+// or none of them are available, in which case it's better to try them
+// all than to reject every request.
+func (s *servers) availableServers(candidates *staticServers) *staticServers {
+	if s.healthChecker == nil && s.outlierDetector == nil {
+		return candidates
+	}
+
+	available := make([]*Server, 0, len(candidates.servers))
+	for _, server := range candidates.servers {
+		if s.isAvailable(server) {
+			available = append(available, server)
+		}
+	}
+
+	if len(available) == len(candidates.servers) {
+		return candidates
+	}
+	if len(available) == 0 {
+		logger.Warnf("no available server in pool, use all %d servers", len(candidates.servers))
+		return candidates
+	}
+
+	return s.shareLoads(candidates.subset(available))
+}
+
+// recordResult records the result of a request for outlier detection.
+func (s *servers) recordResult(server *Server, failed bool) {
+	if s.outlierDetector == nil {
+		return
+	}
+
+	s.outlierDetector.record(server, failed, len(s.candidateServers()))
+}
+
+func (s *servers) candidateServers() []*Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.candidates.servers
+}
+
+func (s *servers) status() []*ServerStatus {
+	if s.healthChecker == nil && s.outlierDetector == nil {
+		return nil
+	}
+
+	candidates := s.candidateServers()
+	status := make([]*ServerStatus, 0, len(candidates))
+	for _, server := range candidates {
+		ss := &ServerStatus{URL: server.URL, Healthy: true}
+		if s.healthChecker != nil {
+			s.healthChecker.status(server, ss)
+		}
+		if s.outlierDetector != nil {
+			s.outlierDetector.status(server, ss)
+		}
+		status = append(status, ss)
+	}
+
+	return status
 }
 
 func (s *servers) snapshot() *staticServers {
@@ -236,6 +381,14 @@ func (s *servers) next(ctx context.HTTPContext) (*Server, error) {
 func (s *servers) close() {
 	close(s.done)
 
+	if s.healthChecker != nil {
+		s.healthChecker.close()
+	}
+
+	if s.outlierDetector != nil {
+		s.outlierDetector.close()
+	}
+
 	if s.serviceWatcher != nil {
 		s.serviceWatcher.Stop()
 	}
@@ -279,6 +432,46 @@ func (ss *staticServers) prepare() {
 		server.checkAddrPattern()
 		ss.weightsSum += server.Weight
 	}
+
+	switch ss.lb.Policy {
+	case PolicyLeastConn, PolicyPeakEWMA:
+		ss.loads = &serverLoads{}
+	case PolicyRingHash:
+		ss.ring = newHashRing(ss.servers, ss.lb.VirtualNodes)
+		if ss.lb.BoundedLoadFactor > 0 {
+			ss.loads = &serverLoads{}
+		}
+	}
+
+	if ss.lb.StickySession != nil {
+		ss.sticky = newStickySession(ss.lb.StickySession, ss.servers)
+	}
+}
+
+// subset returns the static servers of servers, which are part of ss.
+// The hash ring of ss is shared and filtered by members instead of being
+// rebuilt, so that the keys of other servers are not moved.
+func (ss *staticServers) subset(servers []*Server) *staticServers {
+	if ss.ring == nil {
+		return newStaticServers(servers, nil, &ss.lb)
+	}
+
+	sub := &staticServers{
+		servers: servers,
+		lb:      ss.lb,
+		ring:    ss.ring,
+		members: make(map[*Server]bool, len(servers)),
+		loads:   ss.loads,
+	}
+	for _, server := range servers {
+		sub.weightsSum += server.Weight
+		sub.members[server] = true
+	}
+	if sub.lb.StickySession != nil {
+		sub.sticky = newStickySession(sub.lb.StickySession, servers)
+	}
+
+	return sub
 }
 
 func (ss *staticServers) len() int {
@@ -286,6 +479,12 @@ func (ss *staticServers) len() int {
 }
 
 func (ss *staticServers) next(ctx context.HTTPContext) *Server {
+	if ss.sticky != nil {
+		if server := ss.sticky.server(ctx); server != nil {
+			return server
+		}
+	}
+
 	switch ss.lb.Policy {
 	case PolicyRoundRobin:
 		return ss.roundRobin(ctx)
@@ -297,6 +496,12 @@ func (ss *staticServers) next(ctx context.HTTPContext) *Server {
 		return ss.ipHash(ctx)
 	case PolicyHeaderHash:
 		return ss.headerHash(ctx)
+	case PolicyLeastConn:
+		return ss.leastConn(ctx)
+	case PolicyPeakEWMA:
+		return ss.peakEWMA(ctx)
+	case PolicyRingHash:
+		return ss.ringHash(ctx)
 	}
 
 	logger.Errorf("BUG: unknown load balance policy: %s", ss.lb.Policy)
@@ -340,3 +545,42 @@ func (ss *staticServers) headerHash(ctx context.HTTPContext) *Server {
 	sum32 := int(hashtool.Hash32(value))
 	return ss.servers[sum32%len(ss.servers)]
 }
+
+func (ss *staticServers) leastConn(ctx context.HTTPContext) *Server {
+	// NOTE: Start from a random server to spread the requests
+	// among the servers which have the same in-flight requests.
+	n := len(ss.servers)
+	offset := rand.Intn(n)
+
+	best := ss.servers[offset]
+	min := ss.loads.get(best).inflightRequests()
+	for i := 1; i < n && min > 0; i++ {
+		server := ss.servers[(offset+i)%n]
+		if inflight := ss.loads.get(server).inflightRequests(); inflight < min {
+			best, min = server, inflight
+		}
+	}
+
+	return best
+}
+
+// peakEWMA picks the server with lower cost from two random
+// servers, i.e. the power of two choices.
+func (ss *staticServers) peakEWMA(ctx context.HTTPContext) *Server {
+	n := len(ss.servers)
+	if n == 1 {
+		return ss.servers[0]
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	s1, s2 := ss.servers[i], ss.servers[j]
+	if ss.loads.get(s2).cost() < ss.loads.get(s1).cost() {
+		return s2
+	}
+	return s1
+}
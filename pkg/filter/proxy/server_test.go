@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
@@ -448,3 +449,71 @@ func TestCheckAddrPattern(t *testing.T) {
 		t.Error("address should be host name")
 	}
 }
+
+func TestLeastConn(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+	}
+	ss := newStaticServers(servers, nil, &LoadBalance{Policy: PolicyLeastConn})
+	ctx := &contexttest.MockedHTTPContext{}
+
+	ss.loads.get(servers[0]).requestStart()
+	ss.loads.get(servers[2]).requestStart()
+	ss.loads.get(servers[2]).requestStart()
+	for i := 0; i < 10; i++ {
+		if ss.next(ctx) != servers[1] {
+			t.Errorf("server with least connections should be picked")
+		}
+	}
+
+	ss.loads.get(servers[1]).requestStart()
+	ss.loads.get(servers[1]).requestStart()
+	if ss.next(ctx) != servers[0] {
+		t.Errorf("server with least connections should be picked")
+	}
+
+	ss.loads.get(servers[2]).requestDone()
+	ss.loads.get(servers[2]).requestDone()
+	if ss.next(ctx) != servers[2] {
+		t.Errorf("server with least connections should be picked")
+	}
+}
+
+func TestPeakEWMA(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+	}
+	ss := newStaticServers(servers, nil, &LoadBalance{Policy: PolicyPeakEWMA})
+	ctx := &contexttest.MockedHTTPContext{}
+
+	ss.loads.get(servers[0]).observeLatency(100 * time.Millisecond)
+	ss.loads.get(servers[1]).observeLatency(10 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if ss.next(ctx) != servers[1] {
+			t.Errorf("server with lower latency should be picked")
+		}
+	}
+
+	// peak latency is taken at once.
+	ss.loads.get(servers[1]).observeLatency(time.Second)
+	if ss.next(ctx) != servers[0] {
+		t.Errorf("server with lower latency should be picked")
+	}
+
+	// in-flight requests increase the cost.
+	ss.loads.get(servers[1]).observeLatency(0)
+	for i := 0; i < 20; i++ {
+		ss.loads.get(servers[0]).requestStart()
+	}
+	if ss.loads.get(servers[0]).cost() <= ss.loads.get(servers[1]).cost() {
+		t.Errorf("cost of server with more in-flight requests should be higher")
+	}
+
+	ss = newStaticServers(servers[:1], nil, &LoadBalance{Policy: PolicyPeakEWMA})
+	if ss.next(ctx) != servers[0] {
+		t.Errorf("the only server should be picked")
+	}
+}
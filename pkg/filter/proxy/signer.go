@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+const (
+	customDataPrefix = "/custom-data/"
+
+	// unsignedPayload is the body hash of requests whose body is not
+	// signed, the same as the one of the signer.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+type (
+	// RequestSignerSpec describes how to sign the requests to upstream servers.
+	RequestSignerSpec struct {
+		signer.Spec `yaml:",inline"`
+
+		// Scopes are the scopes of the credential, the upstream servers
+		// must use the same scopes to verify the signature.
+		Scopes []string `yaml:"scopes,omitempty" jsonschema:"omitempty"`
+		// EtcdKey is the key of the credential in etcd, it takes precedence
+		// over accessKeyId/accessKeySecret. The credential is stored as:
+		// key: /custom-data/{etcdKey}
+		// value:
+		//   accessKeyId: "$id"
+		//   accessKeySecret: "$secret"
+		EtcdKey string `yaml:"etcdKey,omitempty" jsonschema:"omitempty"`
+	}
+
+	// requestSigner signs the requests to upstream servers.
+	requestSigner struct {
+		spec *RequestSignerSpec
+
+		signer atomic.Value // *signer.Signer
+
+		stopCtx context.Context
+		cancel  context.CancelFunc
+	}
+
+	// signerCredential defines the format of the credential in etcd.
+	signerCredential struct {
+		AccessKeyID     string `yaml:"accessKeyId"`
+		AccessKeySecret string `yaml:"accessKeySecret"`
+	}
+)
+
+// Validate validates RequestSignerSpec.
+func (s RequestSignerSpec) Validate() error {
+	if s.EtcdKey != "" {
+		return nil
+	}
+	if s.AccessKeyID == "" || s.AccessKeySecret == "" {
+		return fmt.Errorf("both accessKeyId and accessKeySecret are required if etcdKey is empty")
+	}
+	return nil
+}
+
+// newRequestSigner creates a request signer, requests are not sent if the
+// credential could not be read from etcd.
+func newRequestSigner(super *supervisor.Supervisor, spec *RequestSignerSpec) *requestSigner {
+	stopCtx, cancel := context.WithCancel(context.Background())
+	rs := &requestSigner{
+		spec:    spec,
+		stopCtx: stopCtx,
+		cancel:  cancel,
+	}
+
+	if spec.EtcdKey == "" {
+		rs.signer.Store(signer.CreateFromSpec(&spec.Spec))
+		return rs
+	}
+
+	rs.signer.Store((*signer.Signer)(nil))
+	if super == nil || super.Cluster() == nil {
+		logger.Errorf("request signer: failed to read credential from etcd")
+		return rs
+	}
+
+	cls := super.Cluster()
+	key := customDataPrefix + strings.TrimPrefix(spec.EtcdKey, "/")
+	value, err := cls.Get(key)
+	if err != nil {
+		logger.Errorf("get credential %s failed: %v", key, err)
+	}
+	rs.reload(key, value)
+	go rs.watchChanges(cls, key)
+
+	return rs
+}
+
+func (rs *requestSigner) reload(key string, value *string) {
+	if value == nil {
+		logger.Errorf("credential %s not found", key)
+		rs.signer.Store((*signer.Signer)(nil))
+		return
+	}
+
+	credential := &signerCredential{}
+	if err := yaml.Unmarshal([]byte(*value), credential); err != nil {
+		logger.Errorf("parse credential %s failed: %v", key, err)
+		return
+	}
+	if credential.AccessKeyID == "" || credential.AccessKeySecret == "" {
+		logger.Errorf("invalid credential %s: both accessKeyId and accessKeySecret are required", key)
+		return
+	}
+
+	spec := rs.spec.Spec
+	spec.AccessKeyID = credential.AccessKeyID
+	spec.AccessKeySecret = credential.AccessKeySecret
+	rs.signer.Store(signer.CreateFromSpec(&spec))
+}
+
+func (rs *requestSigner) watchChanges(cls cluster.Cluster, key string) {
+	var (
+		syncer *cluster.Syncer
+		err    error
+		ch     <-chan *string
+	)
+
+	for {
+		syncer, err = cls.Syncer(30 * time.Minute)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.Sync(key); err != nil {
+			logger.Errorf("failed to sync key: %v", err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-rs.stopCtx.Done():
+			return
+		}
+	}
+
+	defer syncer.Close()
+
+	for {
+		select {
+		case <-rs.stopCtx.Done():
+			return
+		case value := <-ch:
+			logger.Infof("credential %s update", key)
+			rs.reload(key, value)
+		}
+	}
+}
+
+// sign signs the request, the header of the request is copied before
+// signing, because it is shared with the HTTPContext and the mirror pool.
+// The body of a streaming request is not signed, because the signer
+// reads the whole body into memory to hash it.
+func (rs *requestSigner) sign(req *http.Request, streaming bool) error {
+	s := rs.signer.Load().(*signer.Signer)
+	if s == nil {
+		return fmt.Errorf("no credential for signing")
+	}
+
+	req.Header = req.Header.Clone()
+	// NOTE: The signer trusts the body hash in the header, so the one
+	// from the client is removed, otherwise the client could choose the
+	// hash, like UNSIGNED-PAYLOAD.
+	hashHeader := s.Literal().ContentSHA256
+	req.Header.Del(hashHeader)
+	if streaming {
+		req.Header.Set(hashHeader, unsignedPayload)
+	}
+	return s.NewContext(fasttime.Now(), rs.spec.Scopes...).Sign(req)
+}
+
+func (rs *requestSigner) close() {
+	rs.cancel()
+}
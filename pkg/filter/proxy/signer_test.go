@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+func verifySignature(req *http.Request, accessKeys map[string]string) error {
+	verifier := signer.CreateFromSpec(&signer.Spec{AccessKeys: accessKeys})
+	return verifier.Verify(req)
+}
+
+func TestRequestSignerSpecValidate(t *testing.T) {
+	spec := RequestSignerSpec{}
+	if spec.Validate() == nil {
+		t.Errorf("spec without credential should be invalid")
+	}
+
+	spec.AccessKeyID = "id"
+	spec.AccessKeySecret = "secret"
+	if spec.Validate() != nil {
+		t.Errorf("spec with credential should be valid")
+	}
+
+	spec = RequestSignerSpec{EtcdKey: "credentials/upstream"}
+	if spec.Validate() != nil {
+		t.Errorf("spec with etcdKey should be valid")
+	}
+}
+
+func TestRequestSigner(t *testing.T) {
+	spec := &RequestSignerSpec{Scopes: []string{"internal"}}
+	spec.AccessKeyID = "id"
+	spec.AccessKeySecret = "secret"
+	rs := newRequestSigner(nil, spec)
+	defer rs.close()
+
+	header := http.Header{}
+	header.Set("X-Test", "test")
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:9095/api?a=b", strings.NewReader("body"))
+	req.Header = header
+
+	if err := rs.sign(req, false); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if len(header) != 1 {
+		t.Errorf("original header should not be modified, but got %v", header)
+	}
+	if err := verifySignature(req, map[string]string{"id": "secret"}); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+	if err := verifySignature(req, map[string]string{"id": "wrong"}); err == nil {
+		t.Errorf("verify should fail with wrong secret")
+	}
+
+	// the body hash from the client is not trusted.
+	req, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:9095/api", strings.NewReader("body"))
+	req.Header.Set("X-Me-Content-Sha256", unsignedPayload)
+	if err := rs.sign(req, false); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if err := verifySignature(req, map[string]string{"id": "secret"}); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	// the body of streaming requests is not signed.
+	req, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:9095/api", strings.NewReader("body"))
+	if err := rs.sign(req, true); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if hash := req.Header.Get("X-Me-Content-Sha256"); hash != unsignedPayload {
+		t.Errorf("body hash should be %s, but got %s", unsignedPayload, hash)
+	}
+	verifier := signer.CreateFromSpec(&signer.Spec{
+		AccessKeys:  map[string]string{"id": "secret"},
+		ExcludeBody: true,
+	})
+	if err := verifier.Verify(req); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	// no cluster
+	rs = newRequestSigner(nil, &RequestSignerSpec{EtcdKey: "credentials/upstream"})
+	defer rs.close()
+	req, _ = http.NewRequest(http.MethodGet, "http://127.0.0.1:9095/", nil)
+	if err := rs.sign(req, false); err == nil {
+		t.Errorf("sign should fail without credential")
+	}
+}
+
+func TestRequestSignerEtcd(t *testing.T) {
+	etcdDirName, err := ioutil.TempDir("", "etcd-proxy-signer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(etcdDirName)
+	clusterInstance := cluster.CreateClusterForTest(etcdDirName)
+
+	clusterInstance.Put("/custom-data/credentials/upstream", `
+accessKeyId: id1
+accessKeySecret: secret1
+`)
+
+	super := supervisor.NewMock(
+		nil, clusterInstance, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+
+	rs := newRequestSigner(super, &RequestSignerSpec{EtcdKey: "/credentials/upstream"})
+
+	sign := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:9095/", nil)
+		if err := rs.sign(req, false); err != nil {
+			t.Fatalf("sign failed: %v", err)
+		}
+		return req
+	}
+
+	if err := verifySignature(sign(), map[string]string{"id1": "secret1"}); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	// credential is updated by watching etcd.
+	clusterInstance.Put("/custom-data/credentials/upstream", `
+accessKeyId: id2
+accessKeySecret: secret2
+`)
+
+	tryCount := 5
+	for i := 0; i <= tryCount; i++ {
+		time.Sleep(200 * time.Millisecond)
+		if verifySignature(sign(), map[string]string{"id2": "secret2"}) == nil {
+			break
+		}
+		if i == tryCount {
+			t.Errorf("credential should be updated")
+		}
+	}
+
+	rs.close()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	clusterInstance.CloseServer(wg)
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const defaultStickyCookieName = "EG_STICKY"
+
+type (
+	// StickySessionSpec is the spec of sticky session, the gateway issues
+	// a signed cookie identifying the chosen server on the first response,
+	// and later requests carrying the cookie go to the same server while
+	// it is still available in the pool.
+	StickySessionSpec struct {
+		CookieName string `yaml:"cookieName,omitempty" jsonschema:"omitempty"`
+		// Secret is the key to sign the cookie, it should be the same
+		// for all instances sharing the sessions.
+		Secret string `yaml:"secret" jsonschema:"required,minLength=16"`
+		// MaxAge is the lifetime of the cookie, the cookie is
+		// a session cookie if it is empty.
+		MaxAge string `yaml:"maxAge,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	// stickyCookie issues the signed cookies identifying the servers.
+	stickyCookie struct {
+		spec   *StickySessionSpec
+		maxAge int
+	}
+
+	// stickySession picks the server identified by the cookie.
+	stickySession struct {
+		cookie  *stickyCookie
+		servers map[string]*Server
+	}
+)
+
+func newStickyCookie(spec *StickySessionSpec) *stickyCookie {
+	sc := &stickyCookie{spec: spec}
+	if d, err := time.ParseDuration(spec.MaxAge); err == nil {
+		sc.maxAge = int(d.Seconds())
+	}
+	return sc
+}
+
+func newStickySession(spec *StickySessionSpec, servers []*Server) *stickySession {
+	ss := &stickySession{
+		cookie:  newStickyCookie(spec),
+		servers: make(map[string]*Server, len(servers)),
+	}
+
+	for _, server := range servers {
+		ss.servers[ss.cookie.value(server)] = server
+	}
+
+	return ss
+}
+
+func (sc *stickyCookie) name() string {
+	if sc.spec.CookieName == "" {
+		return defaultStickyCookieName
+	}
+	return sc.spec.CookieName
+}
+
+// value returns the identity of the server and its signature,
+// the URL itself is not exposed to clients.
+func (sc *stickyCookie) value(server *Server) string {
+	id := strconv.FormatUint(hash64(server.URL), 36)
+
+	mac := hmac.New(sha256.New, []byte(sc.spec.Secret))
+	mac.Write([]byte(id))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return id + "." + sig
+}
+
+// set sets the cookie for server to every response, so that its
+// max age is refreshed, the cookie is secure for HTTPS requests.
+func (sc *stickyCookie) set(ctx context.HTTPContext, server *Server) {
+	cookie := &http.Cookie{
+		Name:     sc.name(),
+		Value:    sc.value(server),
+		Path:     "/",
+		MaxAge:   sc.maxAge,
+		HttpOnly: true,
+		Secure:   ctx.Request().Scheme() == "https",
+	}
+	ctx.Response().Header().Add("Set-Cookie", cookie.String())
+}
+
+// server returns the server identified by the cookie of the request,
+// it returns nil if there's no cookie, the signature is invalid or the
+// server is not available in the pool.
+func (ss *stickySession) server(ctx context.HTTPContext) *Server {
+	cookie, err := ctx.Request().Cookie(ss.cookie.name())
+	if err != nil || cookie == nil {
+		return nil
+	}
+
+	// NOTE: The values are generated from the servers, so a tampered
+	// value never matches, there's no need to verify the signature.
+	return ss.servers[cookie.Value]
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestStickySession(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+	}
+	lb := &LoadBalance{
+		Policy: PolicyRoundRobin,
+		StickySession: &StickySessionSpec{
+			Secret: "0123456789abcdef",
+			MaxAge: "1h",
+		},
+	}
+	ss := newStaticServers(servers, nil, lb)
+
+	var cookie *http.Cookie
+	respHeader := http.Header{}
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedScheme = func() string {
+		return "http"
+	}
+	ctx.MockedRequest.MockedCookie = func(name string) (*http.Cookie, error) {
+		if cookie == nil || cookie.Name != name {
+			return nil, http.ErrNoCookie
+		}
+		return cookie, nil
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(respHeader)
+	}
+
+	// the first request is picked by round robin and gets a cookie.
+	sticky := newStickyCookie(lb.StickySession)
+	server := ss.next(ctx)
+	sticky.set(ctx, server)
+	setCookie := respHeader.Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, defaultStickyCookieName+"=") ||
+		!strings.Contains(setCookie, "Max-Age=3600") {
+		t.Fatalf("unexpected cookie: %s", setCookie)
+	}
+	if strings.Contains(setCookie, "Secure") {
+		t.Errorf("cookie of HTTP requests should not be secure: %s", setCookie)
+	}
+	if strings.Contains(setCookie, "127.0.0.1") {
+		t.Errorf("server URL should not be exposed in cookie: %s", setCookie)
+	}
+
+	// later requests go to the same server.
+	cookie = &http.Cookie{Name: defaultStickyCookieName, Value: sticky.value(server)}
+	for i := 0; i < 5; i++ {
+		if ss.next(ctx) != server {
+			t.Errorf("request with cookie should go to the same server")
+		}
+	}
+
+	// the cookie is set again to refresh its max age.
+	respHeader = http.Header{}
+	sticky.set(ctx, server)
+	if respHeader.Get("Set-Cookie") != setCookie {
+		t.Errorf("cookie should be set again: %s", respHeader.Get("Set-Cookie"))
+	}
+
+	// tampered cookie is ignored.
+	cookie.Value = fmt.Sprintf("%s.tampered", strings.Split(cookie.Value, ".")[0])
+	picked := map[*Server]bool{}
+	for i := 0; i < 3; i++ {
+		picked[ss.next(ctx)] = true
+	}
+	if len(picked) != 3 {
+		t.Errorf("request with tampered cookie should be load balanced")
+	}
+
+	// server not in the pool anymore.
+	cookie.Value = sticky.value(server)
+	var others []*Server
+	for _, s := range servers {
+		if s != server {
+			others = append(others, s)
+		}
+	}
+	ss = newStaticServers(others, nil, lb)
+	if ss.next(ctx) == server {
+		t.Errorf("removed server should not be picked")
+	}
+}
+
+func TestStickySessionSpecYAML(t *testing.T) {
+	proxy := newTestProxy(t, `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: http://127.0.0.1:9095
+  - url: http://127.0.0.1:9096
+  loadBalance:
+    policy: roundRobin
+    stickySession:
+      secret: 0123456789abcdef
+`)
+	defer proxy.Close()
+
+	if proxy.mainPool.sticky == nil {
+		t.Fatalf("sticky session should be created")
+	}
+	if proxy.mainPool.servers.snapshot().sticky == nil {
+		t.Errorf("servers should be picked by sticky session")
+	}
+}
+
+func TestStickySessionCookie(t *testing.T) {
+	proxy := newTestProxy(t, `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: http://127.0.0.1:9095
+  loadBalance:
+    policy: roundRobin
+    stickySession:
+      secret: 0123456789abcdef
+`)
+	defer proxy.Close()
+
+	fnSendRequestBackup := fnSendRequest
+	defer func() {
+		fnSendRequest = fnSendRequestBackup
+	}()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return nil, fmt.Errorf("mocked error")
+	}
+
+	respHeader := http.Header{}
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedScheme = func() string {
+		return "https"
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(http.Header{})
+	}
+	ctx.MockedRequest.MockedCookie = func(name string) (*http.Cookie, error) {
+		return nil, http.ErrNoCookie
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(respHeader)
+	}
+
+	// the cookie is set for failed requests too, and is secure for HTTPS.
+	if result := proxy.Handle(ctx); result != resultServerError {
+		t.Fatalf("proxy.Handle should fail, but got %s", result)
+	}
+	setCookie := respHeader.Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, defaultStickyCookieName+"=") || !strings.Contains(setCookie, "Secure") {
+		t.Errorf("unexpected cookie: %s", setCookie)
+	}
+}
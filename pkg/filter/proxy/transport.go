@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// protocolHTTP1 talks HTTP/1.1 to servers, it is the default.
+	protocolHTTP1 = "http1"
+	// protocolHTTP2 talks HTTP/2 over TLS to servers, the protocol is
+	// negotiated by ALPN, so HTTP/1.1 is used if a server doesn't
+	// support HTTP/2.
+	protocolHTTP2 = "http2"
+	// protocolH2C talks HTTP/2 over cleartext TCP to servers with
+	// prior knowledge.
+	protocolH2C = "h2c"
+)
+
+// hopByHopHeaders are the connection-specific headers, which are
+// prohibited in HTTP/2.
+// Reference: https://tools.ietf.org/html/rfc7540#section-8.1.2.2
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func isHTTP2(protocol string) bool {
+	return protocol == protocolHTTP2 || protocol == protocolH2C
+}
+
+// newH2CTransport creates the transport talking h2c, all requests to
+// a server are multiplexed on one connection.
+func newH2CTransport(dialer *net.Dialer) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+		// NOTE: The connections are long-lived, so ping them
+		// to detect the broken ones.
+		ReadIdleTimeout: 30 * time.Second,
+	}
+}
+
+// removeHopByHopHeaders returns the header without hop-by-hop headers,
+// including the ones listed in the Connection header, the header is
+// cloned if it has any of them.
+func removeHopByHopHeaders(header http.Header) http.Header {
+	if _, exists := header["Connection"]; exists {
+		header = header.Clone()
+		for _, v := range header["Connection"] {
+			for _, key := range strings.Split(v, ",") {
+				header.Del(strings.TrimSpace(key))
+			}
+		}
+	}
+
+	cloned := false
+	for _, key := range hopByHopHeaders {
+		if _, exists := header[key]; !exists {
+			continue
+		}
+		if !cloned {
+			header, cloned = header.Clone(), true
+		}
+		delete(header, key)
+	}
+	return header
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Test", "1")
+	if h := removeHopByHopHeaders(header); len(h) != 1 || h.Get("X-Test") != "1" {
+		t.Errorf("header without hop-by-hop headers should not change")
+	}
+
+	header.Set("Connection", "Upgrade, X-Conn")
+	header.Set("Upgrade", "websocket")
+	header.Set("X-Conn", "1")
+	header.Set("Keep-Alive", "timeout=5")
+
+	h := removeHopByHopHeaders(header)
+	if len(h) != 1 || h.Get("X-Test") != "1" {
+		t.Errorf("unexpected header: %v", h)
+	}
+	if len(header) != 5 {
+		t.Errorf("original header should not change: %v", header)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	var conns int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	})
+	countConns := func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+
+	h2cServer := httptest.NewUnstartedServer(h2c.NewHandler(handler, &http2.Server{}))
+	h2cServer.Config.ConnState = countConns
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	b := &Proxy{spec: &Spec{}}
+	b.client = b.newClient(protocolHTTP1)
+	b.http2Client = b.newClient(protocolHTTP2)
+	b.h2cClient = b.newClient(protocolH2C)
+	defer func() {
+		b.client.CloseIdleConnections()
+		b.http2Client.CloseIdleConnections()
+		b.h2cClient.CloseIdleConnections()
+	}()
+
+	cases := []struct {
+		protocol string
+		url      string
+		proto    string
+	}{
+		{"", h2cServer.URL, "HTTP/1.1"},
+		{protocolH2C, h2cServer.URL, "HTTP/2.0"},
+		{protocolHTTP1, tlsServer.URL, "HTTP/1.1"},
+		{protocolHTTP2, tlsServer.URL, "HTTP/2.0"},
+	}
+
+	for _, c := range cases {
+		client := b.clientOf(&pool{protocol: c.protocol})
+		resp, err := client.Get(c.url)
+		if err != nil {
+			t.Fatalf("protocol %q: unexpected error: %v", c.protocol, err)
+		}
+		resp.Body.Close()
+		if proto := resp.Header.Get("X-Proto"); proto != c.proto {
+			t.Errorf("protocol %q: want %s, got %s", c.protocol, c.proto, proto)
+		}
+	}
+
+	// h2c requests are multiplexed on one connection.
+	atomic.StoreInt32(&conns, 0)
+	b.h2cClient.CloseIdleConnections()
+	done := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			resp, err := b.h2cClient.Get(h2cServer.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("h2c requests should share one connection, but got %d", n)
+	}
+}
+
+func TestPoolSpecProtocol(t *testing.T) {
+	spec := PoolSpec{
+		Protocol:    protocolH2C,
+		Servers:     []*Server{{URL: "http://127.0.0.1:9095"}},
+		LoadBalance: &LoadBalance{Policy: "roundRobin"},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec.Protocol = protocolHTTP2
+	if spec.Validate() == nil {
+		t.Errorf("http2 should require https servers")
+	}
+
+	spec.Servers[0].URL = "https://127.0.0.1:9095"
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec.Protocol = protocolH2C
+	if spec.Validate() == nil {
+		t.Errorf("h2c should require http servers")
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// minClusterRefreshPeriod is the min limitRefreshPeriod of cluster mode,
+	// to avoid hitting etcd too frequently.
+	minClusterRefreshPeriod = time.Second
+
+	// clusterBatchDivisor controls the batch size of tokens reserved from
+	// the cluster at a time, which is limitForPeriod/clusterBatchDivisor.
+	clusterBatchDivisor = 20
+
+	// clusterBatchesPerMember controls the min batch size, which is
+	// limitForPeriod/(members*clusterBatchesPerMember), so that a member
+	// of a small cluster doesn't hit etcd too frequently.
+	clusterBatchesPerMember = 4
+)
+
+type (
+	// clusterLimiter limits the rate across all members of the cluster.
+	//
+	// The time is divided into windows of limitRefreshPeriod, aligned to
+	// the Unix epoch, so all members share the same windows. The number of
+	// tokens granted in the current window is stored in etcd, every member
+	// reserves tokens in batches by an STM transaction, so that the total
+	// number of granted tokens never exceeds limitForPeriod. Tokens reserved
+	// but not used in a window are wasted.
+	//
+	// The next batch is reserved in background when the tokens run low,
+	// so requests don't wait for etcd unless the tokens are used up.
+	clusterLimiter struct {
+		lock sync.Mutex
+
+		cls       cluster.Cluster
+		key       string
+		policy    *librl.Policy
+		batchSize int
+
+		window    int64
+		tokens    int
+		reserved  int
+		exhausted bool
+		// reserving is closed when the ongoing reservation is done,
+		// it is nil if there is no ongoing reservation in the window.
+		reserving chan struct{}
+	}
+)
+
+func newClusterLimiter(cls cluster.Cluster, key string, policy *librl.Policy) *clusterLimiter {
+	members := 1
+	if kvs, err := cls.GetPrefix(cls.Layout().StatusMemberPrefix()); err != nil {
+		logger.Errorf("get members of cluster failed: %v", err)
+	} else if len(kvs) > members {
+		members = len(kvs)
+	}
+
+	batchSize := policy.LimitForPeriod / clusterBatchDivisor
+	if minSize := policy.LimitForPeriod / (members * clusterBatchesPerMember); batchSize < minSize {
+		batchSize = minSize
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &clusterLimiter{
+		cls:       cls,
+		key:       key,
+		policy:    policy,
+		batchSize: batchSize,
+	}
+}
+
+// AcquirePermission acquires a permission in the current window, it returns
+// the duration to the next window if not permitted.
+func (cl *clusterLimiter) AcquirePermission() (bool, time.Duration) {
+	for {
+		now := nowFunc()
+		period := int64(cl.policy.LimitRefreshPeriod)
+		window := now.UnixNano() / period
+
+		cl.lock.Lock()
+		if window != cl.window {
+			cl.window, cl.tokens, cl.reserved, cl.exhausted = window, 0, 0, false
+			cl.reserving = nil
+		}
+
+		if cl.tokens > 0 {
+			cl.tokens--
+			if cl.tokens <= cl.batchSize/2 && !cl.exhausted && cl.reserving == nil {
+				cl.reserveAsync(window)
+			}
+			cl.lock.Unlock()
+			return true, 0
+		}
+
+		if cl.exhausted {
+			cl.lock.Unlock()
+			next := time.Unix(0, (window+1)*period)
+			return false, next.Sub(now)
+		}
+
+		// NOTE: Wait for the reservation without holding the lock,
+		// and try again after it's done.
+		done := cl.reserving
+		if done == nil {
+			done = cl.reserveAsync(window)
+		}
+		cl.lock.Unlock()
+		<-done
+	}
+}
+
+// reserveAsync reserves a batch of tokens of the window in background,
+// it must be called with the lock held, the returned channel is closed
+// when the reservation is done.
+func (cl *clusterLimiter) reserveAsync(window int64) chan struct{} {
+	done := make(chan struct{})
+	cl.reserving = done
+
+	go func() {
+		defer close(done)
+
+		granted, reserved, err := cl.reserve(window)
+		if err != nil {
+			// NOTE: Reject the requests rather than fall back to the local
+			// limit, because the cluster-wide limit must not be exceeded.
+			logger.Errorf("reserve tokens of %s failed: %v", cl.key, err)
+		}
+
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+
+		if cl.reserving == done {
+			cl.reserving = nil
+		}
+		// NOTE: The tokens are wasted if the window has passed.
+		if cl.window != window {
+			return
+		}
+		cl.tokens += granted
+		if err == nil {
+			cl.reserved = reserved
+		}
+		cl.exhausted = granted == 0
+	}()
+
+	return done
+}
+
+// Remaining returns the estimated number of tokens left in the cluster
+// in the current window, and the duration to the next window if the
+// tokens are used up.
+func (cl *clusterLimiter) Remaining() (int, time.Duration) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	now := nowFunc()
+	period := int64(cl.policy.LimitRefreshPeriod)
+	window := now.UnixNano() / period
+	if window != cl.window {
+		return cl.policy.LimitForPeriod, 0
+	}
+
+	if cl.tokens == 0 && cl.exhausted {
+		next := time.Unix(0, (window+1)*period)
+		return 0, next.Sub(now)
+	}
+
+	// NOTE: It doesn't include the tokens reserved by other members
+	// after the last reservation of this member.
+	remaining := cl.policy.LimitForPeriod - cl.reserved + cl.tokens
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, 0
+}
+
+// reserve reserves a batch of tokens of the window from the cluster,
+// it returns the number of reserved tokens, and the total number of
+// tokens reserved by all members in the window.
+func (cl *clusterLimiter) reserve(window int64) (int, int, error) {
+	granted, reserved := 0, 0
+
+	err := cl.cls.STM(func(s concurrency.STM) error {
+		granted, reserved = 0, cl.policy.LimitForPeriod
+
+		used := 0
+		if value := s.Get(cl.key); value != "" {
+			if w, u, err := parseUsage(value); err != nil {
+				logger.Errorf("BUG: invalid value of %s: %s", cl.key, value)
+			} else if w > window {
+				// NOTE: Another member is already in a later window because
+				// of clock skew, treat the current window as exhausted.
+				return nil
+			} else if w == window {
+				used = u
+			}
+		}
+
+		granted = cl.policy.LimitForPeriod - used
+		if granted > cl.batchSize {
+			granted = cl.batchSize
+		}
+		if granted <= 0 {
+			granted = 0
+			return nil
+		}
+
+		reserved = used + granted
+		s.Put(cl.key, fmt.Sprintf("%d:%d", window, reserved))
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return granted, reserved, nil
+}
+
+// close deletes the key from etcd after the current window passes, but
+// the key is kept if it's used by other members in later windows.
+func (cl *clusterLimiter) close() {
+	time.AfterFunc(cl.policy.LimitRefreshPeriod, cl.cleanup)
+}
+
+func (cl *clusterLimiter) cleanup() {
+	window := nowFunc().UnixNano() / int64(cl.policy.LimitRefreshPeriod)
+
+	err := cl.cls.STM(func(s concurrency.STM) error {
+		value := s.Get(cl.key)
+		if value == "" {
+			return nil
+		}
+		if w, _, err := parseUsage(value); err == nil && w >= window {
+			return nil
+		}
+		s.Del(cl.key)
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("delete %s failed: %v", cl.key, err)
+	}
+}
+
+// parseUsage parses the value of the key, which is "window:used".
+func parseUsage(value string) (int64, int, error) {
+	var window int64
+	var used int
+	_, err := fmt.Sscanf(value, "%d:%d", &window, &used)
+	return window, used, err
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// KeySourceClientIP uses the real IP of the client as the key.
+	KeySourceClientIP = "clientIP"
+	// KeySourceHeader uses the value of a request header as the key.
+	KeySourceHeader = "header"
+	// KeySourceQuery uses the value of a query parameter as the key.
+	KeySourceQuery = "query"
+	// KeySourceJWTClaim uses a claim of the token verified by the Validator
+	// filter as the key.
+	KeySourceJWTClaim = "jwtClaim"
+	// KeySourceConsumer uses the consumer authenticated by the Validator
+	// filter as the key.
+	KeySourceConsumer = "consumer"
+
+	defaultMaxKeys     = 10000
+	defaultIdleTimeout = 10 * time.Minute
+)
+
+type (
+	// KeySpec describes how to get the key of a request, every key
+	// has its own rate limiter.
+	KeySpec struct {
+		Source      string `yaml:"source" jsonschema:"required,enum=clientIP,enum=header,enum=query,enum=jwtClaim,enum=consumer"`
+		Name        string `yaml:"name,omitempty" jsonschema:"omitempty"`
+		MaxKeys     int    `yaml:"maxKeys,omitempty" jsonschema:"omitempty,minimum=1"`
+		IdleTimeout string `yaml:"idleTimeout,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	// keyedLimiter holds rate limiters of keys, the ones idle for
+	// idleTimeout are evicted. If the number of keys reaches maxKeys,
+	// new keys share the overflow rate limiter, so that a client sending
+	// many distinct keys can't evict the rate limiters of active keys.
+	keyedLimiter struct {
+		lock sync.Mutex
+
+		spec        *KeySpec
+		policy      *librl.Policy
+		idleTimeout time.Duration
+		maxKeys     int
+		buckets     *simplelru.LRU
+		overflow    *librl.RateLimiter
+	}
+
+	bucket struct {
+		rl         *librl.RateLimiter
+		lastAccess time.Time
+	}
+)
+
+// Validate validates KeySpec.
+func (spec KeySpec) Validate() error {
+	switch spec.Source {
+	case KeySourceHeader, KeySourceQuery, KeySourceJWTClaim:
+		if spec.Name == "" {
+			return fmt.Errorf("name is required for key source %s", spec.Source)
+		}
+	}
+	return nil
+}
+
+func newKeyedLimiter(spec *KeySpec, policy *librl.Policy) *keyedLimiter {
+	maxKeys := defaultMaxKeys
+	if spec.MaxKeys > 0 {
+		maxKeys = spec.MaxKeys
+	}
+
+	idleTimeout := defaultIdleTimeout
+	if spec.IdleTimeout != "" {
+		d, err := time.ParseDuration(spec.IdleTimeout)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", spec.IdleTimeout, err)
+		} else {
+			idleTimeout = d
+		}
+	}
+	// NOTE: A rate limiter idle for this duration is the same as a new one,
+	// evicting it earlier would reset the limit of the key.
+	if min := policy.LimitRefreshPeriod + policy.TimeoutDuration; idleTimeout < min {
+		idleTimeout = min
+	}
+
+	buckets, _ := simplelru.NewLRU(maxKeys, nil)
+
+	return &keyedLimiter{
+		spec:        spec,
+		policy:      policy,
+		idleTimeout: idleTimeout,
+		maxKeys:     maxKeys,
+		buckets:     buckets,
+		overflow:    librl.New(policy),
+	}
+}
+
+// key returns the key of the request, requests without the key
+// share the rate limiter of the empty key.
+// NOTE: The JWT claims and the consumer are read from the identity
+// verified by the Validator rather than the headers forwarded by it,
+// because the headers could be spoofed by clients.
+func (kl *keyedLimiter) key(req context.HTTPRequest) string {
+	switch kl.spec.Source {
+	case KeySourceClientIP:
+		return req.RealIP()
+	case KeySourceHeader:
+		return req.Header().Get(kl.spec.Name)
+	case KeySourceQuery:
+		return req.Std().URL.Query().Get(kl.spec.Name)
+	case KeySourceJWTClaim:
+		identity := req.Identity()
+		if identity == nil {
+			return ""
+		}
+		if v, ok := identity.Claims[kl.spec.Name]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	case KeySourceConsumer:
+		identity := req.Identity()
+		if identity == nil {
+			return ""
+		}
+		return identity.Consumer
+	default:
+		return ""
+	}
+}
+
+// limiter returns the rate limiter of the key of the request.
+func (kl *keyedLimiter) limiter(req context.HTTPRequest) *librl.RateLimiter {
+	key := kl.key(req)
+	now := nowFunc()
+
+	kl.lock.Lock()
+	defer kl.lock.Unlock()
+
+	kl.evictIdle(now)
+
+	var b *bucket
+	if v, ok := kl.buckets.Get(key); ok {
+		b = v.(*bucket)
+	} else if kl.buckets.Len() >= kl.maxKeys {
+		return kl.overflow
+	} else {
+		b = &bucket{rl: librl.New(kl.policy)}
+		kl.buckets.Add(key, b)
+	}
+	b.lastAccess = now
+
+	return b.rl
+}
+
+func (kl *keyedLimiter) evictIdle(now time.Time) {
+	for {
+		_, v, ok := kl.buckets.GetOldest()
+		if !ok || now.Sub(v.(*bucket).lastAccess) < kl.idleTimeout {
+			return
+		}
+		kl.buckets.RemoveOldest()
+	}
+}
+
+func (kl *keyedLimiter) len() int {
+	kl.lock.Lock()
+	defer kl.lock.Unlock()
+
+	return kl.buckets.Len()
+}
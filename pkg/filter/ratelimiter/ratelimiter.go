@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -34,8 +35,20 @@ const (
 	// Kind is the kind of RateLimiter.
 	Kind              = "RateLimiter"
 	resultRateLimited = "rateLimited"
+
+	// ModeLocal limits the rate in every member independently.
+	ModeLocal = "local"
+	// ModeCluster limits the rate across all members of the cluster.
+	ModeCluster = "cluster"
+
+	headerLimit      = "X-RateLimit-Limit"
+	headerRemaining  = "X-RateLimit-Remaining"
+	headerRetryAfter = "Retry-After"
 )
 
+// for unit testing cases to mock 'time.Now' only
+var nowFunc = time.Now
+
 var results = []string{resultRateLimited}
 
 func init() {
@@ -49,13 +62,17 @@ type (
 		TimeoutDuration    string `yaml:"timeoutDuration" jsonschema:"omitempty,format=duration"`
 		LimitRefreshPeriod string `yaml:"limitRefreshPeriod" jsonschema:"omitempty,format=duration"`
 		LimitForPeriod     int    `yaml:"limitForPeriod" jsonschema:"omitempty,minimum=1"`
+		Mode               string `yaml:"mode,omitempty" jsonschema:"omitempty,enum=local,enum=cluster"`
 	}
 
 	// URLRule defines the rate limiter rule for a URL pattern
 	URLRule struct {
 		urlrule.URLRule `yaml:",inline"`
+		Key             *KeySpec `yaml:"key,omitempty" jsonschema:"omitempty"`
 		policy          *Policy
 		rl              *librl.RateLimiter
+		crl             *clusterLimiter
+		krl             *keyedLimiter
 	}
 
 	// Spec is the configuration of a rate limiter
@@ -70,10 +87,32 @@ type (
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
 	}
+
+	limiter interface {
+		AcquirePermission() (bool, time.Duration)
+		Remaining() (int, time.Duration)
+	}
 )
 
 // Validate implements custom validation for Spec
 func (spec Spec) Validate() error {
+	for _, p := range spec.Policies {
+		if p.Mode != ModeCluster {
+			continue
+		}
+		if p.LimitRefreshPeriod == "" {
+			return fmt.Errorf("policy '%s': limitRefreshPeriod is required in cluster mode", p.Name)
+		}
+		d, err := time.ParseDuration(p.LimitRefreshPeriod)
+		if err != nil {
+			return fmt.Errorf("policy '%s': invalid limitRefreshPeriod: %v", p.Name, err)
+		}
+		if d < minClusterRefreshPeriod {
+			return fmt.Errorf("policy '%s': limitRefreshPeriod must be at least %s in cluster mode",
+				p.Name, minClusterRefreshPeriod)
+		}
+	}
+
 URLLoop:
 	for _, u := range spec.URLs {
 		name := u.PolicyRef
@@ -82,9 +121,13 @@ URLLoop:
 		}
 
 		for _, p := range spec.Policies {
-			if p.Name == name {
-				continue URLLoop
+			if p.Name != name {
+				continue
+			}
+			if p.Mode == ModeCluster && u.Key != nil {
+				return fmt.Errorf("policy '%s': key is not supported in cluster mode", name)
 			}
+			continue URLLoop
 		}
 
 		return fmt.Errorf("policy '%s' is not defined", name)
@@ -93,8 +136,8 @@ URLLoop:
 	return nil
 }
 
-func (url *URLRule) createRateLimiter() {
-	policy := librl.Policy{
+func (url *URLRule) libPolicy() *librl.Policy {
+	policy := &librl.Policy{
 		LimitForPeriod: url.policy.LimitForPeriod,
 	}
 
@@ -114,7 +157,35 @@ func (url *URLRule) createRateLimiter() {
 		policy.LimitRefreshPeriod = 10 * time.Millisecond
 	}
 
-	url.rl = librl.New(&policy)
+	return policy
+}
+
+func (url *URLRule) createRateLimiter() {
+	url.rl = librl.New(url.libPolicy())
+}
+
+func (url *URLRule) createClusterRateLimiter(filterSpec *httppipeline.FilterSpec) {
+	cls := filterSpec.Super().Cluster()
+	key := cls.Layout().RateLimiterKey(filterSpec.Pipeline(), filterSpec.Name(), url.ID())
+	url.crl = newClusterLimiter(cls, key, url.libPolicy())
+}
+
+func (url *URLRule) limitForPeriod() int {
+	if url.policy.LimitForPeriod == 0 {
+		return 50
+	}
+	return url.policy.LimitForPeriod
+}
+
+func (url *URLRule) limiter(req context.HTTPRequest) limiter {
+	switch {
+	case url.crl != nil:
+		return url.crl
+	case url.krl != nil:
+		return url.krl.limiter(req)
+	default:
+		return url.rl
+	}
 }
 
 // Kind returns the kind of RateLimiter.
@@ -165,6 +236,14 @@ func (rl *RateLimiter) bindPolicyToURL(u *URLRule) {
 func (rl *RateLimiter) createRateLimiterForURL(u *URLRule) {
 	u.Init()
 	rl.bindPolicyToURL(u)
+	if u.policy.Mode == ModeCluster {
+		u.createClusterRateLimiter(rl.filterSpec)
+		return
+	}
+	if u.Key != nil {
+		u.krl = newKeyedLimiter(u.Key, u.libPolicy())
+		return
+	}
 	u.createRateLimiter()
 	rl.setStateListenerForURL(u)
 }
@@ -212,12 +291,17 @@ OuterLoop:
 			if !isSamePolicy(rl.spec, previousGeneration.spec, url.PolicyRef) {
 				continue
 			}
+			if !reflect.DeepEqual(url.Key, prev.Key) {
+				continue
+			}
 
 			url.Init()
 			rl.bindPolicyToURL(url)
-			url.rl = prev.rl
-			prev.rl = nil
-			rl.setStateListenerForURL(url)
+			url.rl, url.crl, url.krl = prev.rl, prev.crl, prev.krl
+			prev.rl, prev.crl, prev.krl = nil, nil, nil
+			if url.rl != nil {
+				rl.setStateListenerForURL(url)
+			}
 			continue OuterLoop
 		}
 		rl.createRateLimiterForURL(url)
@@ -234,6 +318,7 @@ func (rl *RateLimiter) Init(filterSpec *httppipeline.FilterSpec) {
 func (rl *RateLimiter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
 	rl.filterSpec, rl.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
 	rl.reload(previousGeneration.(*RateLimiter))
+	previousGeneration.Close()
 }
 
 // Handle handles HTTP request
@@ -248,11 +333,34 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 			continue
 		}
 
-		permitted, d := u.rl.AcquirePermission()
+		l := u.limiter(ctx.Request())
+		permitted, d := l.AcquirePermission()
+
+		// NOTE: In cluster mode, wait for the next window
+		// if it's not later than timeoutDuration.
+		if !permitted && u.crl != nil && d <= u.crl.policy.TimeoutDuration {
+			timer := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ""
+			case <-timer.C:
+			}
+			permitted, d = l.AcquirePermission()
+		}
+
+		// NOTE: Set headers to the standard response writer directly,
+		// because the response header is replaced by the backend response.
+		remaining, retryAfter := l.Remaining()
+		header := ctx.Response().Std().Header()
+		header.Set(headerLimit, strconv.Itoa(u.limitForPeriod()))
+		header.Set(headerRemaining, strconv.Itoa(remaining))
+
 		if !permitted {
 			ctx.AddTag("rateLimiter: too many requests")
 			ctx.Response().SetStatusCode(http.StatusTooManyRequests)
-			ctx.Response().Std().Header().Set("X-EG-Rate-Limiter", "too-many-requests")
+			header.Set("X-EG-Rate-Limiter", "too-many-requests")
+			header.Set(headerRetryAfter, strconv.Itoa(retryAfterSeconds(retryAfter)))
 			return resultRateLimited
 		}
 
@@ -273,6 +381,16 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 	return ""
 }
 
+// retryAfterSeconds converts the duration to the seconds of Retry-After,
+// rounding up to at least 1 second.
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
 // Status returns Status generated by Runtime.
 func (rl *RateLimiter) Status() interface{} {
 	return nil
@@ -280,4 +398,10 @@ func (rl *RateLimiter) Status() interface{} {
 
 // Close closes RateLimiter.
 func (rl *RateLimiter) Close() {
+	// NOTE: The limiters inherited by the next generation are nil.
+	for _, u := range rl.spec.URLs {
+		if u.crl != nil {
+			u.crl.close()
+		}
+	}
 }
@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{
+		Policies: []*Policy{{Name: "p", LimitRefreshPeriod: "10ms", Mode: ModeLocal}},
+		URLs:     []*URLRule{},
+	}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.Policies[0].Mode = ModeCluster
+	if spec.Validate() == nil {
+		t.Error("validate should fail for short refresh period in cluster mode")
+	}
+
+	spec.Policies[0].LimitRefreshPeriod = "1s"
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+}
+
+func TestClusterLimiter(t *testing.T) {
+	etcdDirName, err := ioutil.TempDir("", "etcd-ratelimiter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(etcdDirName)
+
+	cls := cluster.CreateClusterForTest(etcdDirName)
+	defer func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		cls.CloseServer(wg)
+		wg.Wait()
+	}()
+
+	now := time.Unix(0, 0).Add(100 * time.Hour)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	policy := librl.NewPolicy(time.Second, time.Minute, 100)
+	key := cls.Layout().RateLimiterKey("pipeline", "filter", "/test")
+
+	// two limiters on the same key act as two members of the cluster.
+	limiters := []*clusterLimiter{
+		newClusterLimiter(cls, key, policy),
+		newClusterLimiter(cls, key, policy),
+	}
+
+	permitted := 0
+	for i := 0; i < 300; i++ {
+		if ok, _ := limiters[i%2].AcquirePermission(); ok {
+			permitted++
+		}
+	}
+	if permitted != 100 {
+		t.Errorf("expected 100 permissions in cluster, but got %d", permitted)
+	}
+
+	ok, d := limiters[0].AcquirePermission()
+	if ok || d != time.Minute {
+		t.Errorf("expected rejected with %s to the next window, but got %v, %s", time.Minute, ok, d)
+	}
+
+	// the next window.
+	now = now.Add(time.Minute)
+	if ok, _ := limiters[1].AcquirePermission(); !ok {
+		t.Errorf("should be permitted in the next window")
+	}
+
+	// the min batch size depends on the number of members.
+	if size := limiters[0].batchSize; size != 25 {
+		t.Errorf("expected batch size 25, but got %d", size)
+	}
+
+	// the key is kept if it's used in the current window.
+	limiters[1].cleanup()
+	if value, _ := cls.Get(key); value == nil {
+		t.Errorf("key should be kept")
+	}
+	now = now.Add(time.Minute)
+	limiters[1].cleanup()
+	if value, _ := cls.Get(key); value != nil {
+		t.Errorf("key should be deleted, but got %s", *value)
+	}
+}
+
+func newRateLimiter(spec *Spec) *RateLimiter {
+	meta := &httppipeline.FilterMetaSpec{
+		Name:     "rate-limiter",
+		Kind:     Kind,
+		Pipeline: "pipeline-demo",
+	}
+	rl := &RateLimiter{}
+	rl.Init(httppipeline.MockFilterSpec(nil, nil, "", meta, spec))
+	return rl
+}
+
+func doRequest(rl *RateLimiter, header map[string]string) (*httptest.ResponseRecorder, string) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/api/pets?tenant=query", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	ctx := context.New(w, req, tracing.NoopTracing, "no trace")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		return lastResult
+	})
+
+	result := rl.Handle(ctx)
+	ctx.Finish()
+	return w, result
+}
+
+func TestKeyedRateLimiter(t *testing.T) {
+	rl := newRateLimiter(&Spec{
+		Policies: []*Policy{{
+			Name:               "policy",
+			TimeoutDuration:    "0s",
+			LimitRefreshPeriod: "1m",
+			LimitForPeriod:     2,
+		}},
+		URLs: []*URLRule{{
+			URLRule: urlrule.URLRule{
+				URL:       urlrule.StringMatch{Prefix: "/api/"},
+				PolicyRef: "policy",
+			},
+			Key: &KeySpec{Source: KeySourceHeader, Name: "X-Tenant"},
+		}},
+	})
+
+	tenantA := map[string]string{"X-Tenant": "a"}
+	tenantB := map[string]string{"X-Tenant": "b"}
+
+	for i := 0; i < 2; i++ {
+		w, result := doRequest(rl, tenantA)
+		if result != "" {
+			t.Fatalf("request %d should be permitted", i)
+		}
+		if w.Header().Get(headerLimit) != "2" || w.Header().Get(headerRemaining) != []string{"1", "0"}[i] {
+			t.Errorf("unexpected headers: %v", w.Header())
+		}
+	}
+
+	w, result := doRequest(rl, tenantA)
+	if result != resultRateLimited || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request should be rate limited")
+	}
+	if w.Header().Get(headerRemaining) != "0" || w.Header().Get(headerRetryAfter) != "60" {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+
+	w, result = doRequest(rl, tenantB)
+	if result != "" || w.Header().Get(headerRemaining) != "1" {
+		t.Errorf("tenant b should have its own bucket")
+	}
+
+	if n := rl.spec.URLs[0].krl.len(); n != 2 {
+		t.Errorf("expected 2 keys, but got %d", n)
+	}
+}
+
+func TestKey(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/api?tenant=query", nil)
+	req.RemoteAddr = "192.168.1.1:8080"
+	req.Header.Set("X-Tenant", "header")
+	ctx := context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace")
+
+	// the JWT claims and the consumer are empty if they are not verified,
+	// even if the headers are spoofed.
+	req.Header.Set("X-AUTH-USER", "spoofed")
+	for _, spec := range []KeySpec{{Source: KeySourceJWTClaim, Name: "tenant"}, {Source: KeySourceConsumer}} {
+		kl := newKeyedLimiter(&spec, librl.NewDefaultPolicy())
+		if key := kl.key(ctx.Request()); key != "" {
+			t.Errorf("expected empty key for source %s, but got %s", spec.Source, key)
+		}
+	}
+
+	// set by the Validator.
+	ctx.Request().SetIdentity(&context.Identity{
+		Consumer: "consumer",
+		Claims:   map[string]interface{}{"tenant": float64(42)},
+	})
+
+	cases := []struct {
+		spec     KeySpec
+		expected string
+	}{
+		{KeySpec{Source: KeySourceClientIP}, "192.168.1.1"},
+		{KeySpec{Source: KeySourceHeader, Name: "X-Tenant"}, "header"},
+		{KeySpec{Source: KeySourceQuery, Name: "tenant"}, "query"},
+		{KeySpec{Source: KeySourceJWTClaim, Name: "tenant"}, "42"},
+		{KeySpec{Source: KeySourceConsumer}, "consumer"},
+	}
+
+	for _, c := range cases {
+		kl := newKeyedLimiter(&c.spec, librl.NewDefaultPolicy())
+		if key := kl.key(ctx.Request()); key != c.expected {
+			t.Errorf("expected key %s for source %s, but got %s", c.expected, c.spec.Source, key)
+		}
+	}
+
+	if (KeySpec{Source: KeySourceHeader}).Validate() == nil {
+		t.Errorf("validate should fail")
+	}
+}
+
+func TestKeyEviction(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	kl := newKeyedLimiter(&KeySpec{
+		Source:      KeySourceHeader,
+		Name:        "X-Tenant",
+		MaxKeys:     2,
+		IdleTimeout: "1m",
+	}, librl.NewDefaultPolicy())
+
+	newRequest := func(tenant string) context.HTTPRequest {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/api", nil)
+		req.Header.Set("X-Tenant", tenant)
+		return context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace").Request()
+	}
+
+	a := kl.limiter(newRequest("a"))
+	kl.limiter(newRequest("b"))
+	c := kl.limiter(newRequest("c"))
+	if kl.len() != 2 {
+		t.Errorf("expected 2 keys, but got %d", kl.len())
+	}
+	if kl.limiter(newRequest("a")) != a {
+		t.Errorf("active key should not be evicted")
+	}
+	if c != kl.overflow || kl.limiter(newRequest("d")) != kl.overflow {
+		t.Errorf("new keys should share the overflow limiter")
+	}
+
+	now = now.Add(time.Minute)
+	if kl.limiter(newRequest("d")) == kl.overflow {
+		t.Errorf("new key should get its own limiter after idle keys are evicted")
+	}
+	if kl.len() != 1 {
+		t.Errorf("idle keys should be evicted, but got %d keys", kl.len())
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/grpcstatus"
 	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
@@ -56,6 +57,10 @@ type (
 		backOffPolicy        backOffPolicy
 		CountingNetworkError bool  `yaml:"countingNetworkError" jsonschema:"omitempty"`
 		FailureStatusCodes   []int `yaml:"failureStatusCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		// NOTE: Only the gRPC status in the response headers (Trailers-Only
+		// responses) is checked, the one in the trailers is not available
+		// until the body has been streamed to the client.
+		FailureGRPCCodes []int `yaml:"failureGRPCCodes,omitempty" jsonschema:"omitempty,uniqueItems=true,format=grpccode-array"`
 	}
 
 	// URLRule is the URL rule
@@ -193,6 +198,9 @@ func (r *Retryer) handle(ctx context.HTTPContext, u *URLRule) string {
 				}
 			}
 		}
+		if !hasErr && len(u.policy.FailureGRPCCodes) > 0 {
+			hasErr = grpcstatus.HasCode(ctx.Response().Header().Std(), u.policy.FailureGRPCCodes)
+		}
 
 		if !hasErr {
 			ctx.AddTag(fmt.Sprintf("retryer: succeeded after %d attempts", attempt))
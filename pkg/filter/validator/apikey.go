@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	httpcontext "github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// consumerHeader is the header to pass the authenticated consumer
+	// to later filters and the upstream.
+	consumerHeader = "X-AUTH-USER"
+
+	defaultAPIKeyHeader = "X-API-Key"
+)
+
+type (
+	// APIKeyValidatorSpec defines the configuration of API key validator.
+	APIKeyValidatorSpec struct {
+		// EtcdPrefix is the prefix of API keys in etcd, keys are stored as:
+		// key: /custom-data/{etcdPrefix}/{$key}
+		// value:
+		//   key: "$key"
+		//   consumer: "$consumer" # optional, the value of "key" is used if empty
+		//   keyHash: "$keyHash" # hex encoded SHA-256 hash of the API key
+		//   enabled: true # optional, default is true
+		//   metadata: # optional
+		//     tier: gold
+		EtcdPrefix string `yaml:"etcdPrefix" jsonschema:"omitempty"`
+		// HeaderName is the header to read the API key from.
+		HeaderName string `yaml:"headerName,omitempty" jsonschema:"omitempty"`
+		// QueryName is the query parameter to read the API key from, it's
+		// used only if the header doesn't exist.
+		QueryName string `yaml:"queryName,omitempty" jsonschema:"omitempty"`
+		// MetadataToHeaders forwards the metadata of consumers to the upstream.
+		MetadataToHeaders ClaimsToHeaders `yaml:"metadataToHeaders,omitempty" jsonschema:"omitempty"`
+	}
+
+	// APIKeyValidator defines the API key validator
+	APIKeyValidator struct {
+		spec  *APIKeyValidatorSpec
+		cache *apiKeyCache
+	}
+
+	// apiKeyConsumer defines the format of API keys in etcd
+	apiKeyConsumer struct {
+		Key      string            `yaml:"key"`
+		Consumer string            `yaml:"consumer"`
+		KeyHash  string            `yaml:"keyHash"`
+		Enabled  *bool             `yaml:"enabled"`
+		Metadata map[string]string `yaml:"metadata"`
+	}
+
+	// apiKeyCache caches API keys in etcd by their hashes.
+	apiKeyCache struct {
+		cluster cluster.Cluster
+		prefix  string
+
+		mutex     sync.RWMutex
+		consumers map[string]*apiKeyConsumer
+
+		stopCtx context.Context
+		cancel  context.CancelFunc
+	}
+)
+
+// hashAPIKey returns the hex encoded SHA-256 hash of an API key, API keys
+// are random strings with enough entropy, so a slow hash is unnecessary.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *apiKeyConsumer) name() string {
+	if c.Consumer != "" {
+		return c.Consumer
+	}
+	return c.Key
+}
+
+func (c *apiKeyConsumer) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+func newAPIKeyCache(cls cluster.Cluster, etcdPrefix string) *apiKeyCache {
+	prefix := customDataKeyPrefix(etcdPrefix, "apikeys/")
+	logger.Infof("API keys etcd prefix %s", prefix)
+
+	stopCtx, cancel := context.WithCancel(context.Background())
+	c := &apiKeyCache{
+		cluster: cls,
+		prefix:  prefix,
+		stopCtx: stopCtx,
+		cancel:  cancel,
+	}
+
+	kvs, err := cls.GetPrefix(prefix)
+	if err != nil {
+		logger.Errorf("get API keys failed: %v", err)
+	}
+	c.reload(kvs)
+
+	return c
+}
+
+func (c *apiKeyCache) reload(kvs map[string]string) {
+	consumers := make(map[string]*apiKeyConsumer, len(kvs))
+	for key, value := range kvs {
+		consumer := &apiKeyConsumer{}
+		if err := yaml.Unmarshal([]byte(value), consumer); err != nil {
+			logger.Errorf("parse API key %s failed: %v", key, err)
+			continue
+		}
+		if consumer.name() == "" || len(consumer.KeyHash) != sha256.Size*2 {
+			logger.Errorf("invalid API key %s: both consumer (or key) and a SHA-256 keyHash are required", key)
+			continue
+		}
+		consumers[strings.ToLower(consumer.KeyHash)] = consumer
+	}
+
+	c.mutex.Lock()
+	c.consumers = consumers
+	c.mutex.Unlock()
+}
+
+func (c *apiKeyCache) watchChanges() {
+	watchCustomData(c.stopCtx, c.cluster, c.prefix, 30*time.Minute, func(kvs map[string]string) {
+		logger.Infof("API keys update")
+		c.reload(kvs)
+	})
+}
+
+func (c *apiKeyCache) lookup(key string) *apiKeyConsumer {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.consumers[hashAPIKey(key)]
+}
+
+func (c *apiKeyCache) close() {
+	c.cancel()
+}
+
+// NewAPIKeyValidator creates a new API key validator, all requests are
+// rejected if the API keys could not be read from etcd.
+func NewAPIKeyValidator(spec *APIKeyValidatorSpec, supervisor *supervisor.Supervisor) *APIKeyValidator {
+	v := &APIKeyValidator{spec: spec}
+	if supervisor == nil || supervisor.Cluster() == nil {
+		logger.Errorf("API key validator: failed to read data from etcd")
+		return v
+	}
+
+	v.cache = newAPIKeyCache(supervisor.Cluster(), spec.EtcdPrefix)
+	v.cache.watchChanges()
+	return v
+}
+
+func (v *APIKeyValidator) apiKey(req httpcontext.HTTPRequest) string {
+	headerName := v.spec.HeaderName
+	if headerName == "" {
+		headerName = defaultAPIKeyHeader
+	}
+	if key := req.Header().Get(headerName); key != "" {
+		return key
+	}
+
+	if v.spec.QueryName == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(req.Query())
+	if err != nil {
+		return ""
+	}
+	return query.Get(v.spec.QueryName)
+}
+
+// Validate validates the API key of a http request, the consumer of
+// the key is set to the X-AUTH-USER header.
+func (v *APIKeyValidator) Validate(req httpcontext.HTTPRequest) error {
+	hdr := req.Header()
+	v.spec.MetadataToHeaders.Strip(hdr)
+
+	if v.cache == nil {
+		return fmt.Errorf("no API keys available")
+	}
+
+	key := v.apiKey(req)
+	if key == "" {
+		return fmt.Errorf("no API key")
+	}
+
+	consumer := v.cache.lookup(key)
+	if consumer == nil {
+		return fmt.Errorf("invalid API key")
+	}
+	if !consumer.enabled() {
+		return fmt.Errorf("consumer %s is disabled", consumer.name())
+	}
+
+	identityOf(req).Consumer = consumer.name()
+	hdr.Set(consumerHeader, consumer.name())
+	if len(v.spec.MetadataToHeaders) > 0 {
+		metadata := make(map[string]interface{}, len(consumer.Metadata))
+		for k, val := range consumer.Metadata {
+			metadata[k] = val
+		}
+		v.spec.MetadataToHeaders.Forward(hdr, metadata)
+	}
+
+	return nil
+}
+
+// Close closes the API key validator.
+func (v *APIKeyValidator) Close() {
+	if v.cache != nil {
+		v.cache.close()
+	}
+}
@@ -100,10 +100,6 @@ type (
 	}
 )
 
-const (
-	customDataPrefix = "/custom-data/"
-)
-
 // Username uses username if present, otherwise key
 func (cred *etcdCredentials) Username() string {
 	if cred.User != "" {
@@ -197,12 +193,7 @@ func (huc *htpasswdUserCache) Match(username string, password string) bool {
 }
 
 func newEtcdUserCache(cluster cluster.Cluster, etcdPrefix string) *etcdUserCache {
-	prefix := customDataPrefix
-	if etcdPrefix == "" {
-		prefix += "credentials/"
-	} else {
-		prefix = customDataPrefix + strings.TrimPrefix(etcdPrefix, "/")
-	}
+	prefix := customDataKeyPrefix(etcdPrefix, "credentials/")
 	logger.Infof("credentials etcd prefix %s", prefix)
 	kvs, err := cluster.GetPrefix(prefix)
 	if err != nil {
@@ -259,45 +250,12 @@ func (euc *etcdUserCache) WatchChanges() {
 		logger.Errorf("missing etcd prefix, skip watching changes")
 		return
 	}
-	var (
-		syncer *cluster.Syncer
-		err    error
-		ch     <-chan map[string]string
-	)
-
-	for {
-		syncer, err = euc.cluster.Syncer(euc.syncInterval)
-		if err != nil {
-			logger.Errorf("failed to create syncer: %v", err)
-		} else if ch, err = syncer.SyncPrefix(euc.prefix); err != nil {
-			logger.Errorf("failed to sync prefix: %v", err)
-			syncer.Close()
-		} else {
-			break
-		}
-
-		select {
-		case <-time.After(10 * time.Second):
-		case <-euc.stopCtx.Done():
-			return
-		}
-	}
-	// start listening in background
-	go func() {
-		defer syncer.Close()
 
-		for {
-			select {
-			case <-euc.stopCtx.Done():
-				return
-			case kvs := <-ch:
-				logger.Infof("basic auth credentials update")
-				pwReader := kvsToReader(kvs)
-				euc.userFileObject.ReloadFromReader(pwReader, nil)
-			}
-		}
-	}()
-	return
+	watchCustomData(euc.stopCtx, euc.cluster, euc.prefix, euc.syncInterval, func(kvs map[string]string) {
+		logger.Infof("basic auth credentials update")
+		pwReader := kvsToReader(kvs)
+		euc.userFileObject.ReloadFromReader(pwReader, nil)
+	})
 }
 
 func (euc *etcdUserCache) Close() {
@@ -366,7 +324,8 @@ func (bav *BasicAuthValidator) Validate(req httpcontext.HTTPRequest) error {
 	}
 
 	if bav.authorizedUsersCache.Match(userID, password) {
-		req.Header().Set("X-AUTH-USER", userID)
+		identityOf(req).Consumer = userID
+		req.Header().Set(consumerHeader, userID)
 		return nil
 	}
 	return fmt.Errorf("unauthorized")
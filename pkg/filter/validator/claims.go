@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// ClaimsToHeaders maps claim names to header names, the value of a
+// claim is forwarded to the upstream in the header after the token
+// is verified.
+type ClaimsToHeaders map[string]string
+
+// Validate validates ClaimsToHeaders.
+func (cth ClaimsToHeaders) Validate() error {
+	for claim, header := range cth {
+		if claim == "" {
+			return fmt.Errorf("empty claim name")
+		}
+		if !httpguts.ValidHeaderFieldName(header) {
+			return fmt.Errorf("invalid header name %q of claim %s", header, claim)
+		}
+	}
+	return nil
+}
+
+// Strip removes the headers from the request, so that the spoofed
+// headers sent by clients never reach the upstream.
+func (cth ClaimsToHeaders) Strip(h *httpheader.HTTPHeader) {
+	for _, header := range cth {
+		h.Del(header)
+	}
+}
+
+// Forward sets the claims to the headers, claims not in the token
+// are skipped.
+func (cth ClaimsToHeaders) Forward(h *httpheader.HTTPHeader, claims map[string]interface{}) {
+	for claim, header := range cth {
+		v, ok := claims[claim]
+		if !ok || v == nil {
+			continue
+		}
+		if s := claimString(v); s != "" {
+			h.Set(header, s)
+		}
+	}
+}
+
+// identityOf returns the verified identity of the request, it is created
+// if the request doesn't have one.
+func identityOf(req context.HTTPRequest) *context.Identity {
+	identity := req.Identity()
+	if identity == nil {
+		identity = &context.Identity{}
+		req.SetIdentity(identity)
+	}
+	return identity
+}
+
+// claimString converts a claim value to a header value, the elements
+// of an array are joined by commas, objects are encoded as JSON.
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if s := claimString(e); s != "" {
+				values = append(values, s)
+			}
+		}
+		return strings.Join(values, ",")
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(buf)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	customDataPrefix = "/custom-data/"
+)
+
+// customDataKeyPrefix returns the etcd prefix of the custom data, it's
+// defaultPrefix under the custom data if etcdPrefix is empty.
+func customDataKeyPrefix(etcdPrefix, defaultPrefix string) string {
+	if etcdPrefix == "" {
+		return customDataPrefix + defaultPrefix
+	}
+	return customDataPrefix + strings.TrimPrefix(etcdPrefix, "/")
+}
+
+// watchCustomData watches the custom data under the prefix in background,
+// and calls onChange with all the key-values under the prefix once they
+// are changed, until stopCtx is done. It retries until the watching starts.
+func watchCustomData(stopCtx context.Context, cls cluster.Cluster, prefix string,
+	syncInterval time.Duration, onChange func(kvs map[string]string)) {
+	var (
+		syncer *cluster.Syncer
+		err    error
+		ch     <-chan map[string]string
+	)
+
+	for {
+		syncer, err = cls.Syncer(syncInterval)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.SyncPrefix(prefix); err != nil {
+			logger.Errorf("failed to sync prefix: %v", err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-stopCtx.Done():
+			return
+		}
+	}
+
+	// start listening in background
+	go func() {
+		defer syncer.Close()
+
+		for {
+			select {
+			case <-stopCtx.Done():
+				return
+			case kvs := <-ch:
+				onChange(kvs)
+			}
+		}
+	}()
+}
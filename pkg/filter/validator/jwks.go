@@ -0,0 +1,336 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	defaultJWKSTimeout         = 10 * time.Second
+
+	// minJWKSRefreshInterval is the min interval of refreshing triggered
+	// by tokens with unknown key IDs, to avoid flooding the JWKS endpoint.
+	minJWKSRefreshInterval = 10 * time.Second
+)
+
+type (
+	// JWKSSpec defines the JSON Web Key Set to fetch public keys from.
+	JWKSSpec struct {
+		URL             string `yaml:"url" jsonschema:"required,format=uri"`
+		RefreshInterval string `yaml:"refreshInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		Timeout         string `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	// jwks caches the public keys of a JSON Web Key Set by key ID,
+	// it refreshes the keys periodically, and when a token is signed
+	// by an unknown key, which happens after the keys are rotated.
+	jwks struct {
+		spec            *JWKSSpec
+		client          *http.Client
+		refreshInterval time.Duration
+
+		mutex sync.RWMutex
+		keys  map[string]interface{}
+
+		fetchMutex sync.Mutex
+		lastFetch  time.Time
+		// refreshing is closed once the running refreshing is done,
+		// it is nil if no refreshing is running.
+		refreshing chan struct{}
+
+		done chan struct{}
+	}
+
+	jsonWebKey struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+
+	jsonWebKeySet struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+)
+
+func newJWKS(spec *JWKSSpec) *jwks {
+	refreshInterval := defaultJWKSRefreshInterval
+	if d, err := time.ParseDuration(spec.RefreshInterval); err == nil && d > 0 {
+		refreshInterval = d
+	}
+	timeout := defaultJWKSTimeout
+	if d, err := time.ParseDuration(spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	j := &jwks{
+		spec:            spec,
+		client:          &http.Client{Timeout: timeout},
+		refreshInterval: refreshInterval,
+		keys:            map[string]interface{}{},
+		done:            make(chan struct{}),
+	}
+	go j.run()
+
+	return j
+}
+
+func (j *jwks) run() {
+	// NOTE: the keys may have been fetched by a request already.
+	j.wait(j.refresh(minJWKSRefreshInterval))
+
+	ticker := time.NewTicker(j.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.wait(j.refresh(0))
+		}
+	}
+}
+
+func (j *jwks) wait(refreshing <-chan struct{}) {
+	if refreshing == nil {
+		return
+	}
+	select {
+	case <-refreshing:
+	case <-j.done:
+	}
+}
+
+func (j *jwks) close() {
+	close(j.done)
+}
+
+// key returns the public key of the key ID, the only key is returned
+// if the key ID is empty. The keys are refreshed for an unknown key ID,
+// and ctx limits the time waiting for the refreshing.
+func (j *jwks) key(ctx context.Context, kid string) (interface{}, error) {
+	if key := j.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	if refreshing := j.refresh(minJWKSRefreshInterval); refreshing != nil {
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for JWKS failed: %v", ctx.Err())
+		}
+	}
+
+	if key := j.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key %q not found in JWKS", kid)
+}
+
+func (j *jwks) lookup(kid string) interface{} {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key
+		}
+	}
+	return j.keys[kid]
+}
+
+// refresh fetches the keys in background if they were fetched earlier
+// than minInterval ago. It returns a channel closed once the running
+// refreshing is done, or nil if there's no refreshing.
+func (j *jwks) refresh(minInterval time.Duration) <-chan struct{} {
+	j.fetchMutex.Lock()
+	defer j.fetchMutex.Unlock()
+
+	if j.refreshing != nil {
+		return j.refreshing
+	}
+	if time.Since(j.lastFetch) < minInterval {
+		return nil
+	}
+	j.lastFetch = time.Now()
+
+	refreshing := make(chan struct{})
+	j.refreshing = refreshing
+	go func() {
+		defer func() {
+			j.fetchMutex.Lock()
+			j.refreshing = nil
+			j.fetchMutex.Unlock()
+			close(refreshing)
+		}()
+
+		keys, err := j.fetch()
+		if err != nil {
+			logger.Errorf("fetch JWKS from %s failed: %v", j.spec.URL, err)
+			return
+		}
+
+		j.mutex.Lock()
+		j.keys = keys
+		j.mutex.Unlock()
+	}()
+
+	return refreshing
+}
+
+func (j *jwks) fetch() (map[string]interface{}, error) {
+	resp, err := j.client.Get(j.spec.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	set := &jsonWebKeySet{}
+	if err = json.NewDecoder(resp.Body).Decode(set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Warnf("ignore key %q of JWKS %s: %v", jwk.Kid, j.spec.URL, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// publicKey converts the JSON Web Key to the public key.
+// Reference: https://tools.ietf.org/html/rfc7518#section-6
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %v", err)
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %v", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %v", err)
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %v", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %v", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid key size: %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+}
+
+// parsePublicKey parses the PEM encoded public key or certificate,
+// RSA, ECDSA and Ed25519 keys are supported.
+func parsePublicKey(data string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
@@ -18,43 +18,116 @@
 package validator
 
 import (
+	stdcontext "context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"encoding/hex"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 
 	"github.com/megaease/easegress/pkg/context"
 )
 
+// for unit testing cases to mock 'time.Now' only
+var nowFunc = time.Now
+
 // JWTValidatorSpec defines the configuration of JWT validator
 type JWTValidatorSpec struct {
-	Algorithm string `yaml:"algorithm" jsonschema:"enum=HS256,enum=HS384,enum=HS512"`
-	// Secret is in hex encoding
-	Secret string `yaml:"secret" jsonschema:"required,pattern=^[A-Fa-f0-9]+$"`
+	Algorithm string `yaml:"algorithm,omitempty" jsonschema:"omitempty,enum=HS256,enum=HS384,enum=HS512,enum=RS256,enum=RS384,enum=RS512,enum=PS256,enum=PS384,enum=PS512,enum=ES256,enum=ES384,enum=ES512,enum=EdDSA"`
+	// Secret is in hex encoding, it's the key of HMAC algorithms.
+	Secret string `yaml:"secret,omitempty" jsonschema:"omitempty,pattern=^[A-Fa-f0-9]+$"`
+	// PublicKey is a PEM encoded public key or certificate,
+	// it's the key of RSA, ECDSA and EdDSA algorithms.
+	PublicKey string `yaml:"publicKey,omitempty" jsonschema:"omitempty"`
+	// JWKS is the JSON Web Key Set to fetch public keys from,
+	// the key is selected by the key ID of the token.
+	JWKS *JWKSSpec `yaml:"jwks,omitempty" jsonschema:"omitempty"`
 	// CookieName specifies the name of a cookie, if not empty, and the cookie with
 	// this name both exists and has a non-empty value, its value is used as token
 	// string, the Authorization header is used to get the token string otherwise.
 	CookieName string `yaml:"cookieName" jsonschema:"omitempty"`
+
+	// Issuer is the expected value of the iss claim.
+	Issuer string `yaml:"issuer,omitempty" jsonschema:"omitempty"`
+	// Audiences are the accepted values of the aud claim, the token
+	// is valid if any one of them is in the aud claim.
+	Audiences []string `yaml:"audiences,omitempty" jsonschema:"omitempty"`
+	// ClockSkew is the allowed clock skew when checking exp, nbf and iat.
+	ClockSkew string `yaml:"clockSkew,omitempty" jsonschema:"omitempty,format=duration"`
+	// ClaimsToHeaders forwards the claims of verified tokens to the upstream.
+	ClaimsToHeaders ClaimsToHeaders `yaml:"claimsToHeaders,omitempty" jsonschema:"omitempty"`
+}
+
+// Validate validates JWTValidatorSpec.
+func (spec JWTValidatorSpec) Validate() error {
+	keys := 0
+	if spec.Secret != "" {
+		keys++
+	}
+	if spec.PublicKey != "" {
+		keys++
+	}
+	if spec.JWKS != nil {
+		keys++
+	}
+	if keys != 1 {
+		return fmt.Errorf("one and only one of secret, publicKey and jwks must be specified")
+	}
+
+	isHMAC := strings.HasPrefix(spec.Algorithm, "HS")
+	if spec.Secret != "" && !isHMAC {
+		return fmt.Errorf("secret requires an HMAC algorithm, but got %q", spec.Algorithm)
+	}
+	if spec.Secret == "" && isHMAC {
+		return fmt.Errorf("algorithm %s requires secret", spec.Algorithm)
+	}
+
+	if spec.PublicKey != "" {
+		if _, err := parsePublicKey(spec.PublicKey); err != nil {
+			return fmt.Errorf("invalid public key: %v", err)
+		}
+	}
+
+	return nil
 }
 
 // NewJWTValidator creates a new JWT validator
 func NewJWTValidator(spec *JWTValidatorSpec) *JWTValidator {
-	secret, _ := hex.DecodeString(spec.Secret)
-	return &JWTValidator{
-		spec:        spec,
-		secretBytes: secret,
+	v := &JWTValidator{spec: spec}
+
+	switch {
+	case spec.Secret != "":
+		v.secretBytes, _ = hex.DecodeString(spec.Secret)
+	case spec.PublicKey != "":
+		v.publicKey, _ = parsePublicKey(spec.PublicKey)
+	case spec.JWKS != nil:
+		v.jwks = newJWKS(spec.JWKS)
+	}
+
+	if spec.ClockSkew != "" {
+		v.clockSkew, _ = time.ParseDuration(spec.ClockSkew)
 	}
+
+	return v
 }
 
 // JWTValidator defines the JWT validator
 type JWTValidator struct {
 	spec        *JWTValidatorSpec
 	secretBytes []byte
+	publicKey   interface{}
+	jwks        *jwks
+	clockSkew   time.Duration
 }
 
 // Validate validates the JWT token of a http request
 func (v *JWTValidator) Validate(req context.HTTPRequest) error {
+	v.spec.ClaimsToHeaders.Strip(req.Header())
+
 	var token string
 
 	if v.spec.CookieName != "" {
@@ -72,13 +145,130 @@ func (v *JWTValidator) Validate(req context.HTTPRequest) error {
 		token = authHdr[len(prefix):]
 	}
 
-	// jwt.Parse does everything including parsing and verification
-	_, e := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if alg := token.Method.Alg(); alg != v.spec.Algorithm {
-			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	claims, e := v.validateToken(req.Std().Context(), token)
+	if e != nil {
+		return e
+	}
+
+	identityOf(req).Claims = claims
+	v.spec.ClaimsToHeaders.Forward(req.Header(), claims)
+	return nil
+}
+
+// ValidateToken validates the token string, and returns its claims.
+func (v *JWTValidator) ValidateToken(token string) (jwt.MapClaims, error) {
+	return v.validateToken(stdcontext.Background(), token)
+}
+
+// validateToken validates the token string, ctx limits the time waiting
+// for the keys of JWKS.
+func (v *JWTValidator) validateToken(ctx stdcontext.Context, token string) (jwt.MapClaims, error) {
+	// NOTE: The claims are validated by validateClaims to allow clock skew.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return v.key(ctx, token)
+	}
+	if _, e := parser.ParseWithClaims(token, claims, keyFunc); e != nil {
+		return nil, e
+	}
+
+	if e := v.validateClaims(claims); e != nil {
+		return nil, e
+	}
+
+	return claims, nil
+}
+
+// key returns the key to verify the token. If the algorithm is not
+// specified, the algorithms allowed are derived from the key type, so
+// neither none nor an HMAC token passes a public key.
+func (v *JWTValidator) key(ctx stdcontext.Context, token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if v.spec.Algorithm != "" && alg != v.spec.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	}
+
+	var key interface{}
+	switch {
+	case v.secretBytes != nil:
+		key = v.secretBytes
+	case v.publicKey != nil:
+		key = v.publicKey
+	case v.jwks != nil:
+		kid, _ := token.Header["kid"].(string)
+		k, err := v.jwks.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	default:
+		return nil, fmt.Errorf("no key to verify the token")
+	}
+
+	if !keyAllowsAlgorithm(key, alg) {
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	}
+	return key, nil
+}
+
+// keyAllowsAlgorithm returns whether the key could be used by the algorithm.
+func keyAllowsAlgorithm(key interface{}, alg string) bool {
+	switch key := key.(type) {
+	case []byte:
+		return alg == "HS256" || alg == "HS384" || alg == "HS512"
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+			return true
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().Name {
+		case "P-256":
+			return alg == "ES256"
+		case "P-384":
+			return alg == "ES384"
+		case "P-521":
+			return alg == "ES512"
+		}
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func (v *JWTValidator) validateClaims(claims jwt.MapClaims) error {
+	now := nowFunc().Unix()
+	skew := int64(v.clockSkew / time.Second)
+
+	if !claims.VerifyExpiresAt(now-skew, false) {
+		return fmt.Errorf("token is expired")
+	}
+	if !claims.VerifyNotBefore(now+skew, false) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now+skew, false) {
+		return fmt.Errorf("token used before issued")
+	}
+
+	if v.spec.Issuer != "" && !claims.VerifyIssuer(v.spec.Issuer, true) {
+		return fmt.Errorf("invalid issuer: %v", claims["iss"])
+	}
+
+	if len(v.spec.Audiences) == 0 {
+		return nil
+	}
+	for _, aud := range v.spec.Audiences {
+		if claims.VerifyAudience(aud, true) {
+			return nil
 		}
-		return v.secretBytes, nil
-	})
+	}
+	return fmt.Errorf("invalid audience: %v", claims["aud"])
+}
 
-	return e
+// Close closes the JWT validator.
+func (v *JWTValidator) Close() {
+	if v.jwks != nil {
+		v.jwks.close()
+	}
 }
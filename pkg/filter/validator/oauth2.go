@@ -23,6 +23,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
@@ -53,6 +54,9 @@ type (
 	OAuth2ValidatorSpec struct {
 		TokenIntrospect *OAuth2TokenIntrospect `yaml:"tokenIntrospect" jsonschema:"omitempty"`
 		JWT             *OAuth2JWT             `yaml:"jwt" jsonschema:"omitempty"`
+		// ClaimsToHeaders forwards the claims of verified tokens, or the
+		// fields of token introspection responses, to the upstream.
+		ClaimsToHeaders ClaimsToHeaders `yaml:"claimsToHeaders,omitempty" jsonschema:"omitempty"`
 	}
 
 	// OAuth2Validator defines the OAuth2 validator
@@ -73,6 +77,8 @@ type (
 		Subject   string `json:"sub"`
 		Audience  string `json:"aud"`
 		Issuer    string `json:"iss"`
+
+		claims map[string]interface{}
 	}
 )
 
@@ -127,12 +133,18 @@ func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 		ErrorDesc string `json:"error_description"`
 	}
 
-	if e = json.NewDecoder(resp.Body).Decode(&ti); e != nil {
+	defer resp.Body.Close()
+	data, e := io.ReadAll(resp.Body)
+	if e != nil {
+		return nil, e
+	}
+	if e = json.Unmarshal(data, &ti); e != nil {
 		return nil, e
 	}
 	if ti.Error != "" {
 		return nil, fmt.Errorf("%s: %s", ti.Error, ti.ErrorDesc)
 	}
+	json.Unmarshal(data, &ti.claims)
 
 	return &ti.tokenInfo, nil
 }
@@ -142,6 +154,8 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 	const prefix = "Bearer "
 
 	hdr := req.Header()
+	v.spec.ClaimsToHeaders.Strip(hdr)
+
 	tokenStr := hdr.Get("Authorization")
 	if !strings.HasPrefix(tokenStr, prefix) {
 		return fmt.Errorf("unexpected authorization header: %s", tokenStr)
@@ -149,6 +163,7 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 	tokenStr = tokenStr[len(prefix):]
 
 	var subject, scope string
+	var claims map[string]interface{}
 	if v.spec.TokenIntrospect != nil {
 		ti, e := v.introspectToken(tokenStr)
 		if e != nil {
@@ -159,6 +174,7 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 		}
 		subject = ti.Subject
 		scope = ti.Scope
+		claims = ti.claims
 	} else {
 		token, e := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 			if alg := token.Method.Alg(); alg != v.spec.JWT.Algorithm {
@@ -170,7 +186,7 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 			return e
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
+		claims = token.Claims.(jwt.MapClaims)
 		subject, _ = claims["sub"].(string)
 		scope, _ = claims["scope"].(string)
 	}
@@ -183,5 +199,8 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 		hdr.Set("X-Authenticated-Scope", scope)
 	}
 
+	identityOf(req).Claims = claims
+	v.spec.ClaimsToHeaders.Forward(hdr, claims)
+
 	return nil
 }
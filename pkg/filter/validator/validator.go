@@ -52,6 +52,7 @@ type (
 		signer    *signer.Signer
 		oauth2    *OAuth2Validator
 		basicAuth *BasicAuthValidator
+		apiKey    *APIKeyValidator
 	}
 
 	// Spec describes the Validator.
@@ -61,6 +62,7 @@ type (
 		Signature *signer.Spec              `yaml:"signature,omitempty" jsonschema:"omitempty"`
 		OAuth2    *OAuth2ValidatorSpec      `yaml:"oauth2,omitempty" jsonschema:"omitempty"`
 		BasicAuth *BasicAuthValidatorSpec   `yaml:"basicAuth,omitempty" jsonschema:"omitempty"`
+		APIKey    *APIKeyValidatorSpec      `yaml:"apiKey,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -120,6 +122,9 @@ func (v *Validator) reload() {
 	if v.spec.BasicAuth != nil {
 		v.basicAuth = NewBasicAuthValidator(v.spec.BasicAuth, v.filterSpec.Super())
 	}
+	if v.spec.APIKey != nil {
+		v.apiKey = NewAPIKeyValidator(v.spec.APIKey, v.filterSpec.Super())
+	}
 }
 
 // Handle validates HTTPContext.
@@ -166,6 +171,13 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 			return resultInvalid
 		}
 	}
+	if v.apiKey != nil {
+		if err := v.apiKey.Validate(req); err != nil {
+			prepareErrorResponse(http.StatusUnauthorized, "API key validator: ", err)
+			return resultInvalid
+		}
+		ctx.AddTag(stringtool.Cat("consumer: ", req.Header().Get(consumerHeader)))
+	}
 
 	return ""
 }
@@ -175,7 +187,13 @@ func (v *Validator) Status() interface{} { return nil }
 
 // Close closes validations.
 func (v *Validator) Close() {
+	if v.jwt != nil {
+		v.jwt.Close()
+	}
 	if v.basicAuth != nil {
 		v.basicAuth.Close()
 	}
+	if v.apiKey != nil {
+		v.apiKey.Close()
+	}
 }
@@ -18,18 +18,33 @@
 package validator
 
 import (
+	stdcontext "context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt"
+
 	cluster "github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
@@ -169,6 +184,330 @@ jwt:
 	v.Description()
 }
 
+func newJWTContext(token string) *contexttest.MockedHTTPContext {
+	ctx := &contexttest.MockedHTTPContext{}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	var identity *context.Identity
+	ctx.MockedRequest.MockedIdentity = func() *context.Identity {
+		return identity
+	}
+	ctx.MockedRequest.MockedSetIdentity = func(id *context.Identity) {
+		identity = id
+	}
+	return ctx
+}
+
+func TestJWTPublicKey(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	spec := &Spec{JWT: &JWTValidatorSpec{
+		Algorithm: "ES256",
+		PublicKey: string(pemData),
+		Issuer:    "https://issuer.example.com",
+		Audiences: []string{"api", "web"},
+		ClockSkew: "30s",
+	}}
+	yamlSpec := "kind: Validator\nname: validator\n" + string(yamltool.Marshal(spec))
+	v := createValidator(yamlSpec, nil, nil)
+	defer v.Close()
+
+	now := time.Now().Unix()
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "https://issuer.example.com",
+			"aud": []string{"web"},
+			"exp": now + 60,
+		}
+	}
+
+	if v.Handle(newJWTContext(sign(jwt.SigningMethodES256, privateKey, validClaims()))) == resultInvalid {
+		t.Errorf("the jwt token should be valid")
+	}
+
+	claims := validClaims()
+	claims["exp"] = now - 10
+	if v.Handle(newJWTContext(sign(jwt.SigningMethodES256, privateKey, claims))) == resultInvalid {
+		t.Errorf("the jwt token expired within clock skew should be valid")
+	}
+
+	cases := []struct {
+		name  string
+		claim string
+		value interface{}
+	}{
+		{"expired", "exp", now - 60},
+		{"not valid yet", "nbf", now + 60},
+		{"invalid issuer", "iss", "https://other.example.com"},
+		{"invalid audience", "aud", "other"},
+	}
+	for _, c := range cases {
+		claims := validClaims()
+		claims[c.claim] = c.value
+		if v.Handle(newJWTContext(sign(jwt.SigningMethodES256, privateKey, claims))) != resultInvalid {
+			t.Errorf("the jwt token should be invalid: %s", c.name)
+		}
+	}
+
+	// HMAC token signed by the public key must be rejected.
+	hmacToken := sign(jwt.SigningMethodHS256, pemData, validClaims())
+	if v.Handle(newJWTContext(hmacToken)) != resultInvalid {
+		t.Errorf("the HMAC jwt token should be invalid")
+	}
+
+	invalidSpecs := []*JWTValidatorSpec{
+		{Algorithm: "HS256", PublicKey: string(pemData)},
+		{Algorithm: "RS256", Secret: "313233343536"},
+		{Algorithm: "RS256"},
+		{Algorithm: "RS256", PublicKey: "invalid"},
+	}
+	for i, spec := range invalidSpecs {
+		if spec.Validate() == nil {
+			t.Errorf("spec %d should be invalid", i)
+		}
+	}
+}
+
+func TestJWTJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	keys := []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": encode(rsaKey.N.Bytes()),
+			"e": encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "crv": "P-384",
+			"x": encode(ecKey.X.Bytes()),
+			"y": encode(ecKey.Y.Bytes()),
+		},
+	}
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	v := NewJWTValidator(&JWTValidatorSpec{JWKS: &JWKSSpec{URL: server.URL}})
+	defer v.Close()
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "user"})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodRS256, "rsa", rsaKey)).Request()); err != nil {
+		t.Errorf("the RS256 token should be valid: %v", err)
+	}
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodES384, "ec", ecKey)).Request()); err != nil {
+		t.Errorf("the ES384 token should be valid: %v", err)
+	}
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodES384, "rsa", ecKey)).Request()); err == nil {
+		t.Errorf("the token signed by another key should be invalid")
+	}
+
+	// the algorithms are derived from the key type if not specified.
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodHS256, "rsa", []byte("secret"))).Request()); err == nil {
+		t.Errorf("the HMAC token should be invalid")
+	}
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType)).Request()); err == nil {
+		t.Errorf("the unsigned token should be invalid")
+	}
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodPS256, "rsa", rsaKey)).Request()); err != nil {
+		t.Errorf("the PS256 token should be valid: %v", err)
+	}
+
+	// the keys are rotated, an unknown key ID triggers refreshing.
+	keys = append(keys, map[string]string{
+		"kty": "OKP", "kid": "ed", "crv": "Ed25519",
+		"x": encode(edPublicKey),
+	})
+	v.jwks.fetchMutex.Lock()
+	v.jwks.lastFetch = time.Time{}
+	v.jwks.fetchMutex.Unlock()
+	if err := v.Validate(newJWTContext(sign(jwt.SigningMethodEdDSA, "ed", edKey)).Request()); err != nil {
+		t.Errorf("the EdDSA token should be valid: %v", err)
+	}
+
+	// refreshing triggered by unknown key IDs is rate limited.
+	n := atomic.LoadInt32(&fetches)
+	v.Validate(newJWTContext(sign(jwt.SigningMethodEdDSA, "unknown", edKey)).Request())
+	if atomic.LoadInt32(&fetches) != n {
+		t.Errorf("JWKS should not be fetched again")
+	}
+}
+
+func TestJWTJWKSContext(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	v := NewJWTValidator(&JWTValidatorSpec{JWKS: &JWKSSpec{URL: server.URL}})
+	defer v.Close()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user"})
+	token.Header["kid"] = "rsa"
+	signed, err := token.SignedString(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the request should not wait for the JWKS longer than its context.
+	ctx := newJWTContext(signed)
+	stdctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx.MockedRequest.MockedStd = func() *http.Request {
+		return (&http.Request{}).WithContext(stdctx)
+	}
+
+	start := time.Now()
+	if err := v.Validate(ctx.Request()); err == nil {
+		t.Errorf("the token should be invalid")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("validation took too long: %v", d)
+	}
+}
+
+func TestClaimsToHeaders(t *testing.T) {
+	yamlSpec := `
+kind: Validator
+name: validator
+jwt:
+  algorithm: HS256
+  secret: 313233343536
+  claimsToHeaders:
+    sub: X-User-Id
+    roles: X-User-Roles
+    tenant: X-Tenant
+`
+	v := createValidator(yamlSpec, nil, nil)
+	defer v.Close()
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("123456"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	ctx := newJWTContext(sign(jwt.MapClaims{
+		"sub":   "alice",
+		"roles": []string{"admin", "dev"},
+	}))
+	header := ctx.Request().Header()
+	header.Set("X-User-Id", "mallory")
+	header.Set("X-Tenant", "spoofed")
+	if v.Handle(ctx) == resultInvalid {
+		t.Fatalf("the jwt token should be valid")
+	}
+	if got := header.Get("X-User-Id"); got != "alice" {
+		t.Errorf("X-User-Id should be alice, but got %q", got)
+	}
+	if got := header.Get("X-User-Roles"); got != "admin,dev" {
+		t.Errorf("X-User-Roles should be admin,dev, but got %q", got)
+	}
+	if got := header.Get("X-Tenant"); got != "" {
+		t.Errorf("spoofed X-Tenant should be stripped, but got %q", got)
+	}
+	if identity := ctx.Request().Identity(); identity == nil || identity.Claims["sub"] != "alice" {
+		t.Errorf("claims should be set to the identity, but got %+v", identity)
+	}
+
+	ctx = newJWTContext("invalid token")
+	header = ctx.Request().Header()
+	header.Set("X-User-Id", "mallory")
+	if v.Handle(ctx) != resultInvalid {
+		t.Fatalf("the jwt token should be invalid")
+	}
+	if got := header.Get("X-User-Id"); got != "" {
+		t.Errorf("spoofed X-User-Id should be stripped, but got %q", got)
+	}
+
+	yamlSpec = `
+kind: Validator
+name: validator
+oauth2:
+  tokenIntrospect:
+    endPoint: http://oauth2.megaease.com/
+  claimsToHeaders:
+    sub: X-User-Id
+    exp: X-Expires-At
+`
+	v = createValidator(yamlSpec, nil, nil)
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
+		body := `{"active": true, "sub": "bob", "exp": 1638316800}`
+		return &http.Response{Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	ctx = newJWTContext("opaque token")
+	header = ctx.Request().Header()
+	header.Set("X-User-Id", "mallory")
+	if v.Handle(ctx) == resultInvalid {
+		t.Fatalf("the oauth2 token should be valid")
+	}
+	if got := header.Get("X-User-Id"); got != "bob" {
+		t.Errorf("X-User-Id should be bob, but got %q", got)
+	}
+	if got := header.Get("X-Expires-At"); got != "1638316800" {
+		t.Errorf("X-Expires-At should be 1638316800, but got %q", got)
+	}
+	if identity := ctx.Request().Identity(); identity == nil || identity.Claims["sub"] != "bob" {
+		t.Errorf("claims should be set to the identity, but got %+v", identity)
+	}
+
+	if (ClaimsToHeaders{"sub": "X User"}).Validate() == nil {
+		t.Errorf("invalid header name should fail the validation")
+	}
+}
+
 func TestOAuth2JWT(t *testing.T) {
 	const yamlSpec = `
 kind: Validator
@@ -544,3 +883,133 @@ lastEntry: "byebye"
 		wg.Wait()
 	})
 }
+
+func TestAPIKey(t *testing.T) {
+	t.Run("no etcd", func(t *testing.T) {
+		yamlSpec := `
+kind: Validator
+name: validator
+apiKey:
+  etcdPrefix: apikeys/
+`
+		v := createValidator(yamlSpec, nil, nil)
+		ctx, header := prepareCtxAndHeader()
+		header.Set("X-API-Key", "key")
+		if v.Handle(ctx) != resultInvalid {
+			t.Errorf("should be invalid")
+		}
+		v.Close()
+	})
+
+	t.Run("keys from etcd", func(t *testing.T) {
+		etcdDirName, err := ioutil.TempDir("", "etcd-validator-apikey-test")
+		check(err)
+		defer os.RemoveAll(etcdDirName)
+		clusterInstance := cluster.CreateClusterForTest(etcdDirName)
+
+		clusterInstance.Put("/custom-data/apikeys/partner-a", fmt.Sprintf(`
+key: partner-a
+keyHash: %s
+metadata:
+  tier: gold
+`, hashAPIKey("key-a")))
+		clusterInstance.Put("/custom-data/apikeys/partner-b", fmt.Sprintf(`
+key: partner-b-key-1
+consumer: partner-b
+keyHash: %s
+enabled: false
+`, hashAPIKey("key-b")))
+		clusterInstance.Put("/custom-data/apikeys/invalid", "key: invalid\nkeyHash: abc")
+
+		supervisor := supervisor.NewMock(
+			nil, clusterInstance, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+
+		yamlSpec := `
+kind: Validator
+name: validator
+apiKey:
+  queryName: api_key
+  metadataToHeaders:
+    tier: X-Consumer-Tier
+`
+		v := createValidator(yamlSpec, nil, supervisor)
+
+		validate := func(header map[string]string, query string) (string, http.Header) {
+			ctx, h := prepareCtxAndHeader()
+			for k, val := range header {
+				h.Set(k, val)
+			}
+			ctx.MockedRequest.MockedQuery = func() string {
+				return query
+			}
+			var tags []string
+			ctx.MockedAddTag = func(tag string) {
+				tags = append(tags, tag)
+			}
+			result := v.Handle(ctx)
+			if result == "" && (len(tags) == 0 || tags[0] != "consumer: "+h.Get("X-AUTH-USER")) {
+				t.Errorf("consumer should be tagged for access log, but got %v", tags)
+			}
+			return result, h
+		}
+
+		result, header := validate(map[string]string{"X-API-Key": "key-a", "X-Consumer-Tier": "platinum"}, "")
+		if result == resultInvalid {
+			t.Fatalf("should be authorized")
+		}
+		if header.Get("X-AUTH-USER") != "partner-a" || header.Get("X-Consumer-Tier") != "gold" {
+			t.Errorf("unexpected header: %v", header)
+		}
+
+		result, header = validate(nil, "api_key=key-a")
+		if result == resultInvalid || header.Get("X-AUTH-USER") != "partner-a" {
+			t.Errorf("API key in query should be authorized")
+		}
+
+		if result, _ = validate(map[string]string{"X-API-Key": "key-b"}, ""); result != resultInvalid {
+			t.Errorf("disabled consumer should be unauthorized")
+		}
+		if result, _ = validate(map[string]string{"X-API-Key": "key-c"}, ""); result != resultInvalid {
+			t.Errorf("unknown API key should be unauthorized")
+		}
+		if result, _ = validate(nil, ""); result != resultInvalid {
+			t.Errorf("request without API key should be unauthorized")
+		}
+
+		// keys are updated by watching etcd.
+		clusterInstance.Put("/custom-data/apikeys/partner-b", fmt.Sprintf(`
+key: partner-b-key-1
+consumer: partner-b
+keyHash: %s
+`, hashAPIKey("key-b")))
+		clusterInstance.Delete("/custom-data/apikeys/partner-a")
+
+		tryCount := 5
+		for i := 0; i <= tryCount; i++ {
+			time.Sleep(200 * time.Millisecond)
+			resultA, _ := validate(map[string]string{"X-API-Key": "key-a"}, "")
+			resultB, header := validate(map[string]string{"X-API-Key": "key-b"}, "")
+			if resultA == resultInvalid && resultB != resultInvalid && header.Get("X-AUTH-USER") == "partner-b" {
+				break
+			}
+			if i == tryCount {
+				t.Errorf("API keys should be updated")
+			}
+		}
+
+		v.Close()
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		clusterInstance.CloseServer(wg)
+		wg.Wait()
+	})
+}
+
+func TestCustomDataKeyPrefix(t *testing.T) {
+	if p := customDataKeyPrefix("", "apikeys/"); p != "/custom-data/apikeys/" {
+		t.Errorf("unexpected prefix %s", p)
+	}
+	if p := customDataKeyPrefix("/tenants/keys/", "apikeys/"); p != "/custom-data/tenants/keys/" {
+		t.Errorf("unexpected prefix %s", p)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// targets of rules
+	targetURI     = "uri"
+	targetPath    = "path"
+	targetQuery   = "query"
+	targetHeaders = "headers"
+	targetCookies = "cookies"
+	targetBody    = "body"
+
+	severityCritical = "critical"
+	severityError    = "error"
+	severityWarning  = "warning"
+	severityNotice   = "notice"
+)
+
+var (
+	allTargets = []string{targetURI, targetPath, targetQuery, targetHeaders, targetCookies, targetBody}
+
+	// severityScores follows the anomaly scores of OWASP CRS.
+	severityScores = map[string]int{
+		severityCritical: 5,
+		severityError:    4,
+		severityWarning:  3,
+		severityNotice:   2,
+	}
+
+	injectionTargets = []string{targetPath, targetQuery, targetHeaders, targetCookies, targetBody}
+	// injectionHeaders are the headers inspected by injection rules,
+	// other headers like Accept contain characters which look like
+	// injections, such as "*/*".
+	injectionHeaders = []string{"User-Agent", "Referer"}
+)
+
+type (
+	// Rule is a custom rule of WAF, a request matches the rule if
+	// any one of its targets matches the pattern.
+	Rule struct {
+		ID      string `yaml:"id" jsonschema:"required"`
+		Message string `yaml:"message,omitempty" jsonschema:"omitempty"`
+		// Severity decides the anomaly score of the rule, default is critical.
+		Severity string   `yaml:"severity,omitempty" jsonschema:"omitempty,enum=critical,enum=error,enum=warning,enum=notice"`
+		Targets  []string `yaml:"targets" jsonschema:"required,uniqueItems=true"`
+		Pattern  string   `yaml:"pattern" jsonschema:"required,format=regexp"`
+	}
+
+	// rule is the compiled rule, a rule either has a pattern which is
+	// matched against its targets, or a check function.
+	rule struct {
+		id      string
+		message string
+		score   int
+		targets []string
+		// headers limits the headers inspected for the target headers,
+		// empty means all headers.
+		headers []string
+		re      *regexp.Regexp
+		check   func(r *inspection) (string, bool)
+	}
+)
+
+// Validate validates Rule.
+func (r Rule) Validate() error {
+	for _, t := range r.Targets {
+		if !stringtool.StrInSlice(t, allTargets) {
+			return fmt.Errorf("rule %s: invalid target %s, must be one of %s",
+				r.ID, t, strings.Join(allTargets, ", "))
+		}
+	}
+	return nil
+}
+
+func (r *Rule) compile() *rule {
+	severity := r.Severity
+	if severity == "" {
+		severity = severityCritical
+	}
+	message := r.Message
+	if message == "" {
+		message = "custom rule " + r.ID
+	}
+
+	return &rule{
+		id:      r.ID,
+		message: message,
+		score:   severityScores[severity],
+		targets: r.Targets,
+		re:      regexp.MustCompile(r.Pattern),
+	}
+}
+
+func newPatternRule(id, severity, message, pattern string, targets ...string) *rule {
+	return &rule{
+		id:      id,
+		message: message,
+		score:   severityScores[severity],
+		targets: targets,
+		re:      regexp.MustCompile(pattern),
+	}
+}
+
+func newInjectionRule(id, message, pattern string) *rule {
+	r := newPatternRule(id, severityCritical, message, pattern, injectionTargets...)
+	r.headers = injectionHeaders
+	return r
+}
+
+func newCheckRule(id, severity, message string, check func(r *inspection) (string, bool)) *rule {
+	return &rule{
+		id:      id,
+		message: message,
+		score:   severityScores[severity],
+		check:   check,
+	}
+}
+
+// builtinRules are the built-in rules, their IDs follow the ones of
+// OWASP CRS which detect the same kind of attacks.
+var builtinRules = []*rule{
+	// protocol anomalies
+	newCheckRule("920170", severityCritical, "GET or HEAD request with body content",
+		func(r *inspection) (string, bool) {
+			if r.method != "GET" && r.method != "HEAD" {
+				return "", false
+			}
+			return "body", r.contentLength > 0
+		}),
+	newCheckRule("920400", severityCritical, "Request body too large",
+		func(r *inspection) (string, bool) {
+			return "body", r.bodyTooLarge
+		}),
+	newCheckRule("920420", severityCritical, "Request content type is not allowed by policy",
+		func(r *inspection) (string, bool) {
+			return "header:Content-Type", r.contentTypeDenied
+		}),
+	newCheckRule("920270", severityCritical, "Invalid character in request (null character)",
+		func(r *inspection) (string, bool) {
+			return r.find(allTargets, nil, func(v string) bool {
+				return strings.IndexByte(v, 0) >= 0
+			})
+		}),
+	newCheckRule("920280", severityWarning, "Request missing a Host header",
+		func(r *inspection) (string, bool) {
+			return "host", r.host == ""
+		}),
+	newPatternRule("920230", severityWarning, "Multiple URL encoding detected",
+		`(?i)%25[0-9a-f]{2}`, targetURI),
+
+	// path traversal and local file inclusion
+	newPatternRule("930100", severityCritical, "Path traversal attack (/../)",
+		`(?i)(?:^|[/\\]|%2f|%5c)(?:\.|%2e){2}(?:[/\\]|%2f|%5c|$)`,
+		targetURI, targetQuery, targetBody),
+	newPatternRule("930120", severityCritical, "OS file access attempt",
+		`(?i)(?:/etc/(?:passwd|shadow|group|hosts)\b|/proc/self/|\b(?:boot|win|system)\.ini\b|\.ht(?:access|passwd)\b|\.git/|\.env\b)`,
+		targetPath, targetQuery, targetBody),
+
+	// cross-site scripting
+	newInjectionRule("941110", "XSS filter - script tag vector",
+		`(?i)<\s*/?\s*script\b`),
+	newInjectionRule("941120", "XSS filter - event handler vector",
+		`(?i)[\s"'\x60;/0-9=]on[a-z]{3,25}\s*=[^=]`),
+	newInjectionRule("941160", "XSS filter - HTML injection",
+		`(?i)<\s*(?:iframe|frame|object|embed|applet|svg|img|body|style|link|meta|base|form)\b[^>]*>`),
+	newInjectionRule("941170", "XSS filter - JavaScript URI",
+		`(?i)(?:javascript|vbscript|livescript)\s*:|data\s*:\s*text/html`),
+
+	// SQL injection
+	newInjectionRule("942130", "SQL injection - tautology",
+		`(?i)['"\d)]\s*(?:or|and|xor|\|\||&&)\s*['"(]?\s*(?:\d+|'[^']*'|"[^"]*"|\w+)\s*(?:=|<>|!=|<|>|\blike\b)\s*['"(]?\s*(?:\d+|'[^']*|"[^"]*|\w+)`),
+	newInjectionRule("942140", "SQL injection - common DB names",
+		`(?i)\b(?:information_schema|mysql\.user|sysobjects|syscolumns|pg_catalog|sqlite_master)\b`),
+	newInjectionRule("942160", "SQL injection - blind sleep or benchmark",
+		`(?i)\b(?:sleep|benchmark|pg_sleep)\s*\(|\bwaitfor\s+delay\b`),
+	newInjectionRule("942190", "SQL injection - UNION SELECT",
+		`(?i)\bunion\b(?:\s|/\*.*?\*/)+(?:all\s+|distinct\s+)?select\b`),
+	newInjectionRule("942350", "SQL injection - stacked query",
+		`(?i);\s*(?:drop|alter|create|truncate|insert|update|delete|exec)\s+\w`),
+	newInjectionRule("942440", "SQL injection - comment sequence",
+		`(?:/\*!?|\*/|['";]\s*--|--\s*$|'\s*#)`),
+}
+
+// match returns the target where the rule matches.
+func (r *rule) match(in *inspection) (string, bool) {
+	if r.check != nil {
+		return r.check(in)
+	}
+	return in.find(r.targets, r.headers, r.re.MatchString)
+}
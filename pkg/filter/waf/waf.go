@@ -0,0 +1,460 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of WAF.
+	Kind = "WAF"
+
+	resultBlocked = "blocked"
+
+	defaultAnomalyThreshold = 5
+	defaultMaxBodySize      = 1024 * 1024
+)
+
+var results = []string{resultBlocked}
+
+func init() {
+	httppipeline.Register(&WAF{})
+}
+
+type (
+	// WAF is filter WAF, it inspects requests against the built-in
+	// rules and custom rules, and blocks the requests whose anomaly
+	// score reaches the threshold.
+	WAF struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		rules []*rule
+
+		inspected uint64
+		detected  uint64
+		blocked   uint64
+	}
+
+	// Spec describes the WAF.
+	Spec struct {
+		// DetectionOnly only logs and tags the matched rules, requests
+		// are never blocked.
+		DetectionOnly bool `yaml:"detectionOnly" jsonschema:"omitempty"`
+		// AnomalyThreshold is the anomaly score to block a request,
+		// default is 5, which means one critical rule blocks a request.
+		AnomalyThreshold int `yaml:"anomalyThreshold" jsonschema:"omitempty,minimum=1"`
+		// MaxBodySize is the max size of the request body in bytes,
+		// default is 1MB.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+		// AllowedContentTypes are the allowed media types of the
+		// request body, empty means all types are allowed.
+		AllowedContentTypes []string `yaml:"allowedContentTypes,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		// DisabledRules are the IDs of the disabled built-in rules.
+		DisabledRules []string     `yaml:"disabledRules,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		Rules         []*Rule      `yaml:"rules,omitempty" jsonschema:"omitempty"`
+		Exclusions    []*Exclusion `yaml:"exclusions,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Exclusion excludes rules for the requests whose path matches.
+	Exclusion struct {
+		Path *urlrule.StringMatch `yaml:"path" jsonschema:"required"`
+		// Rules are the IDs of the excluded rules, empty means all rules.
+		Rules []string `yaml:"rules,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Status is the status of WAF.
+	Status struct {
+		Inspected uint64 `yaml:"inspected"`
+		Detected  uint64 `yaml:"detected"`
+		Blocked   uint64 `yaml:"blocked"`
+	}
+
+	// inspection holds the parts of a request to be inspected.
+	inspection struct {
+		method        string
+		host          string
+		contentLength int64
+
+		bodyTooLarge      bool
+		contentTypeDenied bool
+
+		// fields are the name/value pairs of every target.
+		fields map[string][]field
+	}
+
+	field struct {
+		name  string
+		value string
+	}
+
+	matchedRule struct {
+		rule   *rule
+		target string
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	ids := map[string]bool{}
+	for _, r := range builtinRules {
+		ids[r.id] = true
+	}
+
+	for _, id := range spec.DisabledRules {
+		if !ids[id] {
+			return fmt.Errorf("disabled rule %s is not a built-in rule", id)
+		}
+	}
+
+	for _, r := range spec.Rules {
+		if ids[r.ID] {
+			return fmt.Errorf("duplicated rule id %s", r.ID)
+		}
+		ids[r.ID] = true
+	}
+
+	return nil
+}
+
+// Kind returns the kind of WAF.
+func (w *WAF) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of WAF.
+func (w *WAF) DefaultSpec() interface{} {
+	return &Spec{
+		AnomalyThreshold: defaultAnomalyThreshold,
+		MaxBodySize:      defaultMaxBodySize,
+	}
+}
+
+// Description returns the description of WAF.
+func (w *WAF) Description() string {
+	return "WAF inspects requests against rule sets and blocks the malicious ones."
+}
+
+// Results returns the results of WAF.
+func (w *WAF) Results() []string {
+	return results
+}
+
+// Init initializes WAF.
+func (w *WAF) Init(filterSpec *httppipeline.FilterSpec) {
+	w.filterSpec, w.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	w.reload()
+}
+
+// Inherit inherits previous generation of WAF.
+func (w *WAF) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	w.Init(filterSpec)
+}
+
+func (w *WAF) reload() {
+	if w.spec.AnomalyThreshold <= 0 {
+		w.spec.AnomalyThreshold = defaultAnomalyThreshold
+	}
+	if w.spec.MaxBodySize <= 0 {
+		w.spec.MaxBodySize = defaultMaxBodySize
+	}
+
+	w.rules = nil
+	for _, r := range builtinRules {
+		if !stringtool.StrInSlice(r.id, w.spec.DisabledRules) {
+			w.rules = append(w.rules, r)
+		}
+	}
+	for _, r := range w.spec.Rules {
+		w.rules = append(w.rules, r.compile())
+	}
+
+	for _, e := range w.spec.Exclusions {
+		e.Path.Init()
+	}
+}
+
+// Handle inspects HTTPContext.
+func (w *WAF) Handle(ctx context.HTTPContext) string {
+	result := w.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (w *WAF) handle(ctx context.HTTPContext) string {
+	req := ctx.Request()
+
+	score, matches := w.evaluate(req)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = stringtool.Cat(m.rule.id, "(", m.target, ")")
+	}
+	summary := stringtool.Cat("score ", strconv.Itoa(score), ", rules ", strings.Join(ids, ","))
+
+	if score < w.spec.AnomalyThreshold {
+		ctx.AddTag(stringtool.Cat("waf: ", summary))
+		return ""
+	}
+
+	atomic.AddUint64(&w.detected, 1)
+	if w.spec.DetectionOnly {
+		logger.Infof("%s: detection only: %s %s is detected as malicious: %s, %s",
+			w.filterSpec.Name(), req.Method(), req.Path(), summary, matches[0].rule.message)
+		ctx.AddTag(stringtool.Cat("waf: detection only: ", summary))
+		return ""
+	}
+
+	atomic.AddUint64(&w.blocked, 1)
+	ctx.AddTag(stringtool.Cat("waf: blocked: ", summary))
+	ctx.Response().SetStatusCode(http.StatusForbidden)
+	return resultBlocked
+}
+
+// evaluate returns the anomaly score and the matched rules of the request.
+func (w *WAF) evaluate(req context.HTTPRequest) (int, []*matchedRule) {
+	excluded := map[string]bool{}
+	for _, e := range w.spec.Exclusions {
+		if !e.Path.Match(req.Path()) {
+			continue
+		}
+		if len(e.Rules) == 0 {
+			return 0, nil
+		}
+		for _, id := range e.Rules {
+			excluded[id] = true
+		}
+	}
+
+	atomic.AddUint64(&w.inspected, 1)
+
+	in := w.inspect(req)
+	score, matches := 0, []*matchedRule(nil)
+	for _, r := range w.rules {
+		if excluded[r.id] {
+			continue
+		}
+		if target, ok := r.match(in); ok {
+			score += r.score
+			matches = append(matches, &matchedRule{rule: r, target: target})
+		}
+	}
+
+	return score, matches
+}
+
+// inspect collects the parts of the request to be inspected, the body
+// is read and set back to the request.
+func (w *WAF) inspect(req context.HTTPRequest) *inspection {
+	stdr := req.Std()
+	in := &inspection{
+		method:        req.Method(),
+		host:          req.Host(),
+		contentLength: stdr.ContentLength,
+		fields:        map[string][]field{},
+	}
+
+	uri := req.EscapedPath()
+	if req.Query() != "" {
+		uri += "?" + req.Query()
+	}
+	in.add(targetURI, "uri", uri)
+	in.add(targetPath, "path", req.Path())
+
+	query, err := url.ParseQuery(req.Query())
+	in.addValues(targetQuery, query)
+	if err != nil {
+		// NOTE: The malformed parts are skipped by ParseQuery, such as the
+		// ones containing semicolons, so the whole query is inspected too.
+		raw, err := url.QueryUnescape(req.Query())
+		if err != nil {
+			raw = req.Query()
+		}
+		in.add(targetQuery, "query", raw)
+	}
+
+	req.Header().VisitAll(func(key, value string) {
+		in.add(targetHeaders, key, value)
+	})
+
+	for _, c := range req.Cookies() {
+		in.add(targetCookies, c.Name, c.Value)
+	}
+
+	if in.contentLength == 0 {
+		return in
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header().Get("Content-Type"))
+	mediaType = strings.ToLower(mediaType)
+	if len(w.spec.AllowedContentTypes) > 0 && !containsFold(w.spec.AllowedContentTypes, mediaType) {
+		in.contentTypeDenied = true
+	}
+
+	if in.contentLength > w.spec.MaxBodySize {
+		in.bodyTooLarge = true
+		return in
+	}
+	if !inspectableBody(mediaType) {
+		return in
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body(), w.spec.MaxBodySize+1))
+	if int64(len(body)) > w.spec.MaxBodySize {
+		in.bodyTooLarge = true
+		req.SetBody(io.MultiReader(bytes.NewReader(body), req.Body()))
+		return in
+	}
+	req.SetBody(bytes.NewReader(body))
+	if err != nil {
+		logger.Warnf("%s: read body failed: %v", w.filterSpec.Name(), err)
+		return in
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		form, _ := url.ParseQuery(string(body))
+		in.addValues(targetBody, form)
+	case "multipart/form-data":
+		in.addMultipart(body, params["boundary"])
+	default:
+		in.add(targetBody, "body", string(body))
+	}
+
+	return in
+}
+
+// addMultipart adds the names and values of the form fields, and the
+// names of the files, the contents of files are not inspected.
+func (in *inspection) addMultipart(body []byte, boundary string) {
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			// NOTE: The malformed body is inspected as a whole.
+			if err != io.EOF {
+				in.add(targetBody, "body", string(body))
+			}
+			return
+		}
+
+		name := part.FormName()
+		in.add(targetBody, name, name)
+		// NOTE: FileName returns the base name only, but the whole
+		// file name is inspected.
+		_, dispositionParams, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if filename, ok := dispositionParams["filename"]; ok {
+			in.add(targetBody, name, filename)
+			continue
+		}
+
+		value, err := io.ReadAll(part)
+		if err != nil {
+			in.add(targetBody, "body", string(body))
+			return
+		}
+		in.add(targetBody, name, string(value))
+	}
+}
+
+func (in *inspection) add(target, name, value string) {
+	in.fields[target] = append(in.fields[target], field{name: name, value: value})
+}
+
+// addValues adds both the names and the values.
+func (in *inspection) addValues(target string, values url.Values) {
+	for name, vs := range values {
+		in.add(target, name, name)
+		for _, v := range vs {
+			in.add(target, name, v)
+		}
+	}
+}
+
+// find returns the first target field where fn returns true, headers
+// limits the inspected headers, empty means all headers.
+func (in *inspection) find(targets, headers []string, fn func(string) bool) (string, bool) {
+	for _, t := range targets {
+		for _, f := range in.fields[t] {
+			if t == targetHeaders && len(headers) > 0 && !containsFold(headers, f.name) {
+				continue
+			}
+			if fn(f.value) {
+				return stringtool.Cat(t, ":", f.name), true
+			}
+		}
+	}
+	return "", false
+}
+
+// inspectableBody returns whether the body of the media type is text
+// or form, binary bodies like files are not inspected.
+func inspectableBody(mediaType string) bool {
+	switch {
+	case mediaType == "",
+		mediaType == "application/x-www-form-urlencoded",
+		mediaType == "multipart/form-data",
+		mediaType == "application/json",
+		mediaType == "application/xml",
+		strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return false
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (w *WAF) Status() interface{} {
+	return &Status{
+		Inspected: atomic.LoadUint64(&w.inspected),
+		Detected:  atomic.LoadUint64(&w.detected),
+		Blocked:   atomic.LoadUint64(&w.blocked),
+	}
+}
+
+// Close closes WAF.
+func (w *WAF) Close() {}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newWAF(t *testing.T, yamlSpec string) *WAF {
+	rawSpec := map[string]interface{}{}
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &WAF{}
+	w.Init(spec)
+	return w
+}
+
+func newContext(method, target, contentType, body string) context.HTTPContext {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, _ := http.NewRequest(method, "http://example.com"+target, reader)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace")
+}
+
+func matchedIDs(w *WAF, ctx context.HTTPContext) []string {
+	_, matches := w.evaluate(ctx.Request())
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.rule.id
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestBuiltinRules(t *testing.T) {
+	w := newWAF(t, `
+kind: WAF
+name: waf
+maxBodySize: 64
+allowedContentTypes: ["application/json", "application/x-www-form-urlencoded"]
+`)
+
+	cases := []struct {
+		method      string
+		target      string
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"GET", "/users?id=1&name=alice", "", "", ""},
+		{"GET", "/search?q=union+of+workers+and+select+committee", "", "", ""},
+		{"GET", "/search?q=turn+on+the+light", "", "", ""},
+		{"POST", "/users", "application/json", `{"name": "bob", "note": "it's 5 o'clock"}`, ""},
+
+		{"GET", "/users?id=1'+or+'1'='1", "", "", "942130"},
+		{"GET", "/users?id=1+UNION+ALL+SELECT+password+FROM+users", "", "", "942190"},
+		{"GET", "/users?id=1;+DROP+TABLE+users", "", "", "942350"},
+		{"GET", "/users?id=1+and+sleep(5)", "", "", "942160"},
+		{"GET", "/users?id=select+*+from+information_schema.tables", "", "", "942140"},
+		{"GET", "/login?user=admin'--", "", "", "942440"},
+		{"POST", "/login", "application/x-www-form-urlencoded", "user=admin'+or+1=1--&password=x", "942130,942440"},
+
+		{"GET", "/search?q=<script>alert(1)</script>", "", "", "941110"},
+		{"GET", "/items/%3Cscript%3Ealert(1)%3C%2Fscript%3E", "", "", "941110"},
+		{"GET", "/search?q=<img+src=x+onerror=alert(1)>", "", "", "941120,941160"},
+		{"GET", "/search?q=javascript:alert(1)", "", "", "941170"},
+		{"POST", "/comments", "application/json", `{"text": "<svg/onload=alert(1)>"}`, "941120,941160"},
+
+		{"GET", "/files?name=../../etc/passwd", "", "", "930100,930120"},
+		{"GET", "/static/%2e%2e/%2e%2e/secret", "", "", "930100"},
+		{"GET", "/.git/config", "", "", "930120"},
+
+		{"GET", "/search?q=%2527", "", "", "920230"},
+		{"GET", "/search?q=a%00b", "", "", "920270"},
+		{"GET", "/users", "application/json", `{}`, "920170"},
+		{"POST", "/upload", "application/octet-stream", "data", "920420"},
+		{"POST", "/users", "application/json", strings.Repeat("a", 65), "920400"},
+	}
+
+	for _, c := range cases {
+		ctx := newContext(c.method, c.target, c.contentType, c.body)
+		ids := strings.Join(matchedIDs(w, ctx), ",")
+		if ids != c.expected {
+			t.Errorf("%s %s %s: expected rules %q, but got %q", c.method, c.target, c.body, c.expected, ids)
+		}
+
+		body, _ := io.ReadAll(ctx.Request().Body())
+		if string(body) != c.body {
+			t.Errorf("%s %s: body should be kept, but got %q", c.method, c.target, body)
+		}
+		ctx.Finish()
+	}
+}
+
+func TestInjectionHeaders(t *testing.T) {
+	w := newWAF(t, `
+kind: WAF
+name: waf
+`)
+
+	cases := []struct {
+		header   map[string]string
+		expected string
+	}{
+		{map[string]string{
+			"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.110 Safari/537.36",
+			"Referer":    "http://example.com/search?q=shoes&page=2",
+			"Accept":     "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		}, ""},
+		{map[string]string{"User-Agent": "' or '1'='1"}, "942130"},
+		{map[string]string{"Referer": "http://example.com/?q=<script>alert(1)</script>"}, "941110"},
+		// only User-Agent and Referer are inspected by injection rules.
+		{map[string]string{"X-Note": "' or '1'='1"}, ""},
+	}
+
+	for _, c := range cases {
+		ctx := newContext(http.MethodGet, "/", "", "")
+		for k, v := range c.header {
+			ctx.Request().Header().Set(k, v)
+		}
+		if ids := strings.Join(matchedIDs(w, ctx), ","); ids != c.expected {
+			t.Errorf("%v: expected rules %q, but got %q", c.header, c.expected, ids)
+		}
+		ctx.Finish()
+	}
+}
+
+func TestMultipartBody(t *testing.T) {
+	w := newWAF(t, `
+kind: WAF
+name: waf
+`)
+
+	const contentType = "multipart/form-data; boundary=xyz"
+	cases := []struct {
+		body     string
+		expected string
+	}{
+		{"--xyz\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nalice\r\n" +
+			"--xyz\r\nContent-Disposition: form-data; name=\"avatar\"; filename=\"a.png\"\r\n\r\n<script>\r\n--xyz--\r\n", ""},
+		{"--xyz\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nalice' or '1'='1\r\n--xyz--\r\n", "942130"},
+		{"--xyz\r\nContent-Disposition: form-data; name=\"file\"; filename=\"../../etc/passwd\"\r\n\r\ndata\r\n--xyz--\r\n", "930100,930120"},
+		// malformed body is inspected as a whole.
+		{"--xyz\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\n<script>alert(1)</script>", "941110"},
+	}
+
+	for i, c := range cases {
+		ctx := newContext(http.MethodPost, "/upload", contentType, c.body)
+		if ids := strings.Join(matchedIDs(w, ctx), ","); ids != c.expected {
+			t.Errorf("case %d: expected rules %q, but got %q", i, c.expected, ids)
+		}
+
+		body, _ := io.ReadAll(ctx.Request().Body())
+		if string(body) != c.body {
+			t.Errorf("case %d: body should be kept, but got %q", i, body)
+		}
+		ctx.Finish()
+	}
+}
+
+func TestWAF(t *testing.T) {
+	const yamlSpec = `
+kind: WAF
+name: waf
+anomalyThreshold: 8
+disabledRules: ["920230"]
+rules:
+- id: "100001"
+  message: scanner detected
+  severity: warning
+  targets: ["headers"]
+  pattern: (?i)sqlmap|nikto
+exclusions:
+- path:
+    prefix: /cms/
+  rules: ["941110", "941160"]
+- path:
+    exact: /healthz
+`
+	w := newWAF(t, yamlSpec)
+
+	handle := func(target string, header map[string]string) string {
+		ctx := newContext(http.MethodGet, target, "", "")
+		for k, v := range header {
+			ctx.Request().Header().Set(k, v)
+		}
+		ctx.SetHandlerCaller(func(lastResult string) string {
+			return lastResult
+		})
+		result := w.Handle(ctx)
+		ctx.Finish()
+		return result
+	}
+
+	// one critical rule doesn't reach the threshold.
+	if result := handle("/search?q=javascript:alert(1)", nil); result != "" {
+		t.Errorf("request should not be blocked")
+	}
+	// critical + warning reaches the threshold.
+	if result := handle("/search?q=javascript:alert(1)", map[string]string{"User-Agent": "sqlmap/1.5"}); result != resultBlocked {
+		t.Errorf("request should be blocked")
+	}
+	// two critical rules reach the threshold.
+	if result := handle("/search?q=<script+src=//evil.com/x.js></script><iframe+src=x>", nil); result != resultBlocked {
+		t.Errorf("request should be blocked")
+	}
+	// the rules are excluded for the path.
+	if result := handle("/cms/posts?content=<script+src=//evil.com/x.js></script><iframe+src=x>", nil); result != "" {
+		t.Errorf("request should not be blocked")
+	}
+	if result := handle("/healthz?q=1'+or+'1'='1&p=../../etc/passwd", nil); result != "" {
+		t.Errorf("all rules should be excluded")
+	}
+	// disabled rule.
+	if ids := matchedIDs(w, newContext(http.MethodGet, "/search?q=%2527", "", "")); len(ids) != 0 {
+		t.Errorf("rule 920230 should be disabled, but got %v", ids)
+	}
+
+	status := w.Status().(*Status)
+	if status.Inspected != 5 || status.Detected != 2 || status.Blocked != 2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	w.spec.DetectionOnly = true
+	if result := handle("/search?q=<script+src=//evil.com/x.js></script><iframe+src=x>", nil); result != "" {
+		t.Errorf("request should not be blocked in detection only mode")
+	}
+	if status = w.Status().(*Status); status.Detected != 3 || status.Blocked != 2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	invalidSpecs := []string{`
+kind: WAF
+name: waf
+disabledRules: ["123"]
+`, `
+kind: WAF
+name: waf
+rules:
+- id: "942130"
+  targets: ["query"]
+  pattern: abc
+`, `
+kind: WAF
+name: waf
+rules:
+- id: "100001"
+  targets: ["method"]
+  pattern: abc
+`, `
+kind: WAF
+name: waf
+exclusions:
+- rules: ["942130"]
+`}
+
+	for i, yamlSpec := range invalidSpecs {
+		rawSpec := map[string]interface{}{}
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec %d should be invalid", i)
+		}
+	}
+}
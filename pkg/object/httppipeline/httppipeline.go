@@ -439,9 +439,25 @@ func (hp *HTTPPipeline) getNextFilterIndex(index int, result string) (int, bool)
 
 // Handle is the handler to deal with HTTP
 func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) string {
+	return hp.handleAfter(ctx, -1)
+}
+
+// HandleAfter handles the context by the filters after the filter of
+// name, so that a filter could run the rest of the pipeline for a context
+// created by itself, e.g. to revalidate a cached response in background.
+func (hp *HTTPPipeline) HandleAfter(ctx context.HTTPContext, name string) (string, error) {
+	for i, filter := range hp.runningFilters {
+		if filter.spec.Name() == name {
+			return hp.handleAfter(ctx, i), nil
+		}
+	}
+	return "", fmt.Errorf("filter %s not found in pipeline %s", name, hp.superSpec.Name())
+}
+
+func (hp *HTTPPipeline) handleAfter(ctx context.HTTPContext, start int) string {
 	ctx.SetTemplate(hp.ht)
 
-	filterIndex := -1
+	filterIndex := start
 	filterStat := newFilterStat()
 	isEnd := false
 
@@ -449,7 +465,7 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) string {
 		// For saving the `filterIndex`'s filter generated HTTP Response.
 		// Note: the sequence of pipeline is stack-liked, we save the filter's response into template
 		// at the beginning of the next filter.
-		if filterIndex != -1 {
+		if filterIndex != start {
 			name := hp.runningFilters[filterIndex].spec.Name()
 			if err := ctx.SaveRspToTemplate(name); err != nil {
 				format := "save http rsp failed, dict is %#v err is %v"
@@ -292,6 +292,12 @@ filters:
 
 	ctx := &contexttest.MockedHTTPContext{}
 	httpPipeline.Handle(ctx)
+	if _, err := httpPipeline.HandleAfter(ctx, "requestAdaptor"); err != nil {
+		t.Errorf("HandleAfter should succeed: %v", err)
+	}
+	if _, err := httpPipeline.HandleAfter(ctx, "unknown"); err == nil {
+		t.Errorf("HandleAfter should fail with unknown filter")
+	}
 	status := httpPipeline.Status()
 	if reflect.TypeOf(status).Kind() == reflect.Struct {
 		t.Errorf("should be type of Status")
@@ -343,6 +349,12 @@ filters:
 
 	ctx := &contexttest.MockedHTTPContext{}
 	httpPipeline.Handle(ctx)
+	if _, err := httpPipeline.HandleAfter(ctx, "requestAdaptor"); err != nil {
+		t.Errorf("HandleAfter should succeed: %v", err)
+	}
+	if _, err := httpPipeline.HandleAfter(ctx, "unknown"); err == nil {
+		t.Errorf("HandleAfter should fail with unknown filter")
+	}
 	status := httpPipeline.Status()
 	if reflect.TypeOf(status).Kind() == reflect.Struct {
 		t.Errorf("should be type of Status")
@@ -36,6 +36,10 @@ type (
 		notFound         bool
 		methodNotAllowed bool
 		path             *muxPath
+		// params and pathSuffix are captured by the path with parameters,
+		// pathSuffix is the part of the request path after the pattern.
+		params     map[string]string
+		pathSuffix string
 	}
 )
 
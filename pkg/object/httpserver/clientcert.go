@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	// clientCertRequire rejects requests without a verified client certificate.
+	clientCertRequire = "require"
+	// clientCertOptional accepts requests with or without a client certificate.
+	clientCertOptional = "optional"
+	// clientCertIgnore accepts all requests and doesn't expose the client certificate.
+	clientCertIgnore = "ignore"
+
+	headerClientCertSubject     = "X-Client-Cert-Subject"
+	headerClientCertIssuer      = "X-Client-Cert-Issuer"
+	headerClientCertSAN         = "X-Client-Cert-San"
+	headerClientCertSerial      = "X-Client-Cert-Serial"
+	headerClientCertFingerprint = "X-Client-Cert-Fingerprint"
+)
+
+var clientCertHeaders = []string{
+	headerClientCertSubject,
+	headerClientCertIssuer,
+	headerClientCertSAN,
+	headerClientCertSerial,
+	headerClientCertFingerprint,
+}
+
+// verifyClientCert returns the leaf certificate of the client certificate
+// chain, which is verified by the handshake, or is verified against roots
+// here if the handshake only requested it. It returns nil if the client
+// didn't give a certificate.
+func verifyClientCert(r *http.Request, roots *x509.CertPool) (*x509.Certificate, error) {
+	if r.TLS == nil {
+		return nil, nil
+	}
+	if len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return r.TLS.VerifiedChains[0][0], nil
+	}
+
+	certs := r.TLS.PeerCertificates
+	if len(certs) == 0 {
+		return nil, nil
+	}
+	if roots == nil {
+		return nil, fmt.Errorf("no root certificates to verify the client certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+func certSANs(cert *x509.Certificate) string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return strings.Join(sans, ",")
+}
+
+// handleClientCert enforces the client certificate mode and exposes the
+// fields of the verified client certificate as request headers, so that
+// filters and templates could use them. The client certificate is verified
+// against roots unless the mode is ignore. It returns false if the request
+// has been rejected.
+func (m *mux) handleClientCert(ctx context.HTTPContext, mode string, roots *x509.CertPool) bool {
+	h := ctx.Request().Header()
+
+	// NOTE: The headers must not be trusted if they come from the client.
+	for _, key := range clientCertHeaders {
+		h.Del(key)
+	}
+
+	if mode == clientCertIgnore {
+		return true
+	}
+
+	cert, err := verifyClientCert(ctx.Request().Std(), roots)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("invalid client certificate: %v", err))
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return false
+	}
+	if cert == nil {
+		if mode == clientCertRequire {
+			ctx.AddTag("client certificate required")
+			ctx.Response().SetStatusCode(http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	h.Set(headerClientCertSubject, cert.Subject.String())
+	h.Set(headerClientCertIssuer, cert.Issuer.String())
+	h.Set(headerClientCertSerial, cert.SerialNumber.Text(16))
+	h.Set(headerClientCertFingerprint, hex.EncodeToString(fingerprint[:]))
+	if sans := certSANs(cert); sans != "" {
+		h.Set(headerClientCertSAN, sans)
+	}
+
+	return true
+}
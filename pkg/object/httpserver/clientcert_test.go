@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func TestClientCertMode(t *testing.T) {
+	spec := &Spec{}
+	rule := &Rule{ClientCertMode: clientCertOptional}
+	if spec.clientCertMode(rule) != clientCertIgnore {
+		t.Errorf("mode should be ignore without caCertBase64")
+	}
+
+	spec.CaCertBase64 = "ZmFrZQ=="
+	if spec.clientCertMode(&Rule{}) != clientCertRequire {
+		t.Errorf("mode should be require by default")
+	}
+	spec.Rules = []*Rule{{}}
+	if !spec.requireClientCertForAll() {
+		t.Errorf("all rules should require client certificates")
+	}
+
+	spec.Rules = append(spec.Rules, rule)
+	if spec.clientCertMode(rule) != clientCertOptional {
+		t.Errorf("mode of rule should override the default one")
+	}
+	if spec.requireClientCertForAll() {
+		t.Errorf("not all rules require client certificates")
+	}
+
+	spec = &Spec{Rules: []*Rule{rule}}
+	if spec.Validate() == nil {
+		t.Errorf("clientCertMode without caCertBase64 should fail")
+	}
+}
+
+func TestHandleClientCert(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.org/client")
+	cert := &x509.Certificate{
+		Raw:            []byte("raw"),
+		SerialNumber:   big.NewInt(255),
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"megaease"}},
+		Issuer:         pkix.Name{CommonName: "ca"},
+		DNSNames:       []string{"client.example.org"},
+		EmailAddresses: []string{"client@example.org"},
+		URIs:           []*url.URL{u},
+	}
+
+	newContext := func(withCert bool) context.HTTPContext {
+		req, _ := http.NewRequest(http.MethodGet, "https://example.org/", nil)
+		req.Header.Set(headerClientCertSubject, "CN=spoofed")
+		req.TLS = &tls.ConnectionState{}
+		if withCert {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace")
+	}
+
+	m := &mux{}
+
+	ctx := newContext(false)
+	if m.handleClientCert(ctx, clientCertRequire, nil) {
+		t.Errorf("request without client certificate should be rejected")
+	}
+	if ctx.Response().StatusCode() != http.StatusForbidden {
+		t.Errorf("status code should be 403, but got %d", ctx.Response().StatusCode())
+	}
+
+	ctx = newContext(false)
+	if !m.handleClientCert(ctx, clientCertOptional, nil) {
+		t.Errorf("request without client certificate should be accepted")
+	}
+	if ctx.Request().Header().Get(headerClientCertSubject) != "" {
+		t.Errorf("spoofed header should be removed")
+	}
+
+	ctx = newContext(true)
+	if !m.handleClientCert(ctx, clientCertIgnore, nil) {
+		t.Errorf("request should be accepted")
+	}
+	if ctx.Request().Header().Get(headerClientCertSubject) != "" {
+		t.Errorf("client certificate should be ignored")
+	}
+
+	ctx = newContext(true)
+	if !m.handleClientCert(ctx, clientCertRequire, nil) {
+		t.Errorf("request with client certificate should be accepted")
+	}
+	h := ctx.Request().Header()
+	expected := map[string]string{
+		headerClientCertSubject:     "CN=client,O=megaease",
+		headerClientCertIssuer:      "CN=ca",
+		headerClientCertSerial:      "ff",
+		headerClientCertSAN:         "client.example.org,client@example.org,spiffe://example.org/client",
+		headerClientCertFingerprint: "d7439bee24773bcbfa2d0a97947ee36227b10d1022b1a55847e928965bb6bfde",
+	}
+	for k, v := range expected {
+		if h.Get(k) != v {
+			t.Errorf("header %s should be %q, but got %q", k, v, h.Get(k))
+		}
+	}
+}
+
+func newTestCert(t *testing.T, name string, isCA bool, usage x509.ExtKeyUsage, parent *tls.Certificate) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		DNSNames:              []string{name},
+	}
+
+	parentCert, parentKey := template, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertHandshake(t *testing.T) {
+	ca := newTestCert(t, "ca", true, x509.ExtKeyUsageAny, nil)
+	serverCert := newTestCert(t, "server", false, x509.ExtKeyUsageServerAuth, ca)
+	trusted := newTestCert(t, "trusted", false, x509.ExtKeyUsageClientAuth, ca)
+	untrusted := newTestCert(t, "untrusted", false, x509.ExtKeyUsageClientAuth, nil)
+
+	encode := func(typ string, der []byte) string {
+		return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	spec := &Spec{
+		HTTPS:        true,
+		CertBase64:   encode("CERTIFICATE", serverCert.Certificate[0]),
+		KeyBase64:    encode("PRIVATE KEY", keyDER),
+		CaCertBase64: encode("CERTIFICATE", ca.Certificate[0]),
+		Rules: []*Rule{
+			{ClientCertMode: clientCertIgnore},
+			{},
+		},
+	}
+	tlsConf, err := spec.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mux{}
+	roots := spec.clientCAs()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := spec.clientCertMode(spec.Rules[1])
+		if r.URL.Path == "/ignore" {
+			mode = spec.clientCertMode(spec.Rules[0])
+		}
+		ctx := context.New(w, r, tracing.NoopTracing, "no trace")
+		m.handleClientCert(ctx, mode, roots)
+		ctx.Finish()
+	}))
+	server.TLS = tlsConf
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(cert *tls.Certificate, path string) int {
+		certPool := x509.NewCertPool()
+		certPool.AddCert(ca.Leaf)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      certPool,
+				ServerName:   "server",
+				Certificates: []tls.Certificate{*cert},
+			},
+		}}
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(untrusted, "/ignore"); code != http.StatusOK {
+		t.Errorf("untrusted certificate should be ignored, but got %d", code)
+	}
+	if code := get(untrusted, "/require"); code != http.StatusForbidden {
+		t.Errorf("untrusted certificate should be rejected, but got %d", code)
+	}
+	if code := get(trusted, "/require"); code != http.StatusOK {
+		t.Errorf("trusted certificate should be accepted, but got %d", code)
+	}
+}
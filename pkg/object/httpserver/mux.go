@@ -18,6 +18,8 @@
 package httpserver
 
 import (
+	"crypto/x509"
+	"math/rand"
 	"net"
 	"net/http"
 	"reflect"
@@ -27,6 +29,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/object/globalfilter"
 
+	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/autocertmanager"
@@ -59,8 +62,10 @@ type (
 		tracer       *tracing.Tracing
 		ipFilter     *ipfilter.IPFilter
 		ipFilterChan *ipfilter.IPFilters
+		clientCAs    *x509.CertPool
 
-		rules []*muxRule
+		rules  []*muxRule
+		router *router
 	}
 
 	muxRule struct {
@@ -84,12 +89,24 @@ type (
 		methods       []string
 		rewriteTarget string
 		backend       string
+		backends      []*WeightedBackend
+		weightsSum    int
 		headers       []*Header
+		queries       []*ParamMatch
+		cookies       []*ParamMatch
+
+		// hasParams is true if path or pathPrefix has parameters.
+		hasParams bool
+		// hasNamedGroups is true if pathRE has named groups, which are
+		// captured as parameters.
+		hasNamedGroups bool
+
+		clientCertMode string
 	}
 )
 
 // newIPFilterChain returns nil if the number of final filters is zero.
-func newIPFilterChain(parentIPFilters *ipfilter.IPFilters, childSpec *ipfilter.Spec) *ipfilter.IPFilters {
+func newIPFilterChain(parentIPFilters *ipfilter.IPFilters, child *ipfilter.IPFilter) *ipfilter.IPFilters {
 	var ipFilters *ipfilter.IPFilters
 	if parentIPFilters != nil {
 		ipFilters = ipfilter.NewIPFilters(parentIPFilters.Filters()...)
@@ -97,8 +114,8 @@ func newIPFilterChain(parentIPFilters *ipfilter.IPFilters, childSpec *ipfilter.S
 		ipFilters = ipfilter.NewIPFilters()
 	}
 
-	if childSpec != nil {
-		ipFilters.Append(ipfilter.New(childSpec))
+	if child != nil {
+		ipFilters.Append(child)
 	}
 
 	if len(ipFilters.Filters()) == 0 {
@@ -108,12 +125,18 @@ func newIPFilterChain(parentIPFilters *ipfilter.IPFilters, childSpec *ipfilter.S
 	return ipFilters
 }
 
-func newIPFilter(spec *ipfilter.Spec) *ipfilter.IPFilter {
+func newIPFilter(spec *ipfilter.Spec, cls cluster.Cluster) *ipfilter.IPFilter {
 	if spec == nil {
 		return nil
 	}
 
-	return ipfilter.New(spec)
+	return ipfilter.NewWithCluster(spec, cls)
+}
+
+func closeIPFilter(ipFilter *ipfilter.IPFilter) {
+	if ipFilter != nil {
+		ipFilter.Close()
+	}
 }
 
 func (mr *muxRules) pass(ctx context.HTTPContext) bool {
@@ -146,7 +169,7 @@ func (mr *muxRules) putCacheItem(ctx context.HTTPContext, ci *cacheItem) {
 	mr.cache.put(key, ci)
 }
 
-func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, paths []*muxPath) *muxRule {
+func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, cls cluster.Cluster) *muxRule {
 	var hostRE *regexp.Regexp
 
 	if rule.HostRegexp != "" {
@@ -159,14 +182,14 @@ func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, paths []*muxPat
 		}
 	}
 
+	ipFilter := newIPFilter(rule.IPFilter, cls)
 	return &muxRule{
-		ipFilter:      newIPFilter(rule.IPFilter),
-		ipFilterChain: newIPFilterChain(parentIPFilters, rule.IPFilter),
+		ipFilter:      ipFilter,
+		ipFilterChain: newIPFilterChain(parentIPFilters, ipFilter),
 
 		host:       rule.Host,
 		hostRegexp: rule.HostRegexp,
 		hostRE:     hostRE,
-		paths:      paths,
 	}
 }
 
@@ -198,7 +221,7 @@ func (mr *muxRule) match(ctx context.HTTPContext) bool {
 	return false
 }
 
-func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *muxPath {
+func newMuxPath(parentIPFilters *ipfilter.IPFilters, clientCertMode string, path *Path, cls cluster.Cluster) *muxPath {
 	var pathRE *regexp.Regexp
 	if path.PathRegexp != "" {
 		var err error
@@ -210,13 +233,34 @@ func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *muxPath {
 		}
 	}
 
+	hasNamedGroups := false
+	if pathRE != nil {
+		for _, name := range pathRE.SubexpNames() {
+			if name != "" {
+				hasNamedGroups = true
+			}
+		}
+	}
+
 	for _, p := range path.Headers {
 		p.initHeaderRoute()
 	}
+	for _, q := range path.Queries {
+		q.Init()
+	}
+	for _, c := range path.Cookies {
+		c.Init()
+	}
+
+	weightsSum := 0
+	for _, b := range path.Backends {
+		weightsSum += b.Weight
+	}
 
+	ipFilter := newIPFilter(path.IPFilter, cls)
 	return &muxPath{
-		ipFilter:      newIPFilter(path.IPFilter),
-		ipFilterChain: newIPFilterChain(parentIPFilters, path.IPFilter),
+		ipFilter:      ipFilter,
+		ipFilterChain: newIPFilterChain(parentIPFilters, ipFilter),
 
 		path:          path.Path,
 		pathPrefix:    path.PathPrefix,
@@ -225,7 +269,16 @@ func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *muxPath {
 		rewriteTarget: path.RewriteTarget,
 		methods:       path.Methods,
 		backend:       path.Backend,
+		backends:      path.Backends,
+		weightsSum:    weightsSum,
 		headers:       path.Headers,
+		queries:       path.Queries,
+		cookies:       path.Cookies,
+
+		hasParams:      hasPathParams(path.Path) || hasPathParams(path.PathPrefix),
+		hasNamedGroups: hasNamedGroups,
+
+		clientCertMode: clientCertMode,
 	}
 }
 
@@ -257,6 +310,31 @@ func (mp *muxPath) matchPath(ctx context.HTTPContext) bool {
 	return false
 }
 
+// regexpParams returns the named groups of pathRegexp as parameters.
+func (mp *muxPath) regexpParams(path string) map[string]string {
+	matches := mp.pathRE.FindStringSubmatch(path)
+	if matches == nil {
+		return nil
+	}
+
+	params := map[string]string{}
+	for i, name := range mp.pathRE.SubexpNames() {
+		if name != "" {
+			params[name] = matches[i]
+		}
+	}
+	return params
+}
+
+// expandParams replaces the {name} in target with the parameters.
+func expandParams(target string, params map[string]string) string {
+	oldnew := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		oldnew = append(oldnew, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(oldnew...).Replace(target)
+}
+
 func (mp *muxPath) matchMethod(ctx context.HTTPContext) bool {
 	if len(mp.methods) == 0 {
 		return true
@@ -265,11 +343,21 @@ func (mp *muxPath) matchMethod(ctx context.HTTPContext) bool {
 	return stringtool.StrInSlice(ctx.Request().Method(), mp.methods)
 }
 
-func (mp *muxPath) hasHeaders() bool {
-	return len(mp.headers) > 0
+// hasRequestMatchers returns true if the path matches the headers,
+// queries or cookies, whose results can't be cached.
+func (mp *muxPath) hasRequestMatchers() bool {
+	return len(mp.headers) > 0 || len(mp.queries) > 0 || len(mp.cookies) > 0
+}
+
+func (mp *muxPath) matchRequest(ctx context.HTTPContext) bool {
+	return mp.matchHeaders(ctx) && mp.matchQueries(ctx) && mp.matchCookies(ctx)
 }
 
 func (mp *muxPath) matchHeaders(ctx context.HTTPContext) bool {
+	if len(mp.headers) == 0 {
+		return true
+	}
+
 	for _, h := range mp.headers {
 		v := ctx.Request().Header().Get(h.Key)
 		if stringtool.StrInSlice(v, h.Values) {
@@ -284,6 +372,60 @@ func (mp *muxPath) matchHeaders(ctx context.HTTPContext) bool {
 	return false
 }
 
+func (mp *muxPath) matchQueries(ctx context.HTTPContext) bool {
+	if len(mp.queries) == 0 {
+		return true
+	}
+
+	query := ctx.Request().Std().URL.Query()
+	for _, q := range mp.queries {
+		if q.Match(query.Get(q.Key)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (mp *muxPath) matchCookies(ctx context.HTTPContext) bool {
+	if len(mp.cookies) == 0 {
+		return true
+	}
+
+	for _, c := range mp.cookies {
+		value := ""
+		if cookie, err := ctx.Request().Cookie(c.Key); err == nil {
+			value = cookie.Value
+		}
+		if c.Match(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectBackend returns the backend, or picks one from the weighted
+// backends randomly.
+func (mp *muxPath) selectBackend() string {
+	if len(mp.backends) == 0 {
+		return mp.backend
+	}
+
+	randomWeight := rand.Intn(mp.weightsSum)
+	for _, b := range mp.backends {
+		randomWeight -= b.Weight
+		if randomWeight < 0 {
+			return b.Name
+		}
+	}
+
+	logger.Errorf("BUG: weighted random can't pick a backend: sum(%d) backends(%+v)",
+		mp.weightsSum, mp.backends)
+
+	return mp.backends[0].Name
+}
+
 func newMux(httpStat *httpstat.HTTPStat, topN *topn.TopN, mapper protocol.MuxMapper) *mux {
 	m := &mux{
 		httpStat: httpStat,
@@ -294,6 +436,7 @@ func newMux(httpStat *httpstat.HTTPStat, topN *topn.TopN, mapper protocol.MuxMap
 		spec:      &Spec{},
 		tracer:    tracing.NoopTracing,
 		muxMapper: mapper,
+		router:    newRouter(nil),
 	})
 
 	return m
@@ -321,12 +464,19 @@ func (m *mux) reloadRules(superSpec *supervisor.Spec, muxMapper protocol.MuxMapp
 		tracer = oldRules.tracer
 	}
 
+	var cls cluster.Cluster
+	if super := superSpec.Super(); super != nil {
+		cls = super.Cluster()
+	}
+
+	ipFilter := newIPFilter(spec.IPFilter, cls)
 	rules := &muxRules{
 		superSpec:    superSpec,
 		spec:         spec,
 		muxMapper:    muxMapper,
-		ipFilter:     newIPFilter(spec.IPFilter),
-		ipFilterChan: newIPFilterChain(nil, spec.IPFilter),
+		ipFilter:     ipFilter,
+		ipFilterChan: newIPFilterChain(nil, ipFilter),
+		clientCAs:    spec.clientCAs(),
 		rules:        make([]*muxRule, len(spec.Rules)),
 		tracer:       tracer,
 	}
@@ -338,18 +488,33 @@ func (m *mux) reloadRules(superSpec *supervisor.Spec, muxMapper protocol.MuxMapp
 	for i := 0; i < len(rules.rules); i++ {
 		specRule := spec.Rules[i]
 
-		ruleIPFilterChain := newIPFilterChain(rules.ipFilterChan, specRule.IPFilter)
+		// NOTE: Given the parent ipFilters not its own.
+		rule := newMuxRule(rules.ipFilterChan, specRule, cls)
+		clientCertMode := spec.clientCertMode(specRule)
 
-		paths := make([]*muxPath, len(specRule.Paths))
-		for j := 0; j < len(paths); j++ {
-			paths[j] = newMuxPath(ruleIPFilterChain, specRule.Paths[j])
+		rule.paths = make([]*muxPath, len(specRule.Paths))
+		for j := 0; j < len(rule.paths); j++ {
+			rule.paths[j] = newMuxPath(rule.ipFilterChain, clientCertMode, specRule.Paths[j], cls)
 		}
 
-		// NOTE: Given the parent ipFilters not its own.
-		rules.rules[i] = newMuxRule(rules.ipFilterChan, specRule, paths)
+		rules.rules[i] = rule
 	}
+	rules.router = newRouter(rules.rules)
 
 	m.rules.Store(rules)
+	oldRules.closeIPFilters()
+}
+
+// closeIPFilters stops watching the dynamic sources of the ip filters,
+// the ip filters are still usable for the requests being handled.
+func (mr *muxRules) closeIPFilters() {
+	closeIPFilter(mr.ipFilter)
+	for _, rule := range mr.rules {
+		closeIPFilter(rule.ipFilter)
+		for _, path := range rule.paths {
+			closeIPFilter(path.ipFilter)
+		}
+	}
 }
 
 func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
@@ -377,57 +542,69 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		return
 	}
 
-	if !rules.pass(ctx) {
+	ci = rules.route(ctx)
+	if ci == nil {
 		m.handleIPNotAllow(ctx)
 		return
 	}
+	m.handleRequestWithCache(rules, ctx, ci)
+}
+
+// route finds the path of the request, it returns nil if the ip of
+// the request is not allowed.
+func (mr *muxRules) route(ctx context.HTTPContext) *cacheItem {
+	if !mr.pass(ctx) {
+		return nil
+	}
 
-	for _, host := range rules.rules {
+	for _, cand := range mr.router.search(ctx) {
+		host, path := cand.leaf.entry.rule, cand.leaf.entry.path
 		if !host.match(ctx) {
 			continue
 		}
 
-		if !host.pass(ctx) {
-			m.handleIPNotAllow(ctx)
-			return
+		if path == nil {
+			if !host.pass(ctx) {
+				return nil
+			}
+			continue
 		}
 
-		for _, path := range host.paths {
-			if !path.matchPath(ctx) {
-				continue
-			}
+		if !cand.leaf.indexed && !path.matchPath(ctx) {
+			continue
+		}
 
-			if !path.matchMethod(ctx) {
-				ci = &cacheItem{ipFilterChan: path.ipFilterChain, methodNotAllowed: true}
-				rules.putCacheItem(ctx, ci)
-				m.handleRequestWithCache(rules, ctx, ci)
-				return
-			}
+		if !path.matchMethod(ctx) {
+			ci := &cacheItem{ipFilterChan: path.ipFilterChain, methodNotAllowed: true}
+			mr.putCacheItem(ctx, ci)
+			return ci
+		}
 
-			if !path.pass(ctx) {
-				m.handleIPNotAllow(ctx)
-				return
-			}
+		if !path.pass(ctx) {
+			return nil
+		}
 
-			if !path.hasHeaders() {
-				ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
-				rules.putCacheItem(ctx, ci)
-				m.handleRequestWithCache(rules, ctx, ci)
-				return
-			}
+		ci := &cacheItem{
+			ipFilterChan: path.ipFilterChain,
+			path:         path,
+			params:       cand.params(),
+			pathSuffix:   ctx.Request().Path()[cand.matchedLen:],
+		}
 
-			if path.matchHeaders(ctx) {
-				// NOTE: No cache for the request matching headers.
-				ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
-				m.handleRequestWithCache(rules, ctx, ci)
-				return
-			}
+		if !path.hasRequestMatchers() {
+			mr.putCacheItem(ctx, ci)
+			return ci
+		}
+
+		if path.matchRequest(ctx) {
+			// NOTE: No cache for the request matching headers, queries or cookies.
+			return ci
 		}
 	}
 
-	ci = &cacheItem{ipFilterChan: rules.ipFilterChan, notFound: true}
-	rules.putCacheItem(ctx, ci)
-	m.handleRequestWithCache(rules, ctx, ci)
+	ci := &cacheItem{ipFilterChan: mr.ipFilterChan, notFound: true}
+	mr.putCacheItem(ctx, ci)
+	return ci
 }
 
 func (m *mux) handleIPNotAllow(ctx context.HTTPContext) {
@@ -449,9 +626,14 @@ func (m *mux) handleRequestWithCache(rules *muxRules, ctx context.HTTPContext, c
 	case ci.methodNotAllowed:
 		ctx.Response().SetStatusCode(http.StatusMethodNotAllowed)
 	case ci.path != nil:
-		handler, exists := rules.muxMapper.GetHandler(ci.path.backend)
+		if !m.handleClientCert(ctx, ci.path.clientCertMode, rules.clientCAs) {
+			return
+		}
+
+		backend := ci.path.selectBackend()
+		handler, exists := rules.muxMapper.GetHandler(backend)
 		if !exists {
-			ctx.AddTag(stringtool.Cat("backend ", ci.path.backend, " not found"))
+			ctx.AddTag(stringtool.Cat("backend ", backend, " not found"))
 			ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
 			return
 		}
@@ -460,10 +642,21 @@ func (m *mux) handleRequestWithCache(rules *muxRules, ctx context.HTTPContext, c
 			m.appendXForwardedFor(ctx)
 		}
 
-		if ci.path.pathRE != nil && ci.path.rewriteTarget != "" {
+		params := ci.params
+		if params == nil && ci.path.hasNamedGroups {
+			params = ci.path.regexpParams(ctx.Request().Path())
+		}
+		if params != nil {
+			ctx.Request().SetPathParams(params)
+		}
+
+		switch {
+		case ci.path.pathRE != nil && ci.path.rewriteTarget != "":
 			path := ctx.Request().Path()
 			path = ci.path.pathRE.ReplaceAllString(path, ci.path.rewriteTarget)
 			ctx.Request().SetPath(path)
+		case ci.path.hasParams && ci.path.rewriteTarget != "":
+			ctx.Request().SetPath(expandParams(ci.path.rewriteTarget, params) + ci.pathSuffix)
 		}
 		// global filter
 		globalFilter := m.getGlobalFilter(rules)
@@ -507,6 +700,7 @@ func (m *mux) getGlobalFilter(rules *muxRules) *globalfilter.GlobalFilter {
 
 func (m *mux) close() {
 	rules := m.rules.Load().(*muxRules)
+	rules.closeIPFilters()
 	err := rules.tracer.Close()
 	if err != nil {
 		logger.Errorf("%s close tracer failed: %v",
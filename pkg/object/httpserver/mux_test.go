@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+func TestPathValidate(t *testing.T) {
+	p := &Path{}
+	if p.Validate() == nil {
+		t.Errorf("path without backend should fail")
+	}
+
+	p.Backend = "pipeline-v1"
+	if p.Validate() != nil {
+		t.Errorf("validate should succeed")
+	}
+
+	p.Backends = []*WeightedBackend{{Name: "pipeline-v2", Weight: 10}}
+	if p.Validate() == nil {
+		t.Errorf("backend and backends are exclusive")
+	}
+
+	p.Backend = ""
+	if p.Validate() != nil {
+		t.Errorf("validate should succeed")
+	}
+
+	p.Backends[0].Weight = 0
+	if p.Validate() == nil {
+		t.Errorf("backends with zero weights should fail")
+	}
+}
+
+func TestMatchRequest(t *testing.T) {
+	mp := newMuxPath(nil, clientCertIgnore, &Path{
+		PathPrefix: "/api",
+		Backend:    "pipeline-v1",
+		Queries: []*ParamMatch{
+			{Key: "version", StringMatch: urlrule.StringMatch{Exact: "v2"}},
+			{Key: "env", StringMatch: urlrule.StringMatch{Prefix: "canary"}},
+		},
+		Cookies: []*ParamMatch{
+			{Key: "user", StringMatch: urlrule.StringMatch{RegEx: "^tester-[0-9]+$"}},
+		},
+	}, nil)
+
+	if !mp.hasRequestMatchers() {
+		t.Fatalf("path should have request matchers")
+	}
+
+	newContext := func(query, cookie string) context.HTTPContext {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.org/api?"+query, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "user", Value: cookie})
+		}
+		return context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace")
+	}
+
+	cases := []struct {
+		query    string
+		cookie   string
+		expected bool
+	}{
+		{"version=v2", "tester-1", true},
+		{"env=canary-east", "tester-22", true},
+		{"version=v1", "tester-1", false},
+		{"version=v2", "tester-x", false},
+		{"version=v2", "", false},
+		{"", "tester-1", false},
+	}
+	for _, c := range cases {
+		if mp.matchRequest(newContext(c.query, c.cookie)) != c.expected {
+			t.Errorf("match query %q cookie %q should be %v", c.query, c.cookie, c.expected)
+		}
+	}
+
+	mp = newMuxPath(nil, clientCertIgnore, &Path{Backend: "pipeline-v1"}, nil)
+	if mp.hasRequestMatchers() || !mp.matchRequest(newContext("", "")) {
+		t.Errorf("path without request matchers should match")
+	}
+}
+
+func TestSelectBackend(t *testing.T) {
+	mp := newMuxPath(nil, clientCertIgnore, &Path{Backend: "pipeline-v1"}, nil)
+	if mp.selectBackend() != "pipeline-v1" {
+		t.Errorf("backend should be pipeline-v1")
+	}
+
+	mp = newMuxPath(nil, clientCertIgnore, &Path{
+		Backends: []*WeightedBackend{
+			{Name: "pipeline-v1", Weight: 90},
+			{Name: "pipeline-v2", Weight: 10},
+			{Name: "pipeline-v3", Weight: 0},
+		},
+	}, nil)
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[mp.selectBackend()]++
+	}
+	if counts["pipeline-v3"] != 0 {
+		t.Errorf("backend with zero weight should not be selected")
+	}
+	if v2 := counts["pipeline-v2"]; v2 < 700 || v2 > 1300 {
+		t.Errorf("pipeline-v2 should be selected about 1000 times, but got %d", v2)
+	}
+}
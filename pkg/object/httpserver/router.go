@@ -0,0 +1,368 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+type (
+	// router indexes the rules by host and the paths by a radix tree,
+	// to find the candidates of a request without walking all paths.
+	// The candidates are checked in the order of rules and paths, so
+	// the result is the same as checking all of them one by one.
+	router struct {
+		// hosts indexes the rules with host.
+		hosts map[string]*pathIndex
+		// anyHost indexes the rules with hostRegexp or without host.
+		anyHost *pathIndex
+	}
+
+	pathIndex struct {
+		root *radixNode
+		// fallback are the entries which can't be indexed: paths with
+		// pathRegexp, and rules with ipFilter.
+		fallback []*routeLeaf
+	}
+
+	radixNode struct {
+		// prefix is the static part of the edge to this node.
+		prefix   string
+		children []*radixNode
+		// param is the child which matches a segment of the path.
+		param *radixNode
+
+		exact    []*routeLeaf
+		prefixes []*routeLeaf
+	}
+
+	// routeEntry is a path of a rule, path is nil for checking the ipFilter
+	// of the rule.
+	routeEntry struct {
+		order int
+		rule  *muxRule
+		path  *muxPath
+	}
+
+	routeLeaf struct {
+		entry      *routeEntry
+		paramNames []string
+		// indexed is true if the path matched when the leaf is found.
+		indexed bool
+	}
+
+	routeCandidate struct {
+		leaf        *routeLeaf
+		paramValues []string
+		// matchedLen is the length of the path matched by the leaf.
+		matchedLen int
+	}
+)
+
+var paramNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parsePathPattern splits a path pattern like /users/{id}/posts into
+// its static parts and parameter names, the number of static parts is
+// always one more than the number of parameters.
+func parsePathPattern(pattern string) ([]string, []string, error) {
+	statics, names := []string{}, []string{}
+
+	rest := pattern
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return nil, nil, fmt.Errorf("unexpected } in %s", pattern)
+			}
+			statics = append(statics, rest)
+			return statics, names, nil
+		}
+		if start == 0 || rest[start-1] != '/' {
+			return nil, nil, fmt.Errorf("parameter must be a whole segment in %s", pattern)
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("unclosed { in %s", pattern)
+		}
+		end += start
+
+		name := rest[start+1 : end]
+		if !paramNameRE.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid parameter name %q in %s", name, pattern)
+		}
+		for _, n := range names {
+			if n == name {
+				return nil, nil, fmt.Errorf("duplicated parameter %s in %s", name, pattern)
+			}
+		}
+		if end+1 < len(rest) && rest[end+1] != '/' {
+			return nil, nil, fmt.Errorf("parameter must be a whole segment in %s", pattern)
+		}
+
+		statics = append(statics, rest[:start])
+		names = append(names, name)
+		rest = rest[end+1:]
+	}
+}
+
+func hasPathParams(pattern string) bool {
+	return strings.IndexByte(pattern, '{') >= 0
+}
+
+func newRouter(rules []*muxRule) *router {
+	r := &router{
+		hosts:   map[string]*pathIndex{},
+		anyHost: newPathIndex(),
+	}
+
+	order := 0
+	for _, rule := range rules {
+		indexes := []*pathIndex{}
+		if rule.host != "" {
+			index := r.hosts[rule.host]
+			if index == nil {
+				index = newPathIndex()
+				r.hosts[rule.host] = index
+			}
+			indexes = append(indexes, index)
+		}
+		if rule.host == "" || rule.hostRE != nil {
+			indexes = append(indexes, r.anyHost)
+		}
+
+		entries := []*routeEntry{}
+		if rule.ipFilter != nil {
+			entries = append(entries, &routeEntry{order: order, rule: rule})
+			order++
+		}
+		for _, path := range rule.paths {
+			entries = append(entries, &routeEntry{order: order, rule: rule, path: path})
+			order++
+		}
+
+		for _, index := range indexes {
+			for _, entry := range entries {
+				index.add(entry)
+			}
+		}
+	}
+
+	return r
+}
+
+func newPathIndex() *pathIndex {
+	return &pathIndex{root: &radixNode{}}
+}
+
+func (pi *pathIndex) add(entry *routeEntry) {
+	path := entry.path
+	if path == nil {
+		pi.fallback = append(pi.fallback, &routeLeaf{entry: entry})
+		return
+	}
+
+	if path.path == "" && path.pathPrefix == "" && path.pathRegexp == "" {
+		pi.root.prefixes = append(pi.root.prefixes, &routeLeaf{entry: entry, indexed: true})
+		return
+	}
+
+	if path.path != "" {
+		node, names := pi.root.insertPattern(path.path)
+		node.exact = append(node.exact, &routeLeaf{entry: entry, paramNames: names, indexed: true})
+	}
+	if path.pathPrefix != "" {
+		node, names := pi.root.insertPattern(path.pathPrefix)
+		node.prefixes = append(node.prefixes, &routeLeaf{entry: entry, paramNames: names, indexed: true})
+	}
+	if path.pathRegexp != "" {
+		pi.fallback = append(pi.fallback, &routeLeaf{entry: entry})
+	}
+}
+
+// search appends the candidates of the path to cands.
+func (pi *pathIndex) search(path string, cands []routeCandidate) []routeCandidate {
+	for _, leaf := range pi.fallback {
+		cands = append(cands, routeCandidate{leaf: leaf})
+	}
+
+	pi.root.search(path, path, nil, &cands)
+	return cands
+}
+
+// insertPattern inserts the path pattern, returns the node of it and
+// the parameter names.
+func (n *radixNode) insertPattern(pattern string) (*radixNode, []string) {
+	statics, names, err := parsePathPattern(pattern)
+	if err != nil {
+		// NOTE: The pattern has been validated, treat it as a static path
+		// in case.
+		statics, names = []string{pattern}, nil
+	}
+
+	node := n.insertStatic(statics[0])
+	for i := range names {
+		if node.param == nil {
+			node.param = &radixNode{}
+		}
+		node = node.param.insertStatic(statics[i+1])
+	}
+
+	return node, names
+}
+
+func (n *radixNode) insertStatic(s string) *radixNode {
+	if s == "" {
+		return n
+	}
+
+	for i, child := range n.children {
+		l := commonPrefixLen(child.prefix, s)
+		if l == 0 {
+			continue
+		}
+
+		if l < len(child.prefix) {
+			mid := &radixNode{prefix: child.prefix[:l], children: []*radixNode{child}}
+			child.prefix = child.prefix[l:]
+			n.children[i] = mid
+			child = mid
+		}
+
+		return child.insertStatic(s[l:])
+	}
+
+	child := &radixNode{prefix: s}
+	n.children = append(n.children, child)
+	return child
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// search appends all leaves matching the path to cands, rest is the
+// part of path which hasn't been matched.
+func (n *radixNode) search(path, rest string, values []string, cands *[]routeCandidate) {
+	found := func(leaf *routeLeaf) {
+		cand := routeCandidate{leaf: leaf, matchedLen: len(path) - len(rest)}
+		if len(values) > 0 {
+			cand.paramValues = append([]string(nil), values...)
+		}
+		*cands = append(*cands, cand)
+	}
+
+	for _, leaf := range n.prefixes {
+		found(leaf)
+	}
+
+	if rest == "" {
+		for _, leaf := range n.exact {
+			found(leaf)
+		}
+		return
+	}
+
+	for _, child := range n.children {
+		if child.prefix[0] != rest[0] {
+			continue
+		}
+		if strings.HasPrefix(rest, child.prefix) {
+			child.search(path, rest[len(child.prefix):], values, cands)
+		}
+		// NOTE: The first bytes of children are different.
+		break
+	}
+
+	if n.param != nil {
+		end := strings.IndexByte(rest, '/')
+		if end < 0 {
+			end = len(rest)
+		}
+		if end > 0 {
+			n.param.search(path, rest[end:], append(values, rest[:end]), cands)
+		}
+	}
+}
+
+// search returns the candidates of the request in the order of rules
+// and paths.
+func (r *router) search(ctx context.HTTPContext) []routeCandidate {
+	req := ctx.Request()
+
+	host := req.Host()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var cands []routeCandidate
+	if index := r.hosts[host]; index != nil {
+		cands = index.search(req.Path(), cands)
+	}
+	cands = r.anyHost.search(req.Path(), cands)
+
+	// NOTE: There are few candidates in most cases, so insertion sort
+	// is used to avoid the allocations of sort.Slice.
+	for i := 1; i < len(cands); i++ {
+		for j := i; j > 0 && cands[j].less(&cands[j-1]); j-- {
+			cands[j], cands[j-1] = cands[j-1], cands[j]
+		}
+	}
+
+	// Remove the duplicated entries, which are from the exact path and
+	// path prefix of the same path, or from both indexes of hosts.
+	result := cands[:0]
+	for _, cand := range cands {
+		if len(result) > 0 && result[len(result)-1].leaf.entry == cand.leaf.entry {
+			continue
+		}
+		result = append(result, cand)
+	}
+
+	return result
+}
+
+func (c *routeCandidate) less(other *routeCandidate) bool {
+	if c.leaf.entry.order != other.leaf.entry.order {
+		return c.leaf.entry.order < other.leaf.entry.order
+	}
+	// NOTE: Prefer the indexed one, so that the parameters are kept.
+	return c.leaf.indexed && !other.leaf.indexed
+}
+
+// params returns the parameters captured from the path.
+func (c *routeCandidate) params() map[string]string {
+	if len(c.leaf.paramNames) == 0 {
+		return nil
+	}
+
+	params := make(map[string]string, len(c.leaf.paramNames))
+	for i, name := range c.leaf.paramNames {
+		params[name] = c.paramValues[i]
+	}
+	return params
+}
@@ -0,0 +1,401 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+)
+
+type (
+	testHandler struct {
+		ctx context.HTTPContext
+	}
+
+	testMuxMapper struct {
+		handlers map[string]*testHandler
+	}
+)
+
+func (h *testHandler) Handle(ctx context.HTTPContext) string {
+	h.ctx = ctx
+	return ""
+}
+
+func (m *testMuxMapper) GetHandler(name string) (protocol.HTTPHandler, bool) {
+	h, ok := m.handlers[name]
+	return h, ok
+}
+
+func newTestMuxRules(spec *Spec) *muxRules {
+	mr := &muxRules{
+		spec:         spec,
+		ipFilterChan: newIPFilterChain(nil, newIPFilter(spec.IPFilter, nil)),
+		rules:        make([]*muxRule, len(spec.Rules)),
+	}
+	for i, specRule := range spec.Rules {
+		rule := newMuxRule(mr.ipFilterChan, specRule, nil)
+		rule.paths = make([]*muxPath, len(specRule.Paths))
+		for j, specPath := range specRule.Paths {
+			rule.paths[j] = newMuxPath(rule.ipFilterChain, clientCertIgnore, specPath, nil)
+		}
+		mr.rules[i] = rule
+	}
+	mr.router = newRouter(mr.rules)
+	return mr
+}
+
+func newTestContext(method, host, path string) context.HTTPContext {
+	req, _ := http.NewRequest(method, "http://"+host+path, nil)
+	req.RemoteAddr = "192.168.1.1:8080"
+	return context.New(httptest.NewRecorder(), req, tracing.NoopTracing, "no trace")
+}
+
+// routeLinear checks the rules and paths one by one, which is the
+// matcher before the router.
+func routeLinear(mr *muxRules, ctx context.HTTPContext, matchPath func(*muxPath, context.HTTPContext) bool) *cacheItem {
+	if !mr.pass(ctx) {
+		return nil
+	}
+
+	for _, host := range mr.rules {
+		if !host.match(ctx) {
+			continue
+		}
+		if !host.pass(ctx) {
+			return nil
+		}
+
+		for _, path := range host.paths {
+			if !matchPath(path, ctx) {
+				continue
+			}
+			if !path.matchMethod(ctx) {
+				return &cacheItem{ipFilterChan: path.ipFilterChain, methodNotAllowed: true}
+			}
+			if !path.pass(ctx) {
+				return nil
+			}
+			if !path.hasRequestMatchers() || path.matchRequest(ctx) {
+				return &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
+			}
+		}
+	}
+
+	return &cacheItem{ipFilterChan: mr.ipFilterChan, notFound: true}
+}
+
+// patternRE converts the path pattern to regular expression.
+func patternRE(pattern string, prefix bool) *regexp.Regexp {
+	expr := regexp.MustCompile(`\\\{[A-Za-z_][A-Za-z0-9_]*\\\}`).
+		ReplaceAllString(regexp.QuoteMeta(pattern), `[^/]+`)
+	if !prefix {
+		expr += "$"
+	}
+	return regexp.MustCompile("^" + expr)
+}
+
+// matchPattern is the reference implementation of matchPath supporting
+// path parameters.
+func matchPattern(mp *muxPath, ctx context.HTTPContext) bool {
+	path := ctx.Request().Path()
+	if mp.path == "" && mp.pathPrefix == "" && mp.pathRE == nil {
+		return true
+	}
+	if mp.path != "" && patternRE(mp.path, false).MatchString(path) {
+		return true
+	}
+	if mp.pathPrefix != "" && patternRE(mp.pathPrefix, true).MatchString(path) {
+		return true
+	}
+	return mp.pathRE != nil && mp.pathRE.MatchString(path)
+}
+
+func TestParsePathPattern(t *testing.T) {
+	statics, names, err := parsePathPattern("/users/{id}/posts/{post_id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(statics, ",") != "/users/,/posts/," || strings.Join(names, ",") != "id,post_id" {
+		t.Errorf("unexpected result: %q %q", statics, names)
+	}
+
+	statics, names, err = parsePathPattern("/users")
+	if err != nil || len(statics) != 1 || len(names) != 0 {
+		t.Errorf("unexpected result: %q %q %v", statics, names, err)
+	}
+
+	for _, pattern := range []string{
+		"/users/{id", "/users/id}", "/users/x{id}", "/users/{id}x",
+		"/users/{}", "/users/{1d}", "/users/{id}/{id}",
+	} {
+		if _, _, err := parsePathPattern(pattern); err == nil {
+			t.Errorf("parse %s should fail", pattern)
+		}
+	}
+
+	p := &Path{Path: "/users/{id", Backend: "pipeline"}
+	if p.Validate() == nil {
+		t.Errorf("invalid pattern should fail")
+	}
+}
+
+func TestRouterSearch(t *testing.T) {
+	spec := &Spec{
+		Rules: []*Rule{
+			{
+				Host: "a.com",
+				Paths: []*Path{
+					{Path: "/users/me", Backend: "me"},
+					{Path: "/users/{id}", Backend: "user"},
+					{PathPrefix: "/users/{id}/", Backend: "user-sub"},
+					{PathPrefix: "/api", Methods: []string{http.MethodGet}, Backend: "api"},
+				},
+			},
+			{
+				HostRegexp: `^[a-z]\.com$`,
+				Paths: []*Path{
+					{PathRegexp: `^/re/[0-9]+$`, Backend: "re"},
+					{PathPrefix: "/api/v2", Backend: "api-v2"},
+					{Path: "/tenants/{tenant}/orders/{order}", Backend: "order"},
+				},
+			},
+			{
+				IPFilter: &ipfilter.Spec{BlockIPs: []string{"0.0.0.0/0"}},
+				Host:     "blocked.com",
+				Paths:    []*Path{{Path: "/never", Backend: "never"}},
+			},
+			{
+				Paths: []*Path{
+					{Path: "/users/{id}", Backend: "any-user"},
+					{Backend: "default"},
+				},
+			},
+		},
+	}
+	mr := newTestMuxRules(spec)
+
+	cases := []struct {
+		method, host, path string
+		backend            string
+		params             string
+	}{
+		{"GET", "a.com", "/users/me", "me", ""},
+		{"GET", "a.com:8080", "/users/1", "user", "id=1"},
+		{"GET", "a.com", "/users/1/posts", "user-sub", "id=1"},
+		{"GET", "a.com", "/users/", "default", ""},
+		{"GET", "a.com", "/api/v2/x", "api", ""},
+		{"POST", "a.com", "/api/v2/x", "405", ""},
+		{"POST", "b.com", "/api/v2/x", "api-v2", ""},
+		{"GET", "b.com", "/re/123", "re", ""},
+		{"GET", "b.com", "/re/12a", "default", ""},
+		{"GET", "b.com", "/tenants/t1/orders/o1", "order", "order=o1,tenant=t1"},
+		{"GET", "b.com", "/tenants/t1/orders/o1/x", "default", ""},
+		{"GET", "blocked.com", "/anything", "403", ""},
+		{"GET", "c.org", "/users/2", "any-user", "id=2"},
+	}
+
+	for _, c := range cases {
+		ctx := newTestContext(c.method, c.host, c.path)
+		ci := mr.route(ctx)
+
+		backend := ""
+		switch {
+		case ci == nil:
+			backend = "403"
+		case ci.methodNotAllowed:
+			backend = "405"
+		case ci.notFound:
+			backend = "404"
+		default:
+			backend = ci.path.backend
+		}
+		if backend != c.backend {
+			t.Errorf("%s %s%s: expected backend %s, but got %s", c.method, c.host, c.path, c.backend, backend)
+			continue
+		}
+
+		params := []string{}
+		if ci != nil {
+			for k, v := range ci.params {
+				params = append(params, k+"="+v)
+			}
+		}
+		sort.Strings(params)
+		if strings.Join(params, ",") != c.params {
+			t.Errorf("%s %s%s: expected params %q, but got %q", c.method, c.host, c.path, c.params, params)
+		}
+	}
+}
+
+func TestRouterMatchesLinear(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	segments := []string{"a", "b", "ab", "{x}", "{y}"}
+	randomPath := func(param bool) string {
+		n := r.Intn(4) + 1
+		path := ""
+		for i := 0; i < n; i++ {
+			seg := segments[r.Intn(len(segments))]
+			if !param && seg[0] == '{' {
+				seg = "c"
+			}
+			if strings.Contains(path, seg) && seg[0] == '{' {
+				seg = "z"
+			}
+			path += "/" + seg
+		}
+		return path
+	}
+
+	spec := &Spec{}
+	for i := 0; i < 20; i++ {
+		rule := &Rule{}
+		switch r.Intn(4) {
+		case 0:
+			rule.Host = "a.com"
+		case 1:
+			rule.HostRegexp = `^b\.`
+		case 2:
+			rule.Host, rule.HostRegexp = "c.com", `^a\.`
+		}
+		if r.Intn(4) == 0 {
+			rule.Host, rule.HostRegexp = "d.com", ""
+			rule.IPFilter = &ipfilter.Spec{BlockIPs: []string{"0.0.0.0/0"}}
+		}
+		for j := 0; j < 10; j++ {
+			path := &Path{Backend: fmt.Sprintf("%d-%d", i, j)}
+			switch r.Intn(4) {
+			case 0:
+				path.Path = randomPath(true)
+			case 1:
+				path.PathPrefix = randomPath(true)
+			case 2:
+				path.PathRegexp = "^" + randomPath(false) + "$"
+			case 3:
+				path.Path, path.PathPrefix = randomPath(true), randomPath(false)
+			}
+			if r.Intn(5) == 0 {
+				path.Methods = []string{http.MethodPost}
+			}
+			rule.Paths = append(rule.Paths, path)
+		}
+		spec.Rules = append(spec.Rules, rule)
+	}
+	mr := newTestMuxRules(spec)
+
+	hosts := []string{"a.com", "b.com", "c.com", "d.com"}
+	for i := 0; i < 5000; i++ {
+		ctx := newTestContext(http.MethodGet, hosts[r.Intn(len(hosts))], randomPath(false)+"/"[:r.Intn(2)])
+		expected, got := routeLinear(mr, ctx, matchPattern), mr.route(ctx)
+		if (expected == nil) != (got == nil) {
+			t.Fatalf("%s%s: expected %+v, but got %+v", ctx.Request().Host(), ctx.Request().Path(), expected, got)
+		}
+		if expected == nil {
+			continue
+		}
+		if expected.notFound != got.notFound || expected.methodNotAllowed != got.methodNotAllowed || expected.path != got.path {
+			t.Fatalf("%s%s: expected %+v, but got %+v", ctx.Request().Host(), ctx.Request().Path(), expected, got)
+		}
+	}
+}
+
+func TestHandleRequestWithParams(t *testing.T) {
+	spec := &Spec{
+		Rules: []*Rule{{
+			Paths: []*Path{
+				{PathPrefix: "/tenants/{tenant}/", RewriteTarget: "/api/{tenant}/", Backend: "tenant"},
+				{PathRegexp: `^/users/(?P<id>[0-9]+)$`, RewriteTarget: "/v2/users/$1", Backend: "user"},
+			},
+		}},
+	}
+	mr := newTestMuxRules(spec)
+	handler := &testHandler{}
+	mr.muxMapper = &testMuxMapper{handlers: map[string]*testHandler{"tenant": handler, "user": handler}}
+	m := &mux{}
+
+	cases := []struct {
+		path, rewritten, param, value string
+	}{
+		{"/tenants/t1/orders/1", "/api/t1/orders/1", "tenant", "t1"},
+		{"/users/42", "/v2/users/42", "id", "42"},
+	}
+	for _, c := range cases {
+		handler.ctx = nil
+		ctx := newTestContext(http.MethodGet, "a.com", c.path)
+		m.handleRequestWithCache(mr, ctx, mr.route(ctx))
+		if handler.ctx == nil {
+			t.Errorf("%s: handler should be called", c.path)
+			continue
+		}
+		if p := ctx.Request().Path(); p != c.rewritten {
+			t.Errorf("%s: path should be rewritten to %s, but got %s", c.path, c.rewritten, p)
+		}
+		if v := ctx.Request().PathParam(c.param); v != c.value {
+			t.Errorf("%s: param %s should be %s, but got %s", c.path, c.param, c.value, v)
+		}
+	}
+}
+
+func newBenchmarkMuxRules(tenants int) *muxRules {
+	spec := &Spec{Rules: []*Rule{{}}}
+	for i := 0; i < tenants; i++ {
+		spec.Rules[0].Paths = append(spec.Rules[0].Paths,
+			&Path{Path: fmt.Sprintf("/tenants/t%d/users", i), Backend: "users"},
+			&Path{PathPrefix: fmt.Sprintf("/tenants/t%d/orders/", i), Backend: "orders"},
+		)
+	}
+	return newTestMuxRules(spec)
+}
+
+func benchmarkRoute(b *testing.B, route func(*muxRules, context.HTTPContext) *cacheItem) {
+	for _, tenants := range []int{10, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("tenants-%d", tenants), func(b *testing.B) {
+			mr := newBenchmarkMuxRules(tenants)
+			path := fmt.Sprintf("/tenants/t%d/orders/1", tenants-1)
+			ctx := newTestContext(http.MethodGet, "a.com", path)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if ci := route(mr, ctx); ci == nil || ci.path == nil {
+					b.Fatalf("%s should be found", path)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRouteLinear(b *testing.B) {
+	benchmarkRoute(b, func(mr *muxRules, ctx context.HTTPContext) *cacheItem {
+		return routeLinear(mr, ctx, (*muxPath).matchPath)
+	})
+}
+
+func BenchmarkRouteRadix(b *testing.B) {
+	benchmarkRoute(b, (*muxRules).route)
+}
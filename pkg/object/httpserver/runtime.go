@@ -25,6 +25,8 @@ import (
 	"time"
 
 	"github.com/lucas-clemente/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/megaease/easegress/pkg/graceupdate"
 	"github.com/megaease/easegress/pkg/logger"
@@ -252,6 +254,14 @@ func (r *runtime) startServer() {
 	}
 	srv.SetKeepAlivesEnabled(r.spec.KeepAlive)
 
+	if r.spec.H2C {
+		// NOTE: The h2c connections are hijacked from srv,
+		// so they are not closed by the shutdown of srv.
+		srv.Handler = h2c.NewHandler(r.mux, &http2.Server{
+			IdleTimeout: keepAliveTimeout,
+		})
+	}
+
 	if r.spec.HTTPS {
 		tlsConfig, _ := r.spec.tlsConfig()
 		srv.TLSConfig = tlsConfig
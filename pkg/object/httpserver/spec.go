@@ -27,12 +27,14 @@ import (
 	"github.com/megaease/easegress/pkg/object/autocertmanager"
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
 type (
 	// Spec describes the HTTPServer.
 	Spec struct {
 		HTTP3            bool          `yaml:"http3" jsonschema:"omitempty"`
+		H2C              bool          `yaml:"h2c,omitempty" jsonschema:"omitempty"`
 		Port             uint16        `yaml:"port" jsonschema:"required,minimum=1"`
 		KeepAlive        bool          `yaml:"keepAlive" jsonschema:"required"`
 		KeepAliveTimeout string        `yaml:"keepAliveTimeout" jsonschema:"omitempty,format=duration"`
@@ -43,6 +45,9 @@ type (
 		XForwardedFor    bool          `yaml:"xForwardedFor" jsonschema:"omitempty"`
 		Tracing          *tracing.Spec `yaml:"tracing" jsonschema:"omitempty"`
 		CaCertBase64     string        `yaml:"caCertBase64" jsonschema:"omitempty,format=base64"`
+		// ClientCertMode is the default client certificate mode of rules,
+		// it is require if caCertBase64 is provided, ignore otherwise.
+		ClientCertMode string `yaml:"clientCertMode,omitempty" jsonschema:"omitempty,enum=require,enum=optional,enum=ignore"`
 
 		// Support multiple certs, preserve the certbase64 and keybase64
 		// for backward compatibility
@@ -73,6 +78,8 @@ type (
 		Host       string         `yaml:"host" jsonschema:"omitempty"`
 		HostRegexp string         `yaml:"hostRegexp" jsonschema:"omitempty,format=regexp"`
 		Paths      []*Path        `yaml:"paths" jsonschema:"omitempty"`
+		// ClientCertMode overrides the clientCertMode of the server.
+		ClientCertMode string `yaml:"clientCertMode,omitempty" jsonschema:"omitempty,enum=require,enum=optional,enum=ignore"`
 	}
 
 	// Path is second level entry of router.
@@ -83,8 +90,27 @@ type (
 		PathRegexp    string         `yaml:"pathRegexp,omitempty" jsonschema:"omitempty,format=regexp"`
 		RewriteTarget string         `yaml:"rewriteTarget" jsonschema:"omitempty"`
 		Methods       []string       `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
-		Backend       string         `yaml:"backend" jsonschema:"required"`
-		Headers       []*Header      `yaml:"headers" jsonschema:"omitempty"`
+		Backend       string         `yaml:"backend,omitempty" jsonschema:"omitempty"`
+		// Backends splits the traffic to multiple backends by weight,
+		// exactly one of backend and backends must be specified.
+		Backends []*WeightedBackend `yaml:"backends,omitempty" jsonschema:"omitempty"`
+		Headers  []*Header          `yaml:"headers" jsonschema:"omitempty"`
+		// Queries and Cookies are checked like Headers, a request must
+		// match one of the entries of each of Headers, Queries and Cookies.
+		Queries []*ParamMatch `yaml:"queries,omitempty" jsonschema:"omitempty"`
+		Cookies []*ParamMatch `yaml:"cookies,omitempty" jsonschema:"omitempty"`
+	}
+
+	// WeightedBackend is a backend with its weight.
+	WeightedBackend struct {
+		Name   string `yaml:"name" jsonschema:"required"`
+		Weight int    `yaml:"weight" jsonschema:"required,minimum=0"`
+	}
+
+	// ParamMatch is the matching rule of a query parameter or a cookie.
+	ParamMatch struct {
+		Key                 string `yaml:"key" jsonschema:"required"`
+		urlrule.StringMatch `yaml:",inline"`
 	}
 
 	// Header is the third level entry of router. A header entry is always under a specific path entry, that is to mean
@@ -101,6 +127,17 @@ type (
 
 // Validate validates HTTPServerSpec.
 func (spec *Spec) Validate() error {
+	if spec.CaCertBase64 == "" {
+		if spec.ClientCertMode != "" && spec.ClientCertMode != clientCertIgnore {
+			return fmt.Errorf("clientCertMode %s requires caCertBase64", spec.ClientCertMode)
+		}
+		for _, rule := range spec.Rules {
+			if rule.ClientCertMode != "" && rule.ClientCertMode != clientCertIgnore {
+				return fmt.Errorf("clientCertMode %s of rule requires caCertBase64", rule.ClientCertMode)
+			}
+		}
+	}
+
 	if !spec.HTTPS {
 		if spec.HTTP3 {
 			return fmt.Errorf("https is disabled when http3 enabled")
@@ -108,6 +145,10 @@ func (spec *Spec) Validate() error {
 		return nil
 	}
 
+	if spec.H2C {
+		return fmt.Errorf("https is enabled when h2c enabled")
+	}
+
 	if spec.CertBase64 == "" && spec.KeyBase64 == "" && len(spec.Certs) == 0 && len(spec.Keys) == 0 && !spec.AutoCert {
 		return fmt.Errorf("certBase64/keyBase64, certs/keys are both empty and autocert is disabled when https enabled")
 	}
@@ -174,18 +215,81 @@ func (spec *Spec) tlsConfig() (*tls.Config, error) {
 
 	// if caCertBase64 configuration is provided, should enable tls.ClientAuth and
 	// add the root cert
-	if len(spec.CaCertBase64) != 0 {
-		rootCertPem, _ := base64.StdEncoding.DecodeString(spec.CaCertBase64)
-		certPool := x509.NewCertPool()
-		certPool.AppendCertsFromPEM(rootCertPem)
-
+	if certPool := spec.clientCAs(); certPool != nil {
+		// NOTE: If some rules don't require client certificates, the handshake
+		// doesn't verify the client certificate, otherwise an invalid one fails
+		// requests to the ignore rules too. The mux verifies it per rule instead.
 		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		if !spec.requireClientCertForAll() {
+			tlsConf.ClientAuth = tls.RequestClientCert
+		}
 		tlsConf.ClientCAs = certPool
 	}
 
 	return tlsConf, nil
 }
 
+// clientCAs returns the root certificates to verify client certificates,
+// nil if caCertBase64 isn't provided.
+func (spec *Spec) clientCAs() *x509.CertPool {
+	if len(spec.CaCertBase64) == 0 {
+		return nil
+	}
+	rootCertPem, _ := base64.StdEncoding.DecodeString(spec.CaCertBase64)
+	certPool := x509.NewCertPool()
+	certPool.AppendCertsFromPEM(rootCertPem)
+	return certPool
+}
+
+// clientCertMode returns the client certificate mode of the rule.
+func (spec *Spec) clientCertMode(rule *Rule) string {
+	switch {
+	case spec.CaCertBase64 == "":
+		return clientCertIgnore
+	case rule != nil && rule.ClientCertMode != "":
+		return rule.ClientCertMode
+	case spec.ClientCertMode != "":
+		return spec.ClientCertMode
+	default:
+		return clientCertRequire
+	}
+}
+
+func (spec *Spec) requireClientCertForAll() bool {
+	if spec.clientCertMode(nil) != clientCertRequire {
+		return false
+	}
+	for _, rule := range spec.Rules {
+		if spec.clientCertMode(rule) != clientCertRequire {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate validates Path.
+func (p *Path) Validate() error {
+	if (p.Backend == "") == (len(p.Backends) == 0) {
+		return fmt.Errorf("exactly one of backend and backends must be specified")
+	}
+
+	for _, pattern := range []string{p.Path, p.PathPrefix} {
+		if _, _, err := parsePathPattern(pattern); err != nil {
+			return err
+		}
+	}
+
+	weightsSum := 0
+	for _, b := range p.Backends {
+		weightsSum += b.Weight
+	}
+	if len(p.Backends) > 0 && weightsSum == 0 {
+		return fmt.Errorf("sum of weights of backends is zero")
+	}
+
+	return nil
+}
+
 func (h *Header) initHeaderRoute() {
 	h.headerRE = regexp.MustCompile(h.Regexp)
 }
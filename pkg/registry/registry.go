@@ -21,18 +21,23 @@ import (
 
 	// Filters
 	_ "github.com/megaease/easegress/pkg/filter/apiaggregator"
+	_ "github.com/megaease/easegress/pkg/filter/authorizer"
 	_ "github.com/megaease/easegress/pkg/filter/bridge"
 	_ "github.com/megaease/easegress/pkg/filter/circuitbreaker"
 	_ "github.com/megaease/easegress/pkg/filter/connectcontrol"
 	_ "github.com/megaease/easegress/pkg/filter/corsadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/extauth"
 	_ "github.com/megaease/easegress/pkg/filter/fallback"
 	_ "github.com/megaease/easegress/pkg/filter/headerlookup"
 	_ "github.com/megaease/easegress/pkg/filter/headertojson"
+	_ "github.com/megaease/easegress/pkg/filter/httpcache"
 	_ "github.com/megaease/easegress/pkg/filter/kafka"
 	_ "github.com/megaease/easegress/pkg/filter/kafkabackend"
 	_ "github.com/megaease/easegress/pkg/filter/meshadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/mirror"
 	_ "github.com/megaease/easegress/pkg/filter/mock"
 	_ "github.com/megaease/easegress/pkg/filter/mqttclientauth"
+	_ "github.com/megaease/easegress/pkg/filter/oidcauth"
 	_ "github.com/megaease/easegress/pkg/filter/proxy"
 	_ "github.com/megaease/easegress/pkg/filter/ratelimiter"
 	_ "github.com/megaease/easegress/pkg/filter/remotefilter"
@@ -41,6 +46,7 @@ import (
 	_ "github.com/megaease/easegress/pkg/filter/retryer"
 	_ "github.com/megaease/easegress/pkg/filter/timelimiter"
 	_ "github.com/megaease/easegress/pkg/filter/validator"
+	_ "github.com/megaease/easegress/pkg/filter/waf"
 	_ "github.com/megaease/easegress/pkg/filter/wasmhost"
 
 	// Objects
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	otlpexporter "go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/tracing/base"
+)
+
+const (
+	// ProtocolGRPC exports spans by OTLP/gRPC.
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTP exports spans by OTLP/HTTP.
+	ProtocolHTTP = "http"
+
+	defaultBatchSize     = 512
+	defaultBatchTimeout  = 5 * time.Second
+	defaultMaxQueueSize  = 2048
+	defaultExportTimeout = 10 * time.Second
+
+	shutdownTimeout = 5 * time.Second
+)
+
+type (
+	// Spec describes OpenTelemetry OTLP exporter.
+	Spec struct {
+		Protocol string `yaml:"protocol,omitempty" jsonschema:"omitempty,enum=grpc,enum=http"`
+		// Endpoint is the host:port of the collector.
+		Endpoint string `yaml:"endpoint" jsonschema:"required"`
+		// URLPath is the path of the collector, only for protocol http.
+		URLPath  string            `yaml:"urlPath,omitempty" jsonschema:"omitempty,pattern=^/"`
+		Insecure bool              `yaml:"insecure" jsonschema:"omitempty"`
+		Headers  map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Timeout  string            `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+
+		SampleRate   float64 `yaml:"sampleRate" jsonschema:"required,minimum=0,maximum=1"`
+		BatchSize    int     `yaml:"batchSize,omitempty" jsonschema:"omitempty,minimum=1"`
+		BatchTimeout string  `yaml:"batchTimeout,omitempty" jsonschema:"omitempty,format=duration"`
+		MaxQueueSize int     `yaml:"maxQueueSize,omitempty" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// cancellableExporter drops the cancelled spans.
+	cancellableExporter struct {
+		sdktrace.SpanExporter
+	}
+
+	shutdownCloser struct {
+		provider *sdktrace.TracerProvider
+	}
+
+	errorHandler struct{}
+)
+
+func init() {
+	otel.SetErrorHandler(errorHandler{})
+}
+
+func (errorHandler) Handle(err error) {
+	logger.Errorf("opentelemetry error: %v", err)
+}
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	batchSize, maxQueueSize := defaultBatchSize, defaultMaxQueueSize
+	if spec.BatchSize > 0 {
+		batchSize = spec.BatchSize
+	}
+	if spec.MaxQueueSize > 0 {
+		maxQueueSize = spec.MaxQueueSize
+	}
+	if batchSize > maxQueueSize {
+		return fmt.Errorf("batchSize(%d) is greater than maxQueueSize(%d)", batchSize, maxQueueSize)
+	}
+
+	return nil
+}
+
+func (ce *cancellableExporter) ExportSpans(ctx context.Context, ss []*sdktrace.SpanSnapshot) error {
+	spans := make([]*sdktrace.SpanSnapshot, 0, len(ss))
+	for _, s := range ss {
+		if !isCancelled(s) {
+			spans = append(spans, s)
+		}
+	}
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	return ce.SpanExporter.ExportSpans(ctx, spans)
+}
+
+func isCancelled(s *sdktrace.SpanSnapshot) bool {
+	for _, kv := range s.Attributes {
+		if kv.Key == base.CancelTagKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc *shutdownCloser) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return sc.provider.Shutdown(ctx)
+}
+
+func (spec *Spec) newDriver() otlpexporter.ProtocolDriver {
+	timeout := defaultExportTimeout
+	if d, err := time.ParseDuration(spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	if spec.Protocol == ProtocolHTTP {
+		opts := []otlphttp.Option{
+			otlphttp.WithEndpoint(spec.Endpoint),
+			otlphttp.WithTimeout(timeout),
+		}
+		if spec.URLPath != "" {
+			opts = append(opts, otlphttp.WithTracesURLPath(spec.URLPath))
+		}
+		if spec.Insecure {
+			opts = append(opts, otlphttp.WithInsecure())
+		}
+		if len(spec.Headers) > 0 {
+			opts = append(opts, otlphttp.WithHeaders(spec.Headers))
+		}
+		return otlphttp.NewDriver(opts...)
+	}
+
+	opts := []otlpgrpc.Option{
+		otlpgrpc.WithEndpoint(spec.Endpoint),
+		otlpgrpc.WithTimeout(timeout),
+	}
+	if spec.Insecure {
+		opts = append(opts, otlpgrpc.WithInsecure())
+	} else {
+		opts = append(opts, otlpgrpc.WithTLSCredentials(credentials.NewTLS(&tls.Config{})))
+	}
+	if len(spec.Headers) > 0 {
+		opts = append(opts, otlpgrpc.WithHeaders(spec.Headers))
+	}
+	return otlpgrpc.NewDriver(opts...)
+}
+
+// New creates OpenTelemetry tracer exporting spans by OTLP.
+func New(serviceName string, spec *Spec) (opentracing.Tracer, io.Closer, error) {
+	exporter, err := otlpexporter.NewExporter(context.Background(), spec.newDriver())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batchOpts := []sdktrace.BatchSpanProcessorOption{
+		sdktrace.WithMaxExportBatchSize(defaultBatchSize),
+		sdktrace.WithBatchTimeout(defaultBatchTimeout),
+		sdktrace.WithMaxQueueSize(defaultMaxQueueSize),
+	}
+	if spec.BatchSize > 0 {
+		batchOpts = append(batchOpts, sdktrace.WithMaxExportBatchSize(spec.BatchSize))
+	}
+	if d, err := time.ParseDuration(spec.BatchTimeout); err == nil && d > 0 {
+		batchOpts = append(batchOpts, sdktrace.WithBatchTimeout(d))
+	}
+	if spec.MaxQueueSize > 0 {
+		batchOpts = append(batchOpts, sdktrace.WithMaxQueueSize(spec.MaxQueueSize))
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(&cancellableExporter{SpanExporter: exporter}, batchOpts...),
+		// NOTE: The sampling decision of the upstream is respected.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(spec.SampleRate))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+	)
+
+	return newTracer(provider.Tracer("easegress")), &shutdownCloser{provider: provider}, nil
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/megaease/easegress/pkg/tracing/base"
+)
+
+func newTestTracer() (*tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(&cancellableExporter{SpanExporter: exporter}),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	return newTracer(provider.Tracer("test")), exporter
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{Endpoint: "127.0.0.1:4317"}
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+
+	spec.BatchSize = 4096
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
+
+func TestSpan(t *testing.T) {
+	tracer, exporter := newTestTracer()
+
+	parent := tracer.StartSpan("parent")
+	parent.SetTag("http.method", "GET")
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	child.LogKV("event", "retry", "attempt", 2)
+	child.Finish()
+
+	cancelled := tracer.StartSpan("cancelled", opentracing.ChildOf(parent.Context()))
+	cancelled.SetTag(base.CancelTagKey, "yes")
+	cancelled.Finish()
+
+	parent.Finish()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, but got %d", len(spans))
+	}
+
+	c, p := spans[0], spans[1]
+	if c.Name != "child" || p.Name != "parent" {
+		t.Fatalf("unexpected spans: %s, %s", c.Name, p.Name)
+	}
+	if c.Parent.SpanID() != p.SpanContext.SpanID() || c.SpanContext.TraceID() != p.SpanContext.TraceID() {
+		t.Errorf("child should be in the trace of parent")
+	}
+	if len(c.MessageEvents) != 1 || c.MessageEvents[0].Name != "retry" {
+		t.Errorf("unexpected events: %+v", c.MessageEvents)
+	}
+	if len(p.Attributes) != 1 || p.Attributes[0].Value.AsString() != "GET" {
+		t.Errorf("unexpected attributes: %+v", p.Attributes)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	tracer, _ := newTestTracer()
+
+	span := tracer.StartSpan("span")
+	header := http.Header{}
+	err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := span.Context().(spanContext)
+	traceparent := header.Get("traceparent")
+	if !strings.Contains(traceparent, sc.TraceID().String()) || !strings.Contains(traceparent, sc.SpanID().String()) {
+		t.Errorf("unexpected traceparent: %s", traceparent)
+	}
+	if header.Get("X-B3-Traceid") != sc.TraceID().String() || header.Get("X-B3-Spanid") != sc.SpanID().String() {
+		t.Errorf("unexpected b3 headers: %v", header)
+	}
+
+	// W3C trace context with trace state.
+	header = http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set("tracestate", "vendor=value")
+	header.Set("X-B3-Traceid", "80f198ee56343ba864fe8b2a57d3eff7")
+	header.Set("X-B3-Spanid", "e457b5a2e4d86bd1")
+	parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	psc := parent.(spanContext)
+	if psc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !psc.IsSampled() || !psc.IsRemote() {
+		t.Errorf("unexpected span context: %+v", psc)
+	}
+
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent))
+	header = http.Header{}
+	tracer.Inject(child.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if header.Get("tracestate") != "vendor=value" {
+		t.Errorf("tracestate should be propagated, but got %q", header.Get("tracestate"))
+	}
+
+	// B3 only.
+	header = http.Header{}
+	header.Set("X-B3-Traceid", "80f198ee56343ba864fe8b2a57d3eff7")
+	header.Set("X-B3-Spanid", "e457b5a2e4d86bd1")
+	header.Set("X-B3-Sampled", "1")
+	parent, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	psc = parent.(spanContext)
+	if psc.TraceID().String() != "80f198ee56343ba864fe8b2a57d3eff7" || psc.SpanID().String() != "e457b5a2e4d86bd1" || !psc.IsSampled() {
+		t.Errorf("unexpected span context: %+v", psc)
+	}
+
+	// nothing.
+	_, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{}))
+	if err != opentracing.ErrSpanContextNotFound {
+		t.Errorf("expected ErrSpanContextNotFound, but got %v", err)
+	}
+}
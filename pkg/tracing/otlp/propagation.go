@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	zipkingomodel "github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// inject injects the span context in both W3C Trace Context
+// (traceparent/tracestate) and B3 headers, so that upstreams
+// supporting either of them could join the trace.
+func inject(sc trace.SpanContext, writer opentracing.TextMapWriter) {
+	header := http.Header{}
+
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+	b3.InjectHTTP(&http.Request{Header: header})(toB3(sc))
+
+	for k := range header {
+		writer.Set(k, header.Get(k))
+	}
+}
+
+// extract extracts the span context from W3C Trace Context headers,
+// and falls back to B3 headers if there's no valid traceparent.
+func extract(reader opentracing.TextMapReader) (trace.SpanContext, error) {
+	header := http.Header{}
+	err := reader.ForeachKey(func(key, val string) error {
+		header.Set(key, val)
+		return nil
+	})
+	if err != nil {
+		return trace.SpanContext{}, err
+	}
+
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(header))
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc, nil
+	}
+
+	b3sc, err := b3.ExtractHTTP(&http.Request{Header: header})()
+	if err != nil {
+		return trace.SpanContext{}, err
+	}
+	if b3sc == nil || b3sc.TraceID.Empty() {
+		return trace.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	return fromB3(b3sc), nil
+}
+
+func toB3(sc trace.SpanContext) zipkingomodel.SpanContext {
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	sampled := sc.IsSampled()
+
+	return zipkingomodel.SpanContext{
+		TraceID: zipkingomodel.TraceID{
+			High: binary.BigEndian.Uint64(traceID[:8]),
+			Low:  binary.BigEndian.Uint64(traceID[8:]),
+		},
+		ID:      zipkingomodel.ID(binary.BigEndian.Uint64(spanID[:])),
+		Sampled: &sampled,
+	}
+}
+
+func fromB3(sc *zipkingomodel.SpanContext) trace.SpanContext {
+	var traceID trace.TraceID
+	binary.BigEndian.PutUint64(traceID[:8], sc.TraceID.High)
+	binary.BigEndian.PutUint64(traceID[8:], sc.TraceID.Low)
+
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], uint64(sc.ID))
+
+	var flags trace.TraceFlags
+	if sc.Debug || (sc.Sampled != nil && *sc.Sampled) {
+		flags = trace.FlagsSampled
+	}
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		Remote:     true,
+	})
+}
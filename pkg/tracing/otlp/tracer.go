@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// tracer adapts OpenTelemetry tracer to opentracing.Tracer,
+	// which is the interface used by the rest of Easegress.
+	tracer struct {
+		tracer trace.Tracer
+	}
+
+	span struct {
+		tracer *tracer
+		span   trace.Span
+	}
+
+	spanContext struct {
+		trace.SpanContext
+	}
+)
+
+func newTracer(t trace.Tracer) *tracer {
+	return &tracer{tracer: t}
+}
+
+// ForeachBaggageItem implements opentracing.SpanContext,
+// baggage is not supported.
+func (sc spanContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+
+// StartSpan implements opentracing.Tracer.
+func (t *tracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	sso := opentracing.StartSpanOptions{}
+	for _, opt := range opts {
+		opt.Apply(&sso)
+	}
+
+	ctx := context.Background()
+	for _, ref := range sso.References {
+		if sc, ok := ref.ReferencedContext.(spanContext); ok && sc.IsValid() {
+			ctx = trace.ContextWithSpanContext(ctx, sc.SpanContext)
+			break
+		}
+	}
+
+	spanOpts := []trace.SpanOption{}
+	if !sso.StartTime.IsZero() {
+		spanOpts = append(spanOpts, trace.WithTimestamp(sso.StartTime))
+	}
+	if len(sso.Tags) > 0 {
+		attrs := make([]attribute.KeyValue, 0, len(sso.Tags))
+		for k, v := range sso.Tags {
+			if k == string(ext.SpanKind) {
+				spanOpts = append(spanOpts, trace.WithSpanKind(spanKind(v)))
+				continue
+			}
+			attrs = append(attrs, toAttribute(k, v))
+		}
+		spanOpts = append(spanOpts, trace.WithAttributes(attrs...))
+	}
+
+	_, s := t.tracer.Start(ctx, operationName, spanOpts...)
+	return &span{tracer: t, span: s}
+}
+
+// Inject implements opentracing.Tracer.
+func (t *tracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	c, ok := sc.(spanContext)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+
+	if format != opentracing.HTTPHeaders && format != opentracing.TextMap {
+		return opentracing.ErrUnsupportedFormat
+	}
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	inject(c.SpanContext, writer)
+	return nil
+}
+
+// Extract implements opentracing.Tracer.
+func (t *tracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	if format != opentracing.HTTPHeaders && format != opentracing.TextMap {
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+
+	sc, err := extract(reader)
+	if err != nil {
+		return nil, err
+	}
+	return spanContext{SpanContext: sc}, nil
+}
+
+func (s *span) Finish() {
+	s.span.End()
+}
+
+func (s *span) FinishWithOptions(opts opentracing.FinishOptions) {
+	for _, record := range opts.LogRecords {
+		s.logFields(record.Timestamp, record.Fields...)
+	}
+	for _, data := range opts.BulkLogData {
+		record := data.ToLogRecord()
+		s.logFields(record.Timestamp, record.Fields...)
+	}
+
+	if opts.FinishTime.IsZero() {
+		s.span.End()
+		return
+	}
+	s.span.End(trace.WithTimestamp(opts.FinishTime))
+}
+
+func (s *span) Context() opentracing.SpanContext {
+	return spanContext{SpanContext: s.span.SpanContext()}
+}
+
+func (s *span) SetOperationName(operationName string) opentracing.Span {
+	s.span.SetName(operationName)
+	return s
+}
+
+func (s *span) SetTag(key string, value interface{}) opentracing.Span {
+	if key == string(ext.Error) {
+		if b, ok := value.(bool); ok && b {
+			s.span.SetStatus(codes.Error, "")
+		}
+		return s
+	}
+
+	s.span.SetAttributes(toAttribute(key, value))
+	return s
+}
+
+func (s *span) LogFields(fields ...log.Field) {
+	s.logFields(time.Time{}, fields...)
+}
+
+func (s *span) logFields(timestamp time.Time, fields ...log.Field) {
+	name := "log"
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for _, field := range fields {
+		if field.Key() == "event" {
+			name = fmt.Sprint(field.Value())
+			continue
+		}
+		attrs = append(attrs, toAttribute(field.Key(), field.Value()))
+	}
+
+	opts := []trace.EventOption{trace.WithAttributes(attrs...)}
+	if !timestamp.IsZero() {
+		opts = append(opts, trace.WithTimestamp(timestamp))
+	}
+	s.span.AddEvent(name, opts...)
+}
+
+func (s *span) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := log.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		s.LogFields(log.Error(err), log.String("function", "LogKV"))
+		return
+	}
+	s.LogFields(fields...)
+}
+
+func (s *span) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	return s
+}
+
+func (s *span) BaggageItem(restrictedKey string) string {
+	return ""
+}
+
+func (s *span) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *span) LogEvent(event string) {
+	s.LogFields(log.String("event", event))
+}
+
+func (s *span) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(log.String("event", event), log.Object("payload", payload))
+}
+
+func (s *span) Log(data opentracing.LogData) {
+	record := data.ToLogRecord()
+	s.logFields(record.Timestamp, record.Fields...)
+}
+
+func toAttribute(key string, value interface{}) attribute.KeyValue {
+	if err, ok := value.(error); ok {
+		return attribute.String(key, err.Error())
+	}
+	return attribute.Any(key, value)
+}
+
+func spanKind(value interface{}) trace.SpanKind {
+	switch fmt.Sprint(value) {
+	case string(ext.SpanKindRPCServerEnum):
+		return trace.SpanKindServer
+	case string(ext.SpanKindRPCClientEnum):
+		return trace.SpanKindClient
+	case string(ext.SpanKindProducerEnum):
+		return trace.SpanKindProducer
+	case string(ext.SpanKindConsumerEnum):
+		return trace.SpanKindConsumer
+	default:
+		return trace.SpanKindInternal
+	}
+}
@@ -18,6 +18,7 @@
 package tracing
 
 import (
+	"net/http"
 	"sync"
 	"time"
 
@@ -99,8 +100,23 @@ func NewSpanWithStart(tracer *Tracing, name string, startAt time.Time) Span {
 	return newSpanWithStart(tracer, name, startAt)
 }
 
-func newSpanWithStart(tracer *Tracing, name string, startAt time.Time) Span {
-	newSpan := tracer.StartSpan(name, opentracing.StartTime(startAt))
+// NewSpanWithHeader creates a span with specify start time, the span
+// joins the trace carried by header if there is one.
+func NewSpanWithHeader(tracer *Tracing, name string, startAt time.Time, header http.Header) Span {
+	if tracer.IsNoopTracer() {
+		return NoopSpan
+	}
+
+	parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	if err != nil || parent == nil {
+		return newSpanWithStart(tracer, name, startAt)
+	}
+	return newSpanWithStart(tracer, name, startAt, opentracing.ChildOf(parent))
+}
+
+func newSpanWithStart(tracer *Tracing, name string, startAt time.Time, opts ...opentracing.StartSpanOption) Span {
+	opts = append(opts, opentracing.StartTime(startAt))
+	newSpan := tracer.StartSpan(name, opts...)
 	for tagKey, tagValue := range tracer.tags {
 		newSpan.SetTag(tagKey, tagValue)
 	}
@@ -18,10 +18,12 @@
 package tracing
 
 import (
+	"fmt"
 	"io"
 
 	opentracing "github.com/opentracing/opentracing-go"
 
+	"github.com/megaease/easegress/pkg/tracing/otlp"
 	"github.com/megaease/easegress/pkg/tracing/zipkin"
 )
 
@@ -31,6 +33,7 @@ type (
 		ServiceName string            `yaml:"serviceName" jsonschema:"required"`
 		Tags        map[string]string `yaml:"tags" jsonschema:"omitempty"`
 		Zipkin      *zipkin.Spec      `yaml:"zipkin" jsonschema:"omitempty"`
+		OTLP        *otlp.Spec        `yaml:"otlp" jsonschema:"omitempty"`
 	}
 
 	// Tracing is the tracing.
@@ -49,13 +52,34 @@ var NoopTracing = &Tracing{
 	closer: nil,
 }
 
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.Zipkin == nil && spec.OTLP == nil {
+		return fmt.Errorf("neither zipkin nor otlp is specified")
+	}
+	if spec.Zipkin != nil && spec.OTLP != nil {
+		return fmt.Errorf("only one of zipkin and otlp can be specified")
+	}
+
+	return nil
+}
+
 // New creates a Tracing.
 func New(spec *Spec) (*Tracing, error) {
 	if spec == nil {
 		return NoopTracing, nil
 	}
 
-	tracer, closer, err := zipkin.New(spec.ServiceName, spec.Zipkin)
+	var (
+		tracer opentracing.Tracer
+		closer io.Closer
+		err    error
+	)
+	if spec.OTLP != nil {
+		tracer, closer, err = otlp.New(spec.ServiceName, spec.OTLP)
+	} else {
+		tracer, closer, err = zipkin.New(spec.ServiceName, spec.Zipkin)
+	}
 	if err != nil {
 		return nil, err
 	}
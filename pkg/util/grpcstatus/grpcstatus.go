@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package grpcstatus provides utilities for the status of gRPC, which
+// is carried by the headers or trailers of HTTP/2 responses.
+// Reference: https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+package grpcstatus
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// ContentType is the content type of gRPC.
+	ContentType = "application/grpc"
+
+	// HeaderStatus is the header (or trailer) of the gRPC status code.
+	HeaderStatus = "Grpc-Status"
+	// HeaderMessage is the header (or trailer) of the gRPC status message.
+	HeaderMessage = "Grpc-Message"
+)
+
+// IsGRPC returns whether the content type is gRPC, like
+// application/grpc and application/grpc+proto.
+func IsGRPC(contentType string) bool {
+	if !strings.HasPrefix(contentType, ContentType) {
+		return false
+	}
+	if len(contentType) == len(ContentType) {
+		return true
+	}
+	switch contentType[len(ContentType)] {
+	case '+', ';':
+		return true
+	default:
+		return false
+	}
+}
+
+// Code returns the gRPC status code in the header,
+// it returns false if there is no valid one.
+func Code(header http.Header) (codes.Code, bool) {
+	value := header.Get(HeaderStatus)
+	if value == "" {
+		return 0, false
+	}
+
+	code, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return codes.Code(code), true
+}
+
+// StatusCode returns the HTTP status code of a response for statistics,
+// it is converted from the gRPC status for gRPC responses.
+func StatusCode(statusCode int, header, trailer http.Header) int {
+	if statusCode != http.StatusOK || !IsGRPC(header.Get("Content-Type")) {
+		return statusCode
+	}
+
+	code, ok := Code(trailer)
+	if !ok {
+		// NOTE: The status is in the header of Trailers-Only responses.
+		code, ok = Code(header)
+	}
+	if !ok {
+		// NOTE: The stream is broken without status.
+		return http.StatusInternalServerError
+	}
+	return HTTPStatus(code)
+}
+
+// HTTPStatus converts the gRPC status code to the HTTP status code,
+// which is used in statistics.
+// Reference: https://github.com/grpc-ecosystem/grpc-gateway/blob/master/runtime/errors.go
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// FromHTTPStatus converts the HTTP status code to the gRPC status code,
+// it is the way gRPC clients handle responses without gRPC status.
+// Reference: https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+func FromHTTPStatus(status int) codes.Code {
+	switch status {
+	case http.StatusOK:
+		return codes.OK
+	case 499:
+		return codes.Canceled
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// HasCode returns whether the gRPC status code in the header is one of targets.
+func HasCode(header http.Header, targets []int) bool {
+	if len(targets) == 0 {
+		return false
+	}
+
+	code, ok := Code(header)
+	if !ok {
+		return false
+	}
+	for _, c := range targets {
+		if c >= 0 && codes.Code(c) == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcstatus
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestIsGRPC(t *testing.T) {
+	cases := map[string]bool{
+		"application/grpc":               true,
+		"application/grpc+proto":         true,
+		"application/grpc;charset=utf-8": true,
+		"application/grpc-web":           false,
+		"application/json":               false,
+		"":                               false,
+	}
+	for contentType, want := range cases {
+		if got := IsGRPC(contentType); got != want {
+			t.Errorf("IsGRPC(%q): want %v, got %v", contentType, want, got)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	header := http.Header{}
+	if _, ok := Code(header); ok {
+		t.Errorf("empty header should have no status")
+	}
+
+	header.Set(HeaderStatus, "x")
+	if _, ok := Code(header); ok {
+		t.Errorf("invalid status should be ignored")
+	}
+
+	header.Set(HeaderStatus, "14")
+	if code, ok := Code(header); !ok || code != codes.Unavailable {
+		t.Errorf("want Unavailable, got %v", code)
+	}
+
+	if !HasCode(header, []int{4, 14}) {
+		t.Errorf("header should have one of the codes")
+	}
+	if HasCode(header, []int{4}) || HasCode(header, nil) {
+		t.Errorf("header should have none of the codes")
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	header, trailer := http.Header{}, http.Header{}
+	if code := StatusCode(http.StatusOK, header, trailer); code != http.StatusOK {
+		t.Errorf("non-gRPC response: want 200, got %d", code)
+	}
+
+	header.Set("Content-Type", ContentType)
+	if code := StatusCode(http.StatusOK, header, trailer); code != http.StatusInternalServerError {
+		t.Errorf("gRPC response without status: want 500, got %d", code)
+	}
+	if code := StatusCode(http.StatusBadGateway, header, trailer); code != http.StatusBadGateway {
+		t.Errorf("HTTP error: want 502, got %d", code)
+	}
+
+	header.Set(HeaderStatus, "5")
+	if code := StatusCode(http.StatusOK, header, trailer); code != http.StatusNotFound {
+		t.Errorf("Trailers-Only response: want 404, got %d", code)
+	}
+
+	trailer.Set(HeaderStatus, "0")
+	if code := StatusCode(http.StatusOK, header, trailer); code != http.StatusOK {
+		t.Errorf("trailer should take precedence: want 200, got %d", code)
+	}
+}
+
+func TestFromHTTPStatus(t *testing.T) {
+	cases := map[int]codes.Code{
+		http.StatusOK:                 codes.OK,
+		http.StatusNotFound:           codes.Unimplemented,
+		http.StatusForbidden:          codes.PermissionDenied,
+		http.StatusTooManyRequests:    codes.Unavailable,
+		http.StatusServiceUnavailable: codes.Unavailable,
+		http.StatusTeapot:             codes.Unknown,
+	}
+	for status, want := range cases {
+		if got := FromHTTPStatus(status); got != want {
+			t.Errorf("FromHTTPStatus(%d): want %v, got %v", status, want, got)
+		}
+	}
+}
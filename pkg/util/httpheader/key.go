@@ -28,6 +28,26 @@ const (
 	KeyContentLength = "Content-Length"
 	// KeyVary is the key of Vary.
 	KeyVary = "Vary"
+	// KeyAge is the key of Age.
+	KeyAge = "Age"
+	// KeyDate is the key of Date.
+	KeyDate = "Date"
+	// KeyExpires is the key of Expires.
+	KeyExpires = "Expires"
+	// KeyETag is the key of ETag.
+	KeyETag = "Etag"
+	// KeyLastModified is the key of Last-Modified.
+	KeyLastModified = "Last-Modified"
+	// KeyIfNoneMatch is the key of If-None-Match.
+	KeyIfNoneMatch = "If-None-Match"
+	// KeyIfModifiedSince is the key of If-Modified-Since.
+	KeyIfModifiedSince = "If-Modified-Since"
+	// KeyAuthorization is the key of Authorization.
+	KeyAuthorization = "Authorization"
+	// KeySetCookie is the key of Set-Cookie.
+	KeySetCookie = "Set-Cookie"
+	// KeyLocation is the key of Location.
+	KeyLocation = "Location"
 
 	// KeyXForwardedFor is the key of X-Forwarded-For.
 	KeyXForwardedFor = "X-Forwarded-For"
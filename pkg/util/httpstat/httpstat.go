@@ -58,6 +58,9 @@ type (
 		respSize uint64
 
 		cc *codecounter.HTTPStatusCodeCounter
+		// totalCC is never reset, it is for the consumers
+		// which need monotonic counters, e.g. Prometheus.
+		totalCC *codecounter.HTTPStatusCodeCounter
 	}
 
 	// Metric is the package of statistics at once.
@@ -100,9 +103,18 @@ type (
 		RespSize uint64 `yaml:"respSize"`
 
 		Codes map[int]uint64 `yaml:"codes"`
+
+		// totalCodes is not in the status output, see TotalCodes.
+		totalCodes map[int]uint64
 	}
 )
 
+// TotalCodes returns the status codes counted since the HTTPStat was
+// created, unlike Codes, they are never reset.
+func (s *Status) TotalCodes() map[int]uint64 {
+	return s.totalCodes
+}
+
 func (m *Metric) isErr() bool {
 	return m.StatusCode >= 400
 }
@@ -121,7 +133,8 @@ func New() *HTTPStat {
 		min:             math.MaxUint64,
 		durationSampler: sampler.NewDurationSampler(),
 
-		cc: codecounter.New(),
+		cc:      codecounter.New(),
+		totalCC: codecounter.New(),
 	}
 
 	return hs
@@ -176,6 +189,7 @@ func (hs *HTTPStat) Stat(m *Metric) {
 	atomic.AddUint64(&hs.respSize, m.RespSize)
 
 	hs.cc.Count(m.StatusCode)
+	hs.totalCC.Count(m.StatusCode)
 }
 
 // Status returns HTTPStat Status, It assumes it is called every five seconds.
@@ -245,7 +259,8 @@ func (hs *HTTPStat) Status() *Status {
 		ReqSize:  hs.reqSize,
 		RespSize: hs.respSize,
 
-		Codes: codes,
+		Codes:      codes,
+		totalCodes: hs.totalCC.Codes(),
 	}
 
 	return status
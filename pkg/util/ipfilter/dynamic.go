@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/yl2chen/cidranger"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	customDataPrefix = "/custom-data/"
+
+	// fileReloadDelay is the delay to reload a file after its last change,
+	// so that a file being written is not loaded before it's complete.
+	fileReloadDelay = 500 * time.Millisecond
+)
+
+type (
+	// ipList is a list of IPs and CIDRs which could be updated at runtime.
+	ipList struct {
+		ranger atomic.Value // cidranger.Ranger
+	}
+
+	// ipRecord defines the format of IPs in etcd.
+	ipRecord struct {
+		Key string `yaml:"key"`
+		IP  string `yaml:"ip"`
+	}
+
+	// watcher watches the files and etcd prefixes of an IPFilter.
+	watcher struct {
+		stopCtx context.Context
+		cancel  context.CancelFunc
+
+		fsWatcher *fsnotify.Watcher
+		// files maps the file to its reload function.
+		files map[string]func()
+	}
+)
+
+func newIPList() *ipList {
+	l := &ipList{}
+	l.ranger.Store(cidranger.NewPCTrieRanger())
+	return l
+}
+
+func (l *ipList) contains(ip net.IP) bool {
+	ok, err := l.ranger.Load().(cidranger.Ranger).Contains(ip)
+	return err == nil && ok
+}
+
+func (l *ipList) update(ipcidrs []string, source string) {
+	ranger := cidranger.NewPCTrieRanger()
+	for _, ipcidr := range ipcidrs {
+		ipNet, err := parseIPCIDR(ipcidr)
+		if err != nil {
+			logger.Warnf("%s: %s is an invalid ip or cidr", source, ipcidr)
+			continue
+		}
+		ranger.Insert(cidranger.NewBasicRangerEntry(*ipNet))
+	}
+	l.ranger.Store(ranger)
+	logger.Infof("%s: %d ips or cidrs loaded", source, ranger.Len())
+}
+
+// readIPFile reads IPs and CIDRs from a file, one per line, empty lines
+// and lines starting with '#' are ignored.
+func readIPFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipcidrs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ipcidrs = append(ipcidrs, line)
+	}
+	return ipcidrs, scanner.Err()
+}
+
+func parseIPRecords(kvs map[string]string) []string {
+	ipcidrs := make([]string, 0, len(kvs))
+	for key, value := range kvs {
+		record := &ipRecord{}
+		if err := yaml.Unmarshal([]byte(value), record); err != nil {
+			logger.Warnf("parse ip record %s failed: %v", key, err)
+			continue
+		}
+		if record.IP != "" {
+			ipcidrs = append(ipcidrs, record.IP)
+		} else {
+			ipcidrs = append(ipcidrs, record.Key)
+		}
+	}
+	return ipcidrs
+}
+
+func newWatcher() *watcher {
+	stopCtx, cancel := context.WithCancel(context.Background())
+	return &watcher{
+		stopCtx: stopCtx,
+		cancel:  cancel,
+		files:   map[string]func(){},
+	}
+}
+
+// watchFile calls reload when the file changes. The directory of the file
+// is watched, because the file could be replaced by renaming.
+func (w *watcher) watchFile(path string, reload func()) {
+	path = filepath.Clean(path)
+	if w.fsWatcher == nil {
+		fsWatcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			logger.Errorf("create file watcher failed: %v", err)
+			return
+		}
+		w.fsWatcher = fsWatcher
+	}
+
+	if err := w.fsWatcher.Add(filepath.Dir(path)); err != nil {
+		logger.Errorf("watch file %s failed: %v", path, err)
+		return
+	}
+	w.files[path] = reload
+}
+
+// start starts watching the files, it must be called after all
+// files are added.
+func (w *watcher) start() {
+	if w.fsWatcher != nil {
+		go w.runFileWatcher()
+	}
+}
+
+func (w *watcher) runFileWatcher() {
+	// timers delay the reloading of files, every change of a file
+	// resets its timer.
+	timers := map[string]*time.Timer{}
+	defer func() {
+		for _, t := range timers {
+			t.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			// NOTE: Removed files are not reloaded, so that the list is
+			// kept when the file is being replaced.
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			path := filepath.Clean(event.Name)
+			reload := w.files[path]
+			if reload == nil {
+				continue
+			}
+			if t := timers[path]; t != nil {
+				t.Reset(fileReloadDelay)
+			} else {
+				timers[path] = time.AfterFunc(fileReloadDelay, reload)
+			}
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Errorf("file watcher error: %v", err)
+		}
+	}
+}
+
+// watchEtcdPrefix calls reload when the keys under the prefix change.
+func (w *watcher) watchEtcdPrefix(cls cluster.Cluster, prefix string, reload func(map[string]string)) {
+	var (
+		syncer *cluster.Syncer
+		err    error
+		ch     <-chan map[string]string
+	)
+
+	for {
+		syncer, err = cls.Syncer(30 * time.Minute)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.SyncPrefix(prefix); err != nil {
+			logger.Errorf("failed to sync prefix: %v", err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-w.stopCtx.Done():
+			return
+		}
+	}
+
+	defer syncer.Close()
+
+	for {
+		select {
+		case <-w.stopCtx.Done():
+			return
+		case kvs := <-ch:
+			reload(kvs)
+		}
+	}
+}
+
+func (w *watcher) close() {
+	w.cancel()
+	if w.fsWatcher != nil {
+		w.fsWatcher.Close()
+	}
+}
@@ -18,11 +18,14 @@
 package ipfilter
 
 import (
+	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 
 	"github.com/yl2chen/cidranger"
 
+	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 )
@@ -39,6 +42,28 @@ type (
 
 		AllowIPs []string `yaml:"allowIPs" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
 		BlockIPs []string `yaml:"blockIPs" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+
+		// AllowIPsFile and BlockIPsFile are files of IPs or CIDRs, one per
+		// line, lines starting with '#' are comments. They are reloaded
+		// when changed.
+		AllowIPsFile string `yaml:"allowIPsFile,omitempty" jsonschema:"omitempty"`
+		BlockIPsFile string `yaml:"blockIPsFile,omitempty" jsonschema:"omitempty"`
+
+		// AllowIPsEtcdPrefix and BlockIPsEtcdPrefix are prefixes of IPs in
+		// etcd, which are stored as:
+		// key: /custom-data/{etcdPrefix}/{$key}
+		// value:
+		//   key: "$key"
+		//   ip: "$ip" # optional, IP or CIDR, the value of "key" is used if empty
+		AllowIPsEtcdPrefix string `yaml:"allowIPsEtcdPrefix,omitempty" jsonschema:"omitempty"`
+		BlockIPsEtcdPrefix string `yaml:"blockIPsEtcdPrefix,omitempty" jsonschema:"omitempty"`
+
+		// GeoIPFile is a MaxMind DB file of countries, such as the GeoLite2
+		// Country database. It is reloaded when changed.
+		GeoIPFile string `yaml:"geoIPFile,omitempty" jsonschema:"omitempty"`
+		// AllowCountries and BlockCountries are ISO 3166-1 alpha-2 codes of countries.
+		AllowCountries []string `yaml:"allowCountries,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		BlockCountries []string `yaml:"blockCountries,omitempty" jsonschema:"omitempty,uniqueItems=true"`
 	}
 
 	// IPFilter is the IP filter.
@@ -47,6 +72,15 @@ type (
 
 		allowRanger cidranger.Ranger
 		blockRanger cidranger.Ranger
+
+		allowLists []*ipList
+		blockLists []*ipList
+
+		geoIP          atomic.Value // *geoIPDB
+		allowCountries map[string]bool
+		blockCountries map[string]bool
+
+		watcher *watcher
 	}
 
 	// IPFilters is the wrapper for multiple IPFilters.
@@ -55,24 +89,49 @@ type (
 	}
 )
 
-// New creates an IPFilter.
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if (len(spec.AllowCountries) > 0 || len(spec.BlockCountries) > 0) && spec.GeoIPFile == "" {
+		return fmt.Errorf("geoIPFile is required by allowCountries and blockCountries")
+	}
+	return nil
+}
+
+func parseIPCIDR(ipcidr string) (*net.IPNet, error) {
+	ip := net.ParseIP(ipcidr)
+	if ip != nil {
+		mask := allOnesIPv4Mask
+		// https://stackoverflow.com/a/48519490/1705845
+		if strings.Count(ipcidr, ":") >= 2 {
+			mask = allOnesIPv6Mask
+		}
+		return &net.IPNet{IP: ip, Mask: mask}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(ipcidr)
+	return ipNet, err
+}
+
+func countrySet(countries []string) map[string]bool {
+	set := make(map[string]bool, len(countries))
+	for _, c := range countries {
+		set[strings.ToUpper(c)] = true
+	}
+	return set
+}
+
+// New creates an IPFilter, the IPs in etcd are not supported.
 func New(spec *Spec) *IPFilter {
+	return NewWithCluster(spec, nil)
+}
+
+// NewWithCluster creates an IPFilter, the cluster is used to read IPs
+// in etcd. The IPFilter must be closed if it has dynamic sources.
+func NewWithCluster(spec *Spec, cls cluster.Cluster) *IPFilter {
 	rangerFromIPCIDRs := func(ipcidrs []string) cidranger.Ranger {
 		ranger := cidranger.NewPCTrieRanger()
 		for _, ipcidr := range ipcidrs {
-			ip := net.ParseIP(ipcidr)
-			if ip != nil {
-				mask := allOnesIPv4Mask
-				// https://stackoverflow.com/a/48519490/1705845
-				if strings.Count(ipcidr, ":") >= 2 {
-					mask = allOnesIPv6Mask
-				}
-				ipNet := net.IPNet{IP: ip, Mask: mask}
-				ranger.Insert(cidranger.NewBasicRangerEntry(ipNet))
-				continue
-			}
-
-			_, ipNet, err := net.ParseCIDR(ipcidr)
+			ipNet, err := parseIPCIDR(ipcidr)
 			if err != nil {
 				logger.Errorf("BUG: %s is an invalid ip or cidr", ipcidr)
 				continue
@@ -83,11 +142,99 @@ func New(spec *Spec) *IPFilter {
 		return ranger
 	}
 
-	return &IPFilter{
+	f := &IPFilter{
 		spec: spec,
 
 		allowRanger: rangerFromIPCIDRs(spec.AllowIPs),
 		blockRanger: rangerFromIPCIDRs(spec.BlockIPs),
+
+		allowCountries: countrySet(spec.AllowCountries),
+		blockCountries: countrySet(spec.BlockCountries),
+	}
+	f.geoIP.Store((*geoIPDB)(nil))
+
+	if spec.AllowIPsFile != "" || spec.BlockIPsFile != "" || spec.GeoIPFile != "" ||
+		spec.AllowIPsEtcdPrefix != "" || spec.BlockIPsEtcdPrefix != "" {
+		f.watcher = newWatcher()
+	}
+
+	if spec.AllowIPsFile != "" {
+		f.allowLists = append(f.allowLists, f.newFileList(spec.AllowIPsFile))
+	}
+	if spec.BlockIPsFile != "" {
+		f.blockLists = append(f.blockLists, f.newFileList(spec.BlockIPsFile))
+	}
+	if spec.AllowIPsEtcdPrefix != "" {
+		f.allowLists = append(f.allowLists, f.newEtcdList(cls, spec.AllowIPsEtcdPrefix))
+	}
+	if spec.BlockIPsEtcdPrefix != "" {
+		f.blockLists = append(f.blockLists, f.newEtcdList(cls, spec.BlockIPsEtcdPrefix))
+	}
+	if spec.GeoIPFile != "" {
+		f.loadGeoIP()
+		f.watcher.watchFile(spec.GeoIPFile, f.loadGeoIP)
+	}
+
+	if f.watcher != nil {
+		f.watcher.start()
+	}
+
+	return f
+}
+
+func (f *IPFilter) newFileList(path string) *ipList {
+	l := newIPList()
+	reload := func() {
+		ipcidrs, err := readIPFile(path)
+		if err != nil {
+			logger.Errorf("read ip file %s failed: %v", path, err)
+			return
+		}
+		l.update(ipcidrs, path)
+	}
+
+	reload()
+	f.watcher.watchFile(path, reload)
+	return l
+}
+
+func (f *IPFilter) newEtcdList(cls cluster.Cluster, etcdPrefix string) *ipList {
+	l := newIPList()
+	if cls == nil {
+		logger.Errorf("failed to read ips of %s from etcd: no cluster", etcdPrefix)
+		return l
+	}
+
+	prefix := customDataPrefix + strings.TrimPrefix(etcdPrefix, "/")
+	reload := func(kvs map[string]string) {
+		l.update(parseIPRecords(kvs), prefix)
+	}
+
+	kvs, err := cls.GetPrefix(prefix)
+	if err != nil {
+		logger.Errorf("get ips of %s failed: %v", prefix, err)
+	} else {
+		reload(kvs)
+	}
+	go f.watcher.watchEtcdPrefix(cls, prefix, reload)
+
+	return l
+}
+
+func (f *IPFilter) loadGeoIP() {
+	db, err := openGeoIPDB(f.spec.GeoIPFile)
+	if err != nil {
+		logger.Errorf("load GeoIP file %s failed: %v", f.spec.GeoIPFile, err)
+		return
+	}
+	f.geoIP.Store(db)
+	logger.Infof("GeoIP file %s loaded", f.spec.GeoIPFile)
+}
+
+// Close closes the IPFilter.
+func (f *IPFilter) Close() {
+	if f.watcher != nil {
+		f.watcher.close()
 	}
 }
 
@@ -115,6 +262,21 @@ func (f *IPFilter) Allow(ipstr string) bool {
 		return defaultResult
 	}
 
+	if !allowed {
+		allowed = anyContains(f.allowLists, ip)
+	}
+	if !blocked {
+		blocked = anyContains(f.blockLists, ip)
+	}
+
+	if len(f.allowCountries) > 0 || len(f.blockCountries) > 0 {
+		if db := f.geoIP.Load().(*geoIPDB); db != nil {
+			country := db.country(ip)
+			allowed = allowed || f.allowCountries[country]
+			blocked = blocked || f.blockCountries[country]
+		}
+	}
+
 	switch {
 	case allowed && blocked:
 		return defaultResult
@@ -127,6 +289,15 @@ func (f *IPFilter) Allow(ipstr string) bool {
 	}
 }
 
+func anyContains(lists []*ipList, ip net.IP) bool {
+	for _, l := range lists {
+		if l.contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewIPFilters creates an IPFilters
 func NewIPFilters(filters ...*IPFilter) *IPFilters {
 	return &IPFilters{filters: filters}
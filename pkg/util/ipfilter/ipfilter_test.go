@@ -0,0 +1,375 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+// mmdbMetadataMarker is the marker before the metadata of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// types of the MaxMind DB data section.
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbUint32  = 6
+	mmdbMap     = 7
+
+	// mmdbDataSeparatorSize is the size of the zeros between the search
+	// tree and the data section.
+	mmdbDataSeparatorSize = 16
+)
+
+// testMMDB builds IPv4 MaxMind DBs of record size 24 for testing.
+type testMMDB struct {
+	// records are node indexes, or data offsets if isData is true,
+	// -1 means empty.
+	records [][2]int
+	isData  [][2]bool
+	data    []byte
+}
+
+func newTestMMDB() *testMMDB {
+	db := &testMMDB{}
+	db.newNode()
+	return db
+}
+
+func (db *testMMDB) newNode() int {
+	db.records = append(db.records, [2]int{-1, -1})
+	db.isData = append(db.isData, [2]bool{})
+	return len(db.records) - 1
+}
+
+func (db *testMMDB) insert(cidr string, dataOffset int) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ip := ipNet.IP.To4()
+	ones, _ := ipNet.Mask.Size()
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i>>3]>>(7-uint(i&7))) & 1
+		if i == ones-1 {
+			db.records[node][bit], db.isData[node][bit] = dataOffset, true
+			return
+		}
+		if db.records[node][bit] < 0 {
+			child := db.newNode()
+			db.records[node][bit] = child
+		}
+		node = db.records[node][bit]
+	}
+}
+
+func mmdbCtrl(typ, size int) []byte {
+	return []byte{byte(typ<<5 | size)}
+}
+
+func mmdbStringValue(s string) []byte {
+	return append(mmdbCtrl(mmdbString, len(s)), s...)
+}
+
+func mmdbUint32Value(n uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, n)
+	return append(mmdbCtrl(mmdbUint32, 4), buf...)
+}
+
+func mmdbPointerTo(off int) []byte {
+	return []byte{byte(mmdbPointer<<5 | (off>>8)&0x7), byte(off)}
+}
+
+// addData appends the encoded value to the data section, returns its offset.
+func (db *testMMDB) addData(value ...[]byte) int {
+	off := len(db.data)
+	for _, v := range value {
+		db.data = append(db.data, v...)
+	}
+	return off
+}
+
+func (db *testMMDB) bytes() []byte {
+	nodeCount := len(db.records)
+	buf := []byte{}
+	for i, records := range db.records {
+		for bit, record := range records {
+			value := nodeCount
+			if db.isData[i][bit] {
+				value = nodeCount + mmdbDataSeparatorSize + record
+			} else if record >= 0 {
+				value = record
+			}
+			buf = append(buf, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+
+	buf = append(buf, make([]byte, mmdbDataSeparatorSize)...)
+	buf = append(buf, db.data...)
+	buf = append(buf, mmdbMetadataMarker...)
+	buf = append(buf, mmdbCtrl(mmdbMap, 3)...)
+	buf = append(buf, mmdbStringValue("node_count")...)
+	buf = append(buf, mmdbUint32Value(uint32(nodeCount))...)
+	buf = append(buf, mmdbStringValue("record_size")...)
+	buf = append(buf, mmdbUint32Value(24)...)
+	buf = append(buf, mmdbStringValue("ip_version")...)
+	buf = append(buf, mmdbUint32Value(4)...)
+	return buf
+}
+
+func countryRecord(key, isoCode string) [][]byte {
+	return [][]byte{
+		mmdbCtrl(mmdbMap, 1),
+		mmdbStringValue(key),
+		mmdbCtrl(mmdbMap, 1),
+		mmdbStringValue("iso_code"),
+		mmdbStringValue(isoCode),
+	}
+}
+
+func buildTestMMDB() []byte {
+	db := newTestMMDB()
+
+	us := db.addData(countryRecord("country", "US")...)
+	db.insert("1.0.0.0/8", us)
+
+	cnMap := db.addData(mmdbCtrl(mmdbMap, 1), mmdbStringValue("iso_code"), mmdbStringValue("CN"))
+	// registered_country only, and its value is a pointer.
+	cn := db.addData(mmdbCtrl(mmdbMap, 1), mmdbStringValue("registered_country"), mmdbPointerTo(cnMap))
+	db.insert("2.2.0.0/16", cn)
+
+	return db.bytes()
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := Spec{BlockCountries: []string{"US"}}
+	if spec.Validate() == nil {
+		t.Errorf("validate should fail without geoIPFile")
+	}
+
+	spec.GeoIPFile = "GeoLite2-Country.mmdb"
+	if spec.Validate() != nil {
+		t.Errorf("validate should succeed")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	f := New(&Spec{
+		AllowIPs: []string{"192.168.1.0/24"},
+		BlockIPs: []string{"192.168.0.0/16", "10.0.0.1"},
+	})
+
+	cases := map[string]bool{
+		"192.168.1.1": true,
+		"192.168.2.1": false,
+		"10.0.0.1":    false,
+		"10.0.0.2":    true,
+		"invalid":     true,
+	}
+	for ip, expected := range cases {
+		if f.Allow(ip) != expected {
+			t.Errorf("allow %s should be %v", ip, expected)
+		}
+	}
+
+	f = New(&Spec{BlockByDefault: true, AllowIPs: []string{"10.0.0.1"}})
+	if !f.Allow("10.0.0.1") || f.Allow("10.0.0.2") {
+		t.Errorf("only 10.0.0.1 should be allowed")
+	}
+}
+
+func TestGeoIPDB(t *testing.T) {
+	db, err := newGeoIPDB(buildTestMMDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"1.2.3.4":     "US",
+		"2.2.3.4":     "CN",
+		"2.3.3.4":     "",
+		"3.0.0.1":     "",
+		"2001:db8::1": "",
+	}
+	for ip, expected := range cases {
+		if c := db.country(net.ParseIP(ip)); c != expected {
+			t.Errorf("country of %s should be %q, but got %q", ip, expected, c)
+		}
+	}
+
+	if _, err := newGeoIPDB([]byte("invalid")); err == nil {
+		t.Errorf("invalid MaxMind DB should fail")
+	}
+}
+
+func TestGeoIPDBMalformed(t *testing.T) {
+	mdb := newTestMMDB()
+	// a map whose value points to the map itself.
+	off := len(mdb.data)
+	cyclic := mdb.addData(mmdbCtrl(mmdbMap, 1), mmdbStringValue("country"), mmdbPointerTo(off))
+	mdb.insert("1.0.0.0/8", cyclic)
+
+	db, err := newGeoIPDB(mdb.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := db.country(net.ParseIP("1.2.3.4")); c != "" {
+		t.Errorf("country of malformed record should be empty, but got %q", c)
+	}
+}
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for i := 0; i < 50; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("timeout waiting for %s", msg)
+}
+
+func TestFileList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfilter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blockFile := filepath.Join(dir, "block.txt")
+	err = ioutil.WriteFile(blockFile, []byte("# blocked\n10.0.0.0/8\n\n192.168.0.1\ninvalid\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geoIPFile := filepath.Join(dir, "country.mmdb")
+	if err = ioutil.WriteFile(geoIPFile, buildTestMMDB(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(&Spec{
+		BlockIPsFile:   blockFile,
+		AllowIPsFile:   filepath.Join(dir, "not-exist.txt"),
+		GeoIPFile:      geoIPFile,
+		BlockCountries: []string{"us"},
+	})
+	defer f.Close()
+
+	cases := map[string]bool{
+		"10.1.1.1":    false,
+		"192.168.0.1": false,
+		"192.168.0.2": true,
+		"1.1.1.1":     false,
+		"2.2.2.2":     true,
+	}
+	for ip, expected := range cases {
+		if f.Allow(ip) != expected {
+			t.Errorf("allow %s should be %v", ip, expected)
+		}
+	}
+
+	if err = ioutil.WriteFile(blockFile, []byte("192.168.0.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "block file reloaded", func() bool {
+		return f.Allow("10.1.1.1") && !f.Allow("192.168.0.2")
+	})
+}
+
+func TestFileReloadDelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfilter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "ips.txt")
+	if err = ioutil.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reloads int32
+	w := newWatcher()
+	defer w.close()
+	w.watchFile(file, func() {
+		atomic.AddInt32(&reloads, 1)
+	})
+	w.start()
+
+	// the file is written in several parts.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"10.0.", "0.1\n", "10.0.0.2\n"} {
+		f.WriteString(part)
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.Close()
+
+	time.Sleep(fileReloadDelay + 500*time.Millisecond)
+	if n := atomic.LoadInt32(&reloads); n != 1 {
+		t.Errorf("file should be reloaded once, but got %d", n)
+	}
+}
+
+func TestEtcdList(t *testing.T) {
+	etcdDirName, err := ioutil.TempDir("", "etcd-ipfilter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(etcdDirName)
+	clusterInstance := cluster.CreateClusterForTest(etcdDirName)
+
+	clusterInstance.Put("/custom-data/blocked-ips/1", "key: 10.0.0.1\n")
+	clusterInstance.Put("/custom-data/blocked-ips/2", "key: office\nip: 172.16.0.0/12\n")
+
+	f := NewWithCluster(&Spec{BlockIPsEtcdPrefix: "/blocked-ips/"}, clusterInstance)
+
+	if f.Allow("10.0.0.1") || f.Allow("172.16.1.1") || !f.Allow("10.0.0.2") {
+		t.Errorf("ips in etcd should be blocked")
+	}
+
+	clusterInstance.Put("/custom-data/blocked-ips/3", "key: 10.0.0.2\n")
+	clusterInstance.Delete("/custom-data/blocked-ips/1")
+	waitFor(t, "etcd list reloaded", func() bool {
+		return f.Allow("10.0.0.1") && !f.Allow("10.0.0.2")
+	})
+
+	f.Close()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	clusterInstance.CloseServer(wg)
+	wg.Wait()
+}
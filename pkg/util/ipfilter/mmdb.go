@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"net"
+	"os"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type (
+	// geoIPDB looks up the countries of IPs in MaxMind DB files, such as
+	// the GeoLite2 and GeoIP2 databases.
+	geoIPDB struct {
+		reader *maxminddb.Reader
+	}
+
+	// geoIPRecord only has the fields used by the IPFilter, other fields
+	// of records are skipped by the decoder.
+	geoIPRecord struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+)
+
+// openGeoIPDB reads the whole file into memory, so that the file could
+// be replaced while it's in use.
+func openGeoIPDB(path string) (*geoIPDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoIPDB(buf)
+}
+
+func newGeoIPDB(buf []byte) (*geoIPDB, error) {
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &geoIPDB{reader: reader}, nil
+}
+
+// country returns the ISO 3166-1 alpha-2 code of the country of the ip,
+// the registered country is used if the country is unknown.
+func (db *geoIPDB) country(ip net.IP) string {
+	if ip.To4() == nil && db.reader.Metadata.IPVersion == 4 {
+		return ""
+	}
+
+	record := &geoIPRecord{}
+	if err := db.reader.Lookup(ip, record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	return record.RegisteredCountry.ISOCode
+}
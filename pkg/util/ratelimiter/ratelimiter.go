@@ -183,6 +183,43 @@ func (rl *RateLimiter) acquirePermission(count int) (bool, time.Duration) {
 	return true, timeToWait
 }
 
+// Remaining returns the number of tokens could be permitted without waiting
+// in current cycle, and the duration to wait before another token could be
+// permitted(including reserved) if the rate limiter is rejecting requests.
+func (rl *RateLimiter) Remaining() (int, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rl.state == StateDisabled {
+		return rl.policy.LimitForPeriod, 0
+	}
+
+	now := nowFunc()
+
+	maxTokens := rl.policy.LimitForPeriod
+	maxTokens *= int(rl.policy.TimeoutDuration/rl.policy.LimitRefreshPeriod) + 1
+
+	cycle := int(now.Sub(rl.startTime) / rl.policy.LimitRefreshPeriod)
+	tokens := rl.tokens - (cycle-rl.cycle)*rl.policy.LimitForPeriod
+	if tokens < 0 {
+		tokens = 0
+	}
+
+	remaining := rl.policy.LimitForPeriod - tokens
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	if tokens < maxTokens {
+		return remaining, 0
+	}
+
+	// the first cycle in which the permitted tokens are less than maxTokens
+	cycle += (tokens-maxTokens)/rl.policy.LimitForPeriod + 1
+	d := rl.policy.LimitRefreshPeriod * time.Duration(cycle)
+	return remaining, rl.startTime.Add(d).Sub(now)
+}
+
 // AcquirePermission acquires a permission from the rate limiter.
 // returns true if the request is permitted and false otherwise.
 // when permitted, the caller should wait returned duration before action.
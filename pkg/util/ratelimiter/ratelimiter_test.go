@@ -246,3 +246,36 @@ func TestRateLimiterN(t *testing.T) {
 	}
 	limiter.SetState(StateDisabled)
 }
+
+func TestRemaining(t *testing.T) {
+	policy := NewPolicy(50*time.Millisecond, 10*time.Millisecond, 5)
+	limiter := New(policy)
+
+	if remaining, d := limiter.Remaining(); remaining != 5 || d != 0 {
+		t.Errorf("unexpected remaining %d, duration %s", remaining, d)
+	}
+
+	for i := 0; i < 3; i++ {
+		limiter.AcquirePermission()
+	}
+	if remaining, d := limiter.Remaining(); remaining != 2 || d != 0 {
+		t.Errorf("unexpected remaining %d, duration %s", remaining, d)
+	}
+
+	for i := 0; i < 27; i++ {
+		limiter.AcquirePermission()
+	}
+	if remaining, d := limiter.Remaining(); remaining != 0 || d != policy.LimitRefreshPeriod {
+		t.Errorf("unexpected remaining %d, duration %s", remaining, d)
+	}
+
+	now = now.Add(policy.LimitRefreshPeriod)
+	if remaining, d := limiter.Remaining(); remaining != 0 || d != 0 {
+		t.Errorf("unexpected remaining %d, duration %s", remaining, d)
+	}
+
+	limiter.SetState(StateDisabled)
+	if remaining, d := limiter.Remaining(); remaining != 5 || d != 0 {
+		t.Errorf("unexpected remaining %d, duration %s", remaining, d)
+	}
+}
@@ -209,6 +209,11 @@ func (signer *Signer) SetLiteral(literal *Literal) *Signer {
 	return signer
 }
 
+// Literal returns the literals of the Signer
+func (signer *Signer) Literal() *Literal {
+	return signer.literal
+}
+
 // ExcludeBody is an option function for Signer to exclude body from signature
 func (signer *Signer) ExcludeBody(exclude bool) *Signer {
 	signer.excludeBody = exclude
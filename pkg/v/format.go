@@ -34,6 +34,7 @@ var (
 		"httpmethod-array": httpMethodArray,
 		"httpcode":         httpCode,
 		"httpcode-array":   httpCodeArray,
+		"grpccode-array":   grpcCodeArray,
 		"timerfc3339":      timerfc3339,
 		"duration":         duration,
 		"ipcidr":           ipcidr,
@@ -125,6 +126,26 @@ func httpCodeArray(v interface{}) error {
 	return nil
 }
 
+func grpcCode(v interface{}) error {
+	code := v.(int)
+	// Reference: https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+	if code < 0 || code > 16 {
+		return fmt.Errorf("invalid grpc code")
+	}
+	return nil
+}
+
+func grpcCodeArray(v interface{}) error {
+	for _, code := range v.([]int) {
+		err := grpcCode(code)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func timerfc3339(v interface{}) error {
 	s := v.(string)
 	_, err := time.Parse(time.RFC3339, s)